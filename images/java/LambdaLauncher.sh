@@ -11,12 +11,24 @@ contains() {
 }
 
 if contains "$1" ".jar" || contains "$1" ".zip";then
-  mv $1 __UserCodeLambdaFunction__.jar
+  jar=$1
+  # With LAMBDA_SOURCE_DIR set, see the lambda package's RunOptions.Source, the
+  # jar is taken from there instead of the image.
+  if [ -n "$LAMBDA_SOURCE_DIR" ] && [ -f "$LAMBDA_SOURCE_DIR/$1" ]; then
+    jar="$LAMBDA_SOURCE_DIR/$1"
+  fi
 else
   echo "Please set jar|zip filename in first param"
   exit 1
 fi
 
+# Values registered with IronWorker are encrypted with KMS, see the lambda
+# package's RegisterOptions.EnvKey.
+if env | grep -q '=encrypted:'; then
+  exports=$(java -cp lambda.jar io.iron.lambda.DecryptEnv) || exit 1
+  eval "$exports"
+fi
+
 # set env variables from CONFIG_* prefixed ones
 for i in $(env); do
     if [[ $i  == CONFIG_* ]]; then
@@ -24,6 +36,11 @@ for i in $(env); do
     fi
 done
 
-java -jar lambda.jar $2
+# With LAMBDA_DEBUG_PORT set, see the lambda package's RunOptions.DebugPort,
+# suspend until a debugger attaches over JDWP.
+if [ -n "$LAMBDA_DEBUG_PORT" ]; then
+  JAVA_OPTS="-agentlib:jdwp=transport=dt_socket,server=y,suspend=y,address=$LAMBDA_DEBUG_PORT"
+fi
 
-exit 0
+# The jar is used where it is, the root filesystem is read-only in a sandbox.
+exec java $JAVA_OPTS -cp "lambda.jar:$jar" io.iron.lambda.Launcher $2
@@ -0,0 +1,51 @@
+package io.iron.lambda;
+
+import java.nio.ByteBuffer;
+import java.nio.charset.StandardCharsets;
+import java.util.*;
+
+import com.amazonaws.client.builder.AwsClientBuilder;
+import com.amazonaws.services.kms.AWSKMS;
+import com.amazonaws.services.kms.AWSKMSClientBuilder;
+import com.amazonaws.services.kms.model.DecryptRequest;
+
+// Values registered with IronWorker are encrypted with KMS, see the lambda
+// package's RegisterOptions.EnvKey. LambdaLauncher.sh evals the exports this
+// prints before the function is loaded.
+public class DecryptEnv {
+
+    static final String PREFIX = "encrypted:";
+
+    public static void main(String[] args) {
+        String region = System.getenv("LAMBDA_KMS_REGION");
+        String endpoint = System.getenv("LAMBDA_KMS_ENDPOINT");
+        AWSKMSClientBuilder builder = AWSKMSClientBuilder.standard();
+        if (endpoint != null && !endpoint.isEmpty()) {
+            builder.setEndpointConfiguration(new AwsClientBuilder.EndpointConfiguration(endpoint, region));
+        } else if (region != null && !region.isEmpty()) {
+            builder.setRegion(region);
+        }
+        AWSKMS kms = builder.build();
+
+        String function = System.getenv("AWS_LAMBDA_FUNCTION_NAME");
+        Map<String, String> context = Collections.singletonMap("LambdaFunctionName", function == null ? "" : function);
+        for (Map.Entry<String, String> e : System.getenv().entrySet()) {
+            if (!e.getValue().startsWith(PREFIX)) {
+                continue;
+            }
+            String plain;
+            try {
+                byte[] blob = Base64.getDecoder().decode(e.getValue().substring(PREFIX.length()));
+                DecryptRequest req = new DecryptRequest()
+                    .withCiphertextBlob(ByteBuffer.wrap(blob))
+                    .withEncryptionContext(context);
+                plain = StandardCharsets.UTF_8.decode(kms.decrypt(req).getPlaintext()).toString();
+            } catch (Exception ex) {
+                System.err.println("bootstrap: Could not decrypt " + e.getKey() + ": " + ex.getMessage());
+                System.exit(1);
+                return;
+            }
+            System.out.println("export " + e.getKey() + "='" + plain.replace("'", "'\\''") + "'");
+        }
+    }
+}
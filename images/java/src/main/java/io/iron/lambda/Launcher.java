@@ -21,6 +21,12 @@ public class Launcher {
     }
 
     public static void main(String[] args) {
+        // With suspend=y, see LambdaLauncher.sh, this only runs once the
+        // debugger attached. The function's timeout starts now.
+        if (System.getenv("LAMBDA_DEBUG_PORT") != null) {
+            System.err.println("\u001elambda debugger attached");
+        }
+
         String handler = args[0];
         String payload = "";
         String file = System.getenv("PAYLOAD_FILE");
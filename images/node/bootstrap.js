@@ -40,15 +40,19 @@ console.log = console.error
 // So the context object needs to have some sort of hidden boolean that is only
 // flipped once, by the first call, and dictates the behavior on the next tick.
 //
-// In addition, the response behaviour depends on the invocation type. If we
-// are to only support the async type, succeed() must return a 202 response
-// code, not sure how to do this.
+// In addition, the response behaviour depends on the invocation type. The
+// result is written to stdout and the status codes for each invocation type
+// are handled by the runner, see lambda/server.
 //
 // Only the first 256kb, followed by a truncation message, should be logged.
 //
 // Also, the error log is always in a json literal
 // { "errorMessage": "<message>" }
-var Context = function() {
+//
+// `finish` is called with the exit code once the invocation concludes. For a
+// single payload this exits the process, for warm containers it lets the next
+// invocation run.
+var Context = function(plannedEnd, finish) {
   var concluded = false;
 
   var contextSelf = this;
@@ -77,12 +81,12 @@ var Context = function() {
       failed = true;
     }
 
-    // FIXME(nikhil): Return 202 or 200 based on invocation type and set response
-    // to result. Should probably be handled externally by the runner/swapi.
+    // The runner returns 202 or 200 based on invocation type with the result
+    // from stdout.
 
     // OK, everything good.
     concluded = true;
-    process.nextTick(function() { process.exit(failed ? 1 : 0) })
+    process.nextTick(function() { finish(failed ? 1 : 0) })
   }
 
   this.fail = function(error) {
@@ -91,7 +95,7 @@ var Context = function() {
     }
 
     concluded = true
-    process.nextTick(function() { process.exit(1) })
+    process.nextTick(function() { finish(1) })
 
     if (error === undefined) {
       error = null
@@ -127,7 +131,6 @@ var Context = function() {
     }
   }
 
-  var plannedEnd = Date.now() + (getTimeoutInSeconds() * 1000);
   this.getRemainingTimeInMillis = function() {
     return Math.max(plannedEnd - Date.now(), 0);
   }
@@ -146,10 +149,8 @@ var getEnv = function(name) {
   return process.env[name] || "";
 }
 
-var makeCtx = function() {
+var makeCtx = function(taskID, plannedEnd, finish) {
   var fnname = getEnv("AWS_LAMBDA_FUNCTION_NAME");
-  // FIXME(nikhil): Generate UUID.
-  var taskID = getEnv("TASK_ID");
 
   var mem = getEnv("TASK_MAXRAM").toLowerCase();
   var bytes = 300 * 1024 * 1024;
@@ -176,7 +177,7 @@ var makeCtx = function() {
 
   var memoryMB = bytes / (1024 * 1024);
 
-  var ctx = new Context();
+  var ctx = new Context(plannedEnd, finish);
   Object.defineProperties(ctx, {
     "functionName": {
       value: fnname,
@@ -240,8 +241,252 @@ var setEnvFromHeader = function () {
 }
 
 
+// Returns undefined if the payload could not be parsed.
+function parsePayload(input) {
+  var payload = {}
+  try {
+    if (input.length > 0) {
+      payload = JSON.parse(input);
+    }
+  } catch(e) {
+    console.error("bootstrap: Error parsing JSON", e);
+    return undefined;
+  }
+  return payload;
+}
+
+// Runs the handler named on the command line once. `finish` is passed on to
+// the context. `unfinished` is called instead if the handler could not be
+// called or threw, for a single payload the process then exits with 1 once the
+// event loop is empty.
+function invoke(payload, taskID, plannedEnd, finish, unfinished) {
+  if (process.argv.length > 2) {
+    var handler = process.argv[2];
+    var parts = handler.split('.');
+    // FIXME(nikhil): Error checking.
+    var script = parts[0];
+    var entry = parts[1];
+    var started = false;
+    try {
+      var mod = require(sourceDir() + '/' + script);
+      var func = mod[entry];
+      if (func === undefined) {
+        oldlog("Handler '" + entry + "' missing on module '" + script + "'");
+        unfinished();
+        return;
+      }
+
+      if (typeof func !== 'function') {
+        throw "TypeError: " + (typeof func) + " is not a function";
+      }
+      started = true;
+      mod[entry](payload, makeCtx(taskID, plannedEnd, finish))
+    } catch(e) {
+      if (typeof e === 'string') {
+        oldlog(e)
+      } else {
+        oldlog(e.message)
+      }
+      if (!started) {
+        oldlog("Process exited before completing request\n")
+      }
+      unfinished();
+    }
+  } else {
+    console.error("bootstrap: No script specified")
+    process.exit(1);
+  }
+}
+
+// Warm containers get one invocation per line on stdin, see the lambda
+// package's pool.go for the protocol.
+var markerStart = '\u001elambda ';
+
+function setOrDelete(name, value) {
+  if (value) {
+    process.env[name] = value;
+  } else {
+    delete process.env[name];
+  }
+}
+
+function runStream() {
+  var buffered = "";
+  var queue = [];
+  var busy = false;
+
+  var next = function() {
+    if (busy || queue.length === 0) {
+      return;
+    }
+
+    var invocation;
+    try {
+      invocation = JSON.parse(queue.shift());
+    } catch(e) {
+      console.error("bootstrap: Error parsing invocation", e);
+      process.exit(1);
+    }
+
+    busy = true;
+    var concluded = false;
+    var finish = function(code) {
+      if (concluded) {
+        return;
+      }
+      concluded = true;
+      var marker = markerStart + "done " + invocation.id + " " + code + "\n";
+      process.stderr.write(marker);
+      process.stdout.write(marker);
+      busy = false;
+      next();
+    }
+
+    // The trace of the invocation, for the X-Ray and OpenTelemetry SDKs. The
+    // previous invocation's must not leak into this one.
+    setOrDelete("_X_AMZN_TRACE_ID", invocation.trace);
+    setOrDelete("TRACEPARENT", invocation.traceparent);
+
+    var payload = parsePayload(invocation.payload);
+    if (payload === undefined) {
+      finish(1);
+      return;
+    }
+    // A handler that could not be called or threw failed, like it does in a
+    // single payload container.
+    invoke(payload, invocation.id, invocation.deadline, finish, function() { finish(1) });
+  }
+
+  process.stdin.setEncoding('utf8');
+  process.stdin.on('data', function(chunk) {
+    buffered += chunk;
+    var idx;
+    while ((idx = buffered.indexOf('\n')) >= 0) {
+      queue.push(buffered.slice(0, idx));
+      buffered = buffered.slice(idx + 1);
+    }
+    next();
+  });
+
+  process.stdin.on('end', function() {
+    process.exit(0);
+  });
+
+  process.stdout.write(markerStart + "ready\n");
+}
+
+// With LAMBDA_DEBUG_PORT set, see the lambda package's RunOptions.DebugPort,
+// restarts the bootstrap with the debugger listening, paused before the first
+// line until a debugger attaches. Returns whether it did.
+function restartForDebugger() {
+  var port = process.env["LAMBDA_DEBUG_PORT"];
+  if (!port) {
+    return false;
+  }
+  if (process.execArgv.length > 0) {
+    // Restarted and resumed by the debugger, the function's timeout starts
+    // now.
+    process.stderr.write(markerStart + "debugger attached\n");
+    return false;
+  }
+
+  var args = ["--debug-brk=" + port].concat(process.argv.slice(1));
+  var child = require('child_process').spawn(process.execPath, args, {stdio: 'inherit'});
+  child.on('exit', function(code) {
+    process.exit(code);
+  });
+  return true;
+}
+
+// With LAMBDA_SOURCE_DIR set, see the lambda package's RunOptions.Source, the
+// function is loaded from there instead of /app. Modules the image installed in
+// /app are still found.
+function sourceDir() {
+  return process.env["LAMBDA_SOURCE_DIR"] || __dirname;
+}
+
+function useSourceDir() {
+  if (!process.env["LAMBDA_SOURCE_DIR"]) {
+    return;
+  }
+  // The function is loaded from the source directory, so the image's
+  // node_modules is not above it. Module.globalPaths is only a copy, lookups
+  // use the paths _initPaths builds from NODE_PATH.
+  var paths = [__dirname + '/node_modules'];
+  if (process.env["NODE_PATH"]) {
+    paths.unshift(process.env["NODE_PATH"]);
+  }
+  process.env["NODE_PATH"] = paths.join(':');
+  require('module')._initPaths();
+  process.chdir(sourceDir());
+}
+
+// Values of environment variables registered with IronWorker are encrypted
+// with KMS, see the lambda package's RegisterOptions. Decrypts them in place,
+// with the function name as the encryption context like Lambda, and calls
+// `done` with the error if one could not be decrypted.
+var encryptedPrefix = "encrypted:";
+
+function decryptEnv(done) {
+  var names = Object.keys(process.env).filter(function(name) {
+    return process.env[name].indexOf(encryptedPrefix) === 0;
+  });
+  if (names.length === 0) {
+    done();
+    return;
+  }
+
+  var AWS = require('aws-sdk');
+  var config = {region: process.env["LAMBDA_KMS_REGION"]};
+  if (process.env["LAMBDA_KMS_ENDPOINT"]) {
+    config.endpoint = process.env["LAMBDA_KMS_ENDPOINT"];
+  }
+  var kms = new AWS.KMS(config);
+
+  var pending = names.length;
+  var failed = false;
+  names.forEach(function(name) {
+    kms.decrypt({
+      CiphertextBlob: new Buffer(process.env[name].slice(encryptedPrefix.length), 'base64'),
+      EncryptionContext: {"LambdaFunctionName": getEnv("AWS_LAMBDA_FUNCTION_NAME")},
+    }, function(err, data) {
+      if (failed) {
+        return;
+      }
+      if (err) {
+        failed = true;
+        done(new Error("Could not decrypt " + name + ": " + err.message));
+        return;
+      }
+      process.env[name] = data.Plaintext.toString('utf8');
+      if (--pending === 0) {
+        done();
+      }
+    });
+  });
+}
+
 function run() {
-  setEnvFromHeader();
+  if (restartForDebugger()) {
+    return;
+  }
+  decryptEnv(function(err) {
+    if (err) {
+      console.error("bootstrap: " + err.message);
+      process.exit(1);
+    }
+    setEnvFromHeader();
+    useSourceDir();
+    if (process.env["PAYLOAD_STREAM"]) {
+      runStream();
+    } else {
+      runPayload();
+    }
+  });
+}
+
+// Runs the function once, with the payload from PAYLOAD_FILE or stdin.
+function runPayload() {
   // FIXME(nikhil): Check for file existence and allow non-payload.
   var path = process.env["PAYLOAD_FILE"];
   var stream = process.stdin;
@@ -266,50 +511,19 @@ function run() {
   });
 
   stream.on('end', function() {
-    var payload = {}
-    try {
-      if (input.length > 0) {
-        payload = JSON.parse(input);
-      }
-    } catch(e) {
-      console.error("bootstrap: Error parsing JSON", e);
+    var payload = parsePayload(input);
+    if (payload === undefined) {
       process.exit(1);
     }
 
-    if (process.argv.length > 2) {
-      var handler = process.argv[2];
-      var parts = handler.split('.');
-      // FIXME(nikhil): Error checking.
-      var script = parts[0];
-      var entry = parts[1];
-      var started = false;
-      try {
-        var mod = require('./'+script);
-        var func = mod[entry];
-        if (func === undefined) {
-          oldlog("Handler '" + entry + "' missing on module '" + script + "'");
-          return;
-        }
-
-        if (typeof func !== 'function') {
-          throw "TypeError: " + (typeof func) + " is not a function";
-        }
-        started = true;
-        mod[entry](payload, makeCtx())
-      } catch(e) {
-        if (typeof e === 'string') {
-          oldlog(e)
-        } else {
-          oldlog(e.message)
-        }
-        if (!started) {
-          oldlog("Process exited before completing request\n")
-        }
-      }
-    } else {
-      console.error("bootstrap: No script specified")
-      process.exit(1);
-    }
+    var plannedEnd = Date.now() + (getTimeoutInSeconds() * 1000);
+    invoke(payload, getEnv("TASK_ID"), plannedEnd, function(code) {
+      process.exit(code);
+    }, function() {
+      process.on('exit', function() {
+        process.exit(1);
+      });
+    });
   })
 }
 
@@ -28,14 +28,15 @@ class Context(object):
     identity = None
     client_context = None
 
-    def __init__(self):
+    def __init__(self, request_id, planned_end):
         self.function_name = getAWS_LAMBDA_FUNCTION_NAME()
         self.function_version = getAWS_LAMBDA_FUNCTION_VERSION()
-        self.aws_request_id = getREQUEST_ID()
+        self.aws_request_id = request_id
         self.memory_limit_in_mb = int(getTASK_MAXRAM() / 1024 / 1024)
+        self.planned_end = planned_end
 
     def get_remaining_time_in_millis(self):
-        remaining = plannedEnd - int(time.time())
+        remaining = self.planned_end - int(time.time())
         if remaining < 0:
             remaining = 0
         return remaining * 1000
@@ -79,7 +80,10 @@ class DynaCaller(object):
         return __import__(self.moduleName)
 
     def locateModuleInMountFolder(self):
-        mountModuleLocation = '/mnt/' + self.moduleName + '.py'
+        sourceDir = os.environ.get('LAMBDA_SOURCE_DIR')
+        if not sourceDir:
+            return None
+        mountModuleLocation = os.path.join(sourceDir, self.moduleName + '.py')
         if not os.path.isfile(mountModuleLocation):
             return None
         return imp.load_source(self.moduleName, mountModuleLocation)
@@ -152,12 +156,14 @@ def getHandlerName():
     return None
 
 
-def configLogging(context):
+def configLogging():
 
     # RequestIdFilter is used to add request_id field value into log line. More details could be found on the following links:
     #  https://docs.python.org/2/howto/logging-cookbook.html#filters-contextual
     #  https://docs.python.org/2/howto/logging-cookbook.html#an-example-dictionary-based-configuration
 
+    # The context of the current invocation is a global, warm containers
+    # replace it for every invocation.
     class RequestIdFilter(logging.Filter):
         def filter(self, record):
             record.request_id = context.aws_request_id
@@ -205,18 +211,158 @@ def setEnvFromHeader():
         os.environ[key] = value
 
 
+# Values registered with IronWorker are encrypted with KMS, see the lambda
+# package's RegisterOptions.EnvKey, and decrypted here before the function
+# is loaded.
+def decryptEnv():
+    prefix = 'encrypted:'
+    names = [k for k, v in os.environ.items() if v.startswith(prefix)]
+    if not names:
+        return
+
+    import base64
+    import boto3
+    kms = boto3.client('kms',
+                       region_name=os.environ.get('LAMBDA_KMS_REGION'),
+                       endpoint_url=os.environ.get('LAMBDA_KMS_ENDPOINT') or None)
+    context = {'LambdaFunctionName': os.environ.get('AWS_LAMBDA_FUNCTION_NAME', '')}
+    for name in names:
+        try:
+            blob = base64.b64decode(os.environ[name][len(prefix):])
+            plain = kms.decrypt(CiphertextBlob=blob, EncryptionContext=context)['Plaintext']
+        except Exception as e:
+            stopWithError("Could not decrypt {name}: {err}".format(name=name, err=e))
+        # Bytes are a str in python 2.
+        os.environ[name] = plain if isinstance(plain, str) else plain.decode('utf-8')
+
+
+def getPAYLOAD_STREAM():
+    return os.environ.get('PAYLOAD_STREAM')
+
+
+def readPayload(payloadFileName):
+    try:
+        if payloadFileName:
+            payloadFile = file(payloadFileName, 'r')
+        else:
+            payloadFile = sys.stdin
+
+        with payloadFile as f:
+            return f.read()
+
+    except Exception, e:
+        stopWithError("Failed to read {payloadFileName}. err={err}".format(payloadFileName=(payloadFileName or '<stdin>'), err=e))
+
+
+def parsePayload(payload):
+    try:
+        if len(payload) > 0:
+            payload = json.loads(payload)
+    except:
+        debugging and print ('payload is ') and print (payload)
+        stopWithError('Payload is not JSON')
+
+    debugging and print ('payload parsed as JSON')
+    return payload
+
+
+def invoke(caller, payload):
+    if caller.module is None:
+        try:
+            caller.locateFunc()
+        except Exception as e:
+            print (e, file=sys.stderr)
+            stopWithError("Failed to locate {module}.{func}"
+                .format(module=moduleName, func=funcName))
+
+        debugging and print ('handler found')
+
+    try:
+        result = caller.call(payload, context)
+        oldstdout.write(json.dumps(result))
+    except Exception as e:
+        stopWithError(e)
+
+    debugging and print ('done')
+
+
+# Warm containers get one invocation per line on stdin, see the lambda
+# package's pool.go for the protocol.
+markerStart = '\x1elambda '
+
+
+def runStream(caller):
+    global context
+
+    oldstdout.write(markerStart + 'ready\n')
+    oldstdout.flush()
+
+    for line in iter(sys.stdin.readline, ''):
+        invocation = json.loads(line)
+        context = Context(invocation['id'], invocation['deadline'] / 1000)
+        # The trace of the invocation, for the X-Ray and OpenTelemetry SDKs.
+        # The previous invocation's must not leak into this one.
+        for name, field in (('_X_AMZN_TRACE_ID', 'trace'), ('TRACEPARENT', 'traceparent')):
+            if invocation.get(field):
+                os.environ[name] = invocation[field]
+            else:
+                os.environ.pop(name, None)
+
+        code = 0
+        try:
+            invoke(caller, parsePayload(invocation['payload']))
+        except SystemExit as e:
+            code = e.code
+
+        marker = '{start}done {id} {code}\n'.format(start=markerStart, id=invocation['id'], code=code)
+        sys.stderr.write(marker)
+        sys.stderr.flush()
+        oldstdout.write(marker)
+        oldstdout.flush()
+
+
+# With LAMBDA_DEBUG_PORT set, see the lambda package's RunOptions.DebugPort,
+# wait for a debugger to attach to ptvsd before the function is loaded.
+def waitForDebugger():
+    port = os.environ.get('LAMBDA_DEBUG_PORT')
+    if not port:
+        return
+
+    try:
+        import ptvsd
+    except ImportError:
+        stopWithError('LAMBDA_DEBUG_PORT is set, but ptvsd is not installed')
+    ptvsd.enable_attach(secret=None, address=('0.0.0.0', int(port)))
+    print('bootstrap: Waiting for a debugger on port', port)
+    ptvsd.wait_for_attach()
+    # The function's timeout starts now.
+    print(markerStart + 'debugger attached')
+
+
+# With LAMBDA_SOURCE_DIR set, see the lambda package's RunOptions.Source, the
+# function and the modules it imports are loaded from there instead of /app.
+def useSourceDir():
+    sourceDir = os.environ.get('LAMBDA_SOURCE_DIR')
+    if not sourceDir:
+        return
+    sys.path.insert(0, sourceDir)
+    os.chdir(sourceDir)
+
+
+decryptEnv()
 setEnvFromHeader()
-plannedEnd = int(time.time()) + getTASK_TIMEOUT()
+useSourceDir()
+waitForDebugger()
 
 debugging and print ('os.environ      = ', os.environ)
 debugging and print ('/mnt content    = ', os.listdir("/mnt"))
 debugging and print ('pwd dir content = ',
     os.listdir(os.path.dirname(os.path.realpath(__file__))))
 
-context = Context()
+context = Context(getREQUEST_ID(), int(time.time()) + getTASK_TIMEOUT())
 debugging and print ('context created')
 
-configLogging(context)
+configLogging()
 debugging and print ('config loaded')
 
 payloadFileName = getPAYLOAD_FILE()
@@ -242,42 +388,9 @@ if moduleName is None:
 if funcName is None:
     stopWithError("Function name is not defined")
 
-try:
-    if payloadFileName:
-        payloadFile = file(payloadFileName, 'r')
-    else:
-        payloadFile = sys.stdin
-
-    with payloadFile as f:
-        payload = f.read()
-
-except Exception, e:
-    stopWithError("Failed to read {payloadFileName}. err={err}".format(payloadFileName=(payloadFileName or '<stdin>'), err=e))
-
-try:
-    if len(payload) > 0:
-        payload = json.loads(payload)
-except:
-    debugging and print ('payload is ') and print (payload)
-    stopWithError('Payload is not JSON')
-
-debugging and print ('payload parsed as JSON')
-
 caller = DynaCaller(moduleName, funcName)
 
-try:
-    caller.locateFunc()
-except Exception as e:
-    print (e, file=sys.stderr)
-    stopWithError("Failed to locate {module}.{func}"
-        .format(module=moduleName, func=funcName))
-
-debugging and print ('handler found')
-
-try:
-    result = caller.call(payload, context)
-    oldstdout.write(json.dumps(result))
-except Exception as e:
-    stopWithError(e)
-
-debugging and print ('done')
+if getPAYLOAD_STREAM():
+    runStream(caller)
+else:
+    invoke(caller, parsePayload(readPayload(payloadFileName)))
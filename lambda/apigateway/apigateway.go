@@ -0,0 +1,292 @@
+// Package apigateway serves HTTP requests with functions written for the API
+// Gateway Lambda proxy integration. Requests are converted to proxy events and
+// the function's result back to HTTP responses.
+package apigateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// API Gateway's payload limit.
+	maxBody = 10 * 1024 * 1024
+
+	stage = "local"
+)
+
+// Runs a function synchronously and returns its result.
+type InvokeFunc func(function string, payload string) ([]byte, error)
+
+type RequestIdentity struct {
+	SourceIP  string `json:"sourceIp"`
+	UserAgent string `json:"userAgent"`
+}
+
+type RequestContext struct {
+	AccountID        string          `json:"accountId"`
+	APIID            string          `json:"apiId"`
+	Stage            string          `json:"stage"`
+	RequestID        string          `json:"requestId"`
+	RequestTime      string          `json:"requestTime"`
+	RequestTimeEpoch int64           `json:"requestTimeEpoch"`
+	ResourcePath     string          `json:"resourcePath"`
+	HTTPMethod       string          `json:"httpMethod"`
+	Path             string          `json:"path"`
+	Protocol         string          `json:"protocol"`
+	Identity         RequestIdentity `json:"identity"`
+}
+
+// The event proxy integrations receive.
+type Request struct {
+	Resource                        string              `json:"resource"`
+	Path                            string              `json:"path"`
+	HTTPMethod                      string              `json:"httpMethod"`
+	Headers                         map[string]string   `json:"headers"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	PathParameters                  map[string]string   `json:"pathParameters"`
+	StageVariables                  map[string]string   `json:"stageVariables"`
+	RequestContext                  RequestContext      `json:"requestContext"`
+	Body                            *string             `json:"body"`
+	IsBase64Encoded                 bool                `json:"isBase64Encoded"`
+}
+
+// The result proxy integrations return.
+type Response struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+type Handler struct {
+	invoke InvokeFunc
+	routes []*Route
+}
+
+func NewHandler(invoke InvokeFunc, routes []*Route) (*Handler, error) {
+	h := &Handler{invoke: invoke}
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		r := *r
+		if err := r.parse(); err != nil {
+			return nil, err
+		}
+
+		key := r.Method + " " + strings.Join(r.segments, "/")
+		if seen[key] {
+			return nil, fmt.Errorf("Duplicate route %s %s.", r.Method, r.Path)
+		}
+		seen[key] = true
+		h.routes = append(h.routes, &r)
+	}
+
+	sort.Stable(bySpecificity(h.routes))
+	return h, nil
+}
+
+type bySpecificity []*Route
+
+func (s bySpecificity) Len() int           { return len(s) }
+func (s bySpecificity) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySpecificity) Less(i, j int) bool { return s[i].specificity() > s[j].specificity() }
+
+func (h *Handler) route(method string, path string) (*Route, map[string]string) {
+	segments := splitPath(path)
+	for _, r := range h.routes {
+		if params, ok := r.match(method, segments); ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params := h.route(r.Method, r.URL.Path)
+	if route == nil {
+		// What API Gateway returns for unknown resources.
+		writeMessage(w, http.StatusForbidden, "Missing Authentication Token")
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body) > maxBody {
+		writeMessage(w, http.StatusRequestEntityTooLarge, "Request Too Long")
+		return
+	}
+
+	payload, err := json.Marshal(NewRequest(r, route, params, body))
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := h.invoke(route.Function, string(payload))
+	if err == lambda.ErrorThrottled {
+		writeMessage(w, http.StatusTooManyRequests, "Too Many Requests")
+		return
+	}
+	if err != nil {
+		log.Printf("%s %s: %s failed: %s", r.Method, r.URL.Path, route.Function, err)
+		writeMessage(w, http.StatusBadGateway, "Internal server error")
+		return
+	}
+
+	if err := writeResponse(w, result); err != nil {
+		log.Printf("%s %s: malformed Lambda proxy response from %s: %s", r.Method, r.URL.Path, route.Function, err)
+		writeMessage(w, http.StatusBadGateway, "Internal server error")
+	}
+}
+
+// Converts an HTTP request to the proxy event for `route`.
+func NewRequest(r *http.Request, route *Route, params map[string]string, body []byte) *Request {
+	now := time.Now()
+	req := &Request{
+		Resource:          route.Path,
+		Path:              r.URL.Path,
+		HTTPMethod:        r.Method,
+		Headers:           make(map[string]string),
+		MultiValueHeaders: make(map[string][]string),
+		RequestContext: RequestContext{
+			AccountID:        "123456789012",
+			APIID:            "local",
+			Stage:            stage,
+			RequestID:        uuid.NewV4().String(),
+			RequestTime:      now.UTC().Format("02/Jan/2006:15:04:05 -0700"),
+			RequestTimeEpoch: now.UnixNano() / int64(time.Millisecond),
+			ResourcePath:     route.Path,
+			HTTPMethod:       r.Method,
+			Path:             "/" + stage + r.URL.Path,
+			Protocol:         r.Proto,
+			Identity: RequestIdentity{
+				SourceIP:  sourceIP(r),
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+
+	// Missing parameters are null rather than empty.
+	if len(params) > 0 {
+		req.PathParameters = params
+	}
+
+	for name, values := range r.Header {
+		req.Headers[name] = values[len(values)-1]
+		req.MultiValueHeaders[name] = values
+	}
+	// Go keeps the Host header out of r.Header.
+	if r.Host != "" {
+		req.Headers["Host"] = r.Host
+		req.MultiValueHeaders["Host"] = []string{r.Host}
+	}
+
+	if query := r.URL.Query(); len(query) > 0 {
+		req.QueryStringParameters = make(map[string]string)
+		req.MultiValueQueryStringParameters = query
+		for name, values := range query {
+			req.QueryStringParameters[name] = values[len(values)-1]
+		}
+	}
+
+	if len(body) > 0 {
+		s := string(body)
+		if isBinary(r.Header.Get("Content-Type"), body) {
+			s = base64.StdEncoding.EncodeToString(body)
+			req.IsBase64Encoded = true
+		}
+		req.Body = &s
+	}
+	return req
+}
+
+// Bodies are passed as text unless they have a non-text content type or are
+// not valid UTF-8.
+func isBinary(contentType string, body []byte) bool {
+	if !utf8.Valid(body) {
+		return true
+	}
+	if contentType == "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded":
+		return false
+	}
+	return true
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func writeResponse(w http.ResponseWriter, result []byte) error {
+	var resp Response
+	if err := json.Unmarshal(result, &resp); err != nil {
+		return err
+	}
+	if resp.StatusCode < 100 || resp.StatusCode > 599 {
+		return fmt.Errorf("invalid status code %d", resp.StatusCode)
+	}
+
+	body := []byte(resp.Body)
+	if resp.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(resp.Body); err != nil {
+			return err
+		}
+	}
+
+	for name, values := range resp.MultiValueHeaders {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	for name, v := range resp.Headers {
+		w.Header().Set(name, v)
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+	return nil
+}
+
+// Errors from API Gateway itself, as opposed to the function.
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
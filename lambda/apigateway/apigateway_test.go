@@ -0,0 +1,170 @@
+package apigateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iron-io/lambda/lambda"
+)
+
+func TestRoutes(t *testing.T) {
+	h, err := NewHandler(nil, []*Route{
+		{Path: "/{proxy+}", Function: "fallback"},
+		{Method: "GET", Path: "/users/{id}", Function: "get-user"},
+		{Path: "/users/{id}", Function: "user"},
+		{Method: "get", Path: "/users/me", Function: "me"},
+		{Method: "GET", Path: "/files/{path+}", Function: "files"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path, function string
+		params                 map[string]string
+	}{
+		{"GET", "/users/42", "get-user", map[string]string{"id": "42"}},
+		{"DELETE", "/users/42", "user", map[string]string{"id": "42"}},
+		{"GET", "/users/me", "me", map[string]string{}},
+		{"GET", "/files/a/b/c.txt", "files", map[string]string{"path": "a/b/c.txt"}},
+		{"POST", "/files/a", "fallback", map[string]string{"proxy": "files/a"}},
+		{"GET", "/users/42/posts", "fallback", map[string]string{"proxy": "users/42/posts"}},
+	}
+	for _, test := range tests {
+		r, params := h.route(test.method, test.path)
+		if r == nil || r.Function != test.function {
+			t.Fatal(test.method, test.path, "expected", test.function, "got", r)
+		}
+		if len(params) != len(test.params) {
+			t.Fatal(test.path, "unexpected params", params)
+		}
+		for k, v := range test.params {
+			if params[k] != v {
+				t.Fatal(test.path, "unexpected params", params)
+			}
+		}
+	}
+
+	if r, _ := h.route("GET", "/"); r != nil {
+		t.Fatal("Greedy parameters should not match an empty path")
+	}
+
+	for _, route := range []*Route{
+		{Path: "users"},
+		{Method: "FETCH", Path: "/users"},
+		{Path: "/{proxy+}/more"},
+		{Path: "/users/{}"},
+		{Path: "/users/id}"},
+	} {
+		if _, err := NewHandler(nil, []*Route{route}); err == nil {
+			t.Fatal("Expected error for route", route.Method, route.Path)
+		}
+	}
+	if _, err := NewHandler(nil, []*Route{{Path: "/a"}, {Method: "ANY", Path: "/a/"}}); err == nil {
+		t.Fatal("Expected error for duplicate routes")
+	}
+}
+
+func TestProxyRequestAndResponse(t *testing.T) {
+	var event Request
+	h, err := NewHandler(func(function string, payload string) ([]byte, error) {
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			t.Fatal(err)
+		}
+		return []byte(`{"statusCode": 201, "headers": {"Content-Type": "text/plain", "X-Id": "7"}, "multiValueHeaders": {"Set-Cookie": ["a=1", "b=2"]}, "body": "aGVsbG8=", "isBase64Encoded": true}`), nil
+	}, []*Route{{Method: "POST", Path: "/users/{id}/avatar", Function: "avatar"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("POST", "http://example.com/users/42/avatar?size=large&tag=a&tag=b", strings.NewReader("\x89PNG\x00"))
+	r.Header.Set("Content-Type", "image/png")
+	r.Header.Add("Accept", "text/plain")
+	r.Header.Add("Accept", "*/*")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if event.HTTPMethod != "POST" || event.Path != "/users/42/avatar" || event.Resource != "/users/{id}/avatar" {
+		t.Fatal("Unexpected request line", event.HTTPMethod, event.Path, event.Resource)
+	}
+	if event.PathParameters["id"] != "42" {
+		t.Fatal("Unexpected path parameters", event.PathParameters)
+	}
+	if event.QueryStringParameters["size"] != "large" || event.QueryStringParameters["tag"] != "b" || len(event.MultiValueQueryStringParameters["tag"]) != 2 {
+		t.Fatal("Unexpected query string", event.QueryStringParameters, event.MultiValueQueryStringParameters)
+	}
+	if event.Headers["Host"] != "example.com" || event.Headers["Accept"] != "*/*" || len(event.MultiValueHeaders["Accept"]) != 2 {
+		t.Fatal("Unexpected headers", event.Headers, event.MultiValueHeaders)
+	}
+	if event.Body == nil || !event.IsBase64Encoded || *event.Body != base64.StdEncoding.EncodeToString([]byte("\x89PNG\x00")) {
+		t.Fatal("Expected base64 encoded body", event.Body)
+	}
+	if event.RequestContext.RequestID == "" || event.RequestContext.ResourcePath != "/users/{id}/avatar" {
+		t.Fatal("Unexpected request context", event.RequestContext)
+	}
+
+	if w.Code != 201 || w.Body.String() != "hello" {
+		t.Fatal("Unexpected response", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "text/plain" || w.Header().Get("X-Id") != "7" || len(w.Header()["Set-Cookie"]) != 2 {
+		t.Fatal("Unexpected response headers", w.Header())
+	}
+}
+
+func TestTextBody(t *testing.T) {
+	for contentType, binary := range map[string]bool{
+		"":                         false,
+		"application/json":         false,
+		"text/html; charset=utf-8": false,
+		"application/vnd.api+json": false,
+		"application/octet-stream": true,
+		"multipart/form-data; b=x": true,
+	} {
+		if isBinary(contentType, []byte("hello")) != binary {
+			t.Fatal("Unexpected encoding for", contentType)
+		}
+	}
+	if !isBinary("text/plain", []byte{0xff, 0xfe}) {
+		t.Fatal("Invalid UTF-8 should be base64 encoded")
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	var result string
+	var invokeErr error
+	h, err := NewHandler(func(function string, payload string) ([]byte, error) {
+		return []byte(result), invokeErr
+	}, []*Route{{Path: "/hello", Function: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		result string
+		err    error
+		status int
+	}{
+		{"/missing", "", nil, http.StatusForbidden},
+		{"/hello", `{"statusCode": 200, "body": "hi"}`, nil, http.StatusOK},
+		{"/hello", `{"body": "hi"}`, nil, http.StatusBadGateway},
+		{"/hello", `not json`, nil, http.StatusBadGateway},
+		{"/hello", `{"errorMessage": "boom"}`, &lambda.ExitError{Code: 1}, http.StatusBadGateway},
+		{"/hello", "", lambda.ErrorThrottled, http.StatusTooManyRequests},
+		{"/hello", "", errors.New("no such image"), http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		result, invokeErr = test.result, test.err
+		r, _ := http.NewRequest("GET", test.path, strings.NewReader(""))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatal(test.path, test.result, "expected", test.status, "got", w.Code)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package apigateway
+
+import (
+	"fmt"
+	"strings"
+)
+
+var methods = map[string]bool{
+	"ANY": true, "GET": true, "HEAD": true, "POST": true, "PUT": true,
+	"PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+// Maps requests to a function, like an API Gateway resource and method with a
+// Lambda proxy integration.
+//
+// Paths are API Gateway resource paths. `{name}` matches a single path
+// segment and `{name+}` the rest of the path, both are passed to the function
+// as path parameters.
+type Route struct {
+	Method   string `json:"method"` // Empty means ANY.
+	Path     string `json:"path"`
+	Function string `json:"function,omitempty"`
+
+	segments []string
+}
+
+func (r *Route) parse() error {
+	if r.Method == "" {
+		r.Method = "ANY"
+	}
+	r.Method = strings.ToUpper(r.Method)
+	if !methods[r.Method] {
+		return fmt.Errorf("Invalid method %s for route %s.", r.Method, r.Path)
+	}
+
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("Route path %s must start with /.", r.Path)
+	}
+
+	r.segments = splitPath(r.Path)
+	for i, seg := range r.segments {
+		if !isParam(seg) {
+			if strings.ContainsAny(seg, "{}") {
+				return fmt.Errorf("Invalid path parameter %s in route %s.", seg, r.Path)
+			}
+			continue
+		}
+		if paramName(seg) == "" {
+			return fmt.Errorf("Invalid path parameter %s in route %s.", seg, r.Path)
+		}
+		if isGreedy(seg) && i != len(r.segments)-1 {
+			return fmt.Errorf("Greedy path parameter %s must be last in route %s.", seg, r.Path)
+		}
+	}
+	return nil
+}
+
+// Returns the path parameters if the route matches.
+func (r *Route) match(method string, segments []string) (map[string]string, bool) {
+	if r.Method != "ANY" && r.Method != method {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, seg := range r.segments {
+		if isGreedy(seg) {
+			// Greedy parameters need at least one segment.
+			if i >= len(segments) {
+				return nil, false
+			}
+			params[paramName(seg)] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if isParam(seg) {
+			params[paramName(seg)] = segments[i]
+		} else if seg != segments[i] {
+			return nil, false
+		}
+	}
+
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// How specific a route is, API Gateway prefers literal segments over
+// parameters, parameters over greedy parameters and methods over ANY.
+func (r *Route) specificity() int {
+	n := 0
+	for _, seg := range r.segments {
+		switch {
+		case isGreedy(seg):
+		case isParam(seg):
+			n += 2
+		default:
+			n += 3
+		}
+	}
+	n *= 2
+	if r.Method != "ANY" {
+		n++
+	}
+	return n
+}
+
+// Returns the path parameters of `path` for the resource path `resource`.
+func PathParameters(resource string, path string) (map[string]string, error) {
+	r := &Route{Path: resource}
+	if err := r.parse(); err != nil {
+		return nil, err
+	}
+
+	params, ok := r.match(r.Method, splitPath(path))
+	if !ok {
+		return nil, fmt.Errorf("Path %s does not match resource %s.", path, resource)
+	}
+	return params, nil
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+func isGreedy(seg string) bool {
+	return isParam(seg) && strings.HasSuffix(seg, "+}")
+}
+
+func paramName(seg string) string {
+	return strings.TrimSuffix(strings.Trim(seg, "{}"), "+")
+}
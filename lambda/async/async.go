@@ -0,0 +1,262 @@
+// Package async runs Event invocations in the background the way Lambda does:
+// failed invocations are retried with backoff, and invocations that fail
+// every attempt are written to a dead-letter store.
+package async
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/iron-io/lambda/lambda/trace"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 1000
+	// Lambda retries failed Event invocations twice, waiting about a minute
+	// before the first retry and two before the second.
+	DefaultMaxRetries = 2
+	DefaultBackoff    = time.Minute
+	// Lambda drops events it could not run within six hours.
+	DefaultMaxEventAge = 6 * time.Hour
+
+	// How long throttled invocations wait before trying again.
+	throttleRetryInterval = time.Second
+)
+
+var (
+	ErrorQueueFull        = errors.New("Async invocation queue is full")
+	ErrorDispatcherClosed = errors.New("Dispatcher is closed")
+	ErrorEventTooOld      = errors.New("Event was throttled until it exceeded the maximum event age")
+)
+
+type Invocation struct {
+	ID         string    `json:"id"`
+	Function   string    `json:"function"`
+	Payload    string    `json:"payload"`
+	Attempts   int       `json:"attempts"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	// Whether an earlier try was throttled, so throttles can be counted once
+	// per invocation.
+	Throttled bool `json:"throttled,omitempty"`
+	// The trace of the caller as a traceparent header. Empty starts a new
+	// trace.
+	Trace string `json:"trace,omitempty"`
+}
+
+// Runs a single attempt of an invocation.
+type RunFunc func(inv Invocation) error
+
+type Options struct {
+	Workers   int // Zero means DefaultWorkers.
+	QueueSize int // Zero means DefaultQueueSize.
+	// Zero means DefaultMaxRetries, negative means no retries.
+	MaxRetries int
+	// Wait before the first retry, doubled for every following one. Zero
+	// means DefaultBackoff.
+	Backoff time.Duration
+	// Throttled invocations older than this are dead-lettered instead of
+	// tried again. Zero means DefaultMaxEventAge.
+	MaxEventAge time.Duration
+	// Where invocations that failed every attempt go. If nil, they are only
+	// logged.
+	DeadLetters *DeadLetterStore
+}
+
+type Dispatcher struct {
+	run   RunFunc
+	opts  Options
+	queue chan *Invocation
+
+	mu      sync.Mutex
+	closed  bool
+	done    chan struct{}
+	workers sync.WaitGroup
+}
+
+func NewDispatcher(run RunFunc, opts Options) *Dispatcher {
+	if opts.Workers == 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.QueueSize == 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxEventAge == 0 {
+		opts.MaxEventAge = DefaultMaxEventAge
+	}
+
+	d := &Dispatcher{
+		run:   run,
+		opts:  opts,
+		queue: make(chan *Invocation, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+
+	for i := 0; i < opts.Workers; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Queues `payload` for `function` and returns the invocation ID.
+func (d *Dispatcher) Enqueue(function string, payload string) (string, error) {
+	return d.EnqueueWithTrace(function, payload, nil)
+}
+
+// Like Enqueue, with the invocation part of trace `tc`, a new trace if nil.
+func (d *Dispatcher) EnqueueWithTrace(function string, payload string, tc *trace.Context) (string, error) {
+	inv := &Invocation{
+		ID:         uuid.NewV4().String(),
+		Function:   function,
+		Payload:    payload,
+		EnqueuedAt: time.Now(),
+	}
+	if tc != nil {
+		inv.Trace = tc.Traceparent()
+	}
+	return inv.ID, d.enqueue(inv)
+}
+
+func (d *Dispatcher) enqueue(inv *Invocation) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrorDispatcherClosed
+	}
+
+	select {
+	case d.queue <- inv:
+		return nil
+	default:
+		return ErrorQueueFull
+	}
+}
+
+// Removes a dead letter from the store and queues it again with a fresh set
+// of attempts.
+func (d *Dispatcher) Replay(id string) error {
+	if d.opts.DeadLetters == nil {
+		return ErrorNoSuchDeadLetter
+	}
+
+	l, err := d.opts.DeadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+
+	inv := l.Invocation
+	inv.Attempts = 0
+	inv.Throttled = false
+	inv.EnqueuedAt = time.Now()
+	if err := d.enqueue(&inv); err != nil {
+		return err
+	}
+	return d.opts.DeadLetters.Delete(id)
+}
+
+// Stops accepting invocations and waits for running ones to finish. Queued
+// invocations and pending retries are dropped.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.mu.Unlock()
+
+	d.workers.Wait()
+}
+
+func (d *Dispatcher) work() {
+	defer d.workers.Done()
+	for {
+		select {
+		case <-d.done:
+			return
+		case inv := <-d.queue:
+			d.attempt(inv)
+		}
+	}
+}
+
+// Only failures of the function itself are retried, including those in a
+// sandbox. Errors from Docker and the like are unlikely to go away by
+// themselves.
+func retryable(err error) bool {
+	if sandboxErr, ok := err.(*lambda.SandboxError); ok {
+		err = sandboxErr.Err
+	}
+	if err == lambda.ErrorTimeout {
+		return true
+	}
+	_, ok := err.(*lambda.ExitError)
+	return ok
+}
+
+func (d *Dispatcher) attempt(inv *Invocation) {
+	err := d.run(*inv)
+	if err == nil {
+		return
+	}
+
+	// Throttled invocations stay queued until there is room, without using
+	// up an attempt.
+	if err == lambda.ErrorThrottled {
+		inv.Throttled = true
+		if time.Since(inv.EnqueuedAt) > d.opts.MaxEventAge {
+			d.deadLetter(inv, ErrorEventTooOld)
+			return
+		}
+		d.retryAfter(inv, throttleRetryInterval)
+		return
+	}
+
+	inv.Attempts++
+	if retryable(err) && inv.Attempts <= d.opts.MaxRetries {
+		backoff := d.opts.Backoff << uint(inv.Attempts-1)
+		log.Printf("Invocation %s of %s failed, retrying in %s: %s", inv.ID, inv.Function, backoff, err)
+		d.retryAfter(inv, backoff)
+		return
+	}
+
+	d.deadLetter(inv, err)
+}
+
+func (d *Dispatcher) retryAfter(inv *Invocation, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if err := d.enqueue(inv); err != nil && err != ErrorDispatcherClosed {
+			d.deadLetter(inv, err)
+		}
+	})
+}
+
+func (d *Dispatcher) deadLetter(inv *Invocation, err error) {
+	log.Printf("Invocation %s of %s failed after %d attempts: %s", inv.ID, inv.Function, inv.Attempts, err)
+	if d.opts.DeadLetters == nil {
+		return
+	}
+
+	l := &DeadLetter{
+		Invocation: *inv,
+		Error:      err.Error(),
+		FailedAt:   time.Now(),
+	}
+	if err := d.opts.DeadLetters.Put(l); err != nil {
+		log.Println("Could not store dead letter", inv.ID, err)
+	}
+}
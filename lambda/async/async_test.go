@@ -0,0 +1,169 @@
+package async
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iron-io/lambda/lambda"
+)
+
+func tempStore(t *testing.T) (*DeadLetterStore, func()) {
+	dir, err := ioutil.TempDir("", "lambda-dead-letters-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewDeadLetterStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, func() { os.RemoveAll(dir) }
+}
+
+// Waits for a dead letter to show up in the store.
+func waitForDeadLetters(t *testing.T, store *DeadLetterStore, n int) []*DeadLetter {
+	for i := 0; i < 300; i++ {
+		letters, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) >= n {
+			return letters
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for dead letters")
+	return nil
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	attempts := 0
+	fail := true
+	run := func(inv Invocation) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if fail {
+			return &lambda.ExitError{Code: 1}
+		}
+		return nil
+	}
+
+	d := NewDispatcher(run, Options{Backoff: time.Millisecond, DeadLetters: store})
+	defer d.Close()
+
+	id, err := d.Enqueue("hello", `{"a": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters := waitForDeadLetters(t, store, 1)
+	l := letters[0]
+	if l.ID != id || l.Function != "hello" || l.Payload != `{"a": 1}` || l.Attempts != 3 {
+		t.Fatal("Unexpected dead letter", l)
+	}
+	mu.Lock()
+	if attempts != 3 {
+		t.Fatal("Expected 3 attempts, got", attempts)
+	}
+	fail = false
+	mu.Unlock()
+
+	if err := d.Replay(id); err != nil {
+		t.Fatal("Replay failed", err)
+	}
+	if _, err := store.Get(id); err != ErrorNoSuchDeadLetter {
+		t.Fatal("Expected dead letter to be removed after replay", err)
+	}
+}
+
+func TestRetrySandboxViolations(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	violation := &lambda.SandboxError{Err: &lambda.ExitError{Code: 1}, Violation: "The function wrote outside /tmp, the only writable directory."}
+	d := NewDispatcher(func(inv Invocation) error {
+		return violation
+	}, Options{Backoff: time.Millisecond, DeadLetters: store})
+	defer d.Close()
+
+	d.Enqueue("hello", `{}`)
+	letters := waitForDeadLetters(t, store, 1)
+	if letters[0].Attempts != 3 || letters[0].Error != violation.Error() {
+		t.Fatal("Expected sandbox violations to be retried", letters[0])
+	}
+}
+
+func TestNoRetryOnOtherErrors(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	d := NewDispatcher(func(inv Invocation) error {
+		return errors.New("No such image")
+	}, Options{Backoff: time.Millisecond, DeadLetters: store})
+	defer d.Close()
+
+	d.Enqueue("hello", `{}`)
+	letters := waitForDeadLetters(t, store, 1)
+	if letters[0].Attempts != 1 || letters[0].Error != "No such image" {
+		t.Fatal("Unexpected dead letter", letters[0])
+	}
+}
+
+func TestDeadLetterStoreRejectsPaths(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	for _, id := range []string{"", "../passwd", ".tmp-123", `a\b`} {
+		if _, err := store.Get(id); err != ErrorNoSuchDeadLetter {
+			t.Error("Expected no dead letter for", id, err)
+		}
+	}
+}
+
+func TestThrottledInvocationsWait(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	calls := 0
+	d := NewDispatcher(func(inv Invocation) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			return lambda.ErrorThrottled
+		}
+		return &lambda.ExitError{Code: 1}
+	}, Options{MaxRetries: -1, Backoff: time.Millisecond, DeadLetters: store})
+	defer d.Close()
+
+	d.Enqueue("hello", `{}`)
+	letters := waitForDeadLetters(t, store, 1)
+	if letters[0].Attempts != 1 {
+		t.Fatal("Throttling should not use up an attempt", letters[0])
+	}
+}
+
+func TestThrottledInvocationsExpire(t *testing.T) {
+	store, cleanup := tempStore(t)
+	defer cleanup()
+
+	d := NewDispatcher(func(inv Invocation) error {
+		return lambda.ErrorThrottled
+	}, Options{MaxEventAge: time.Millisecond, DeadLetters: store})
+	defer d.Close()
+
+	d.Enqueue("hello", `{}`)
+	letters := waitForDeadLetters(t, store, 1)
+	if letters[0].Error != ErrorEventTooOld.Error() || letters[0].Attempts != 0 {
+		t.Fatal("Expected the throttled event to expire", letters[0])
+	}
+}
@@ -0,0 +1,120 @@
+package async
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrorNoSuchDeadLetter = errors.New("No such dead letter")
+
+// An invocation that failed on every attempt.
+type DeadLetter struct {
+	Invocation
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Keeps dead letters as one JSON file each in a directory, so they survive
+// restarts and can be inspected with regular tools.
+type DeadLetterStore struct {
+	dir string
+}
+
+func NewDeadLetterStore(dir string) (*DeadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DeadLetterStore{dir}, nil
+}
+
+func (s *DeadLetterStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *DeadLetterStore) Put(l *DeadLetter) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so List never sees partial letters.
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(l.ID))
+}
+
+func (s *DeadLetterStore) Get(id string) (*DeadLetter, error) {
+	// IDs come from users when replaying, don't let them escape the directory.
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, ErrorNoSuchDeadLetter
+	}
+
+	b, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrorNoSuchDeadLetter
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var l DeadLetter
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Returns all dead letters, oldest failure first.
+func (s *DeadLetterStore) List() ([]*DeadLetter, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := []*DeadLetter{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		l, err := s.Get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, l)
+	}
+
+	sort.Sort(byFailedAt(letters))
+	return letters, nil
+}
+
+func (s *DeadLetterStore) Delete(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return os.Remove(s.path(id))
+}
+
+type byFailedAt []*DeadLetter
+
+func (a byFailedAt) Len() int           { return len(a) }
+func (a byFailedAt) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byFailedAt) Less(i, j int) bool { return a[i].FailedAt.Before(a[j].FailedAt) }
@@ -0,0 +1,324 @@
+package lambda
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+const (
+	DefaultSTSEndpoint  = "https://sts.amazonaws.com"
+	DefaultRoleDuration = time.Hour
+
+	// Temporary credentials are renewed this long before they expire.
+	credentialsExpiryWindow = 5 * time.Minute
+	// Keys from the environment or a credentials file are read again after
+	// this long, so rotated keys are picked up.
+	credentialsReloadInterval = 5 * time.Minute
+)
+
+// Where the AWS credentials passed to functions come from. Like with the AWS
+// SDKs, the zero value forwards AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN from our environment if they are set, and otherwise uses
+// the profile AWS_PROFILE names, if any. Whenever a source is configured,
+// failing to get credentials from it is an error, we never fall back to the
+// environment.
+type CredentialsOptions struct {
+	// Use this profile of the shared credentials file instead of the
+	// environment.
+	Profile string
+	// Empty means AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials.
+	CredentialsFile string
+
+	// Assume this role with STS and pass the function the temporary
+	// credentials, like Lambda does with execution roles. The credentials
+	// above are used to call STS.
+	RoleARN    string
+	ExternalID string
+	// Empty means the function's image name.
+	SessionName string
+	// Zero means DefaultRoleDuration.
+	RoleDuration time.Duration
+	// Empty means DefaultSTSEndpoint.
+	STSEndpoint string
+
+	// Exchange credentials that are not temporary for a session token,
+	// valid for RoleDuration. Set by the CredentialsServer so it never hands
+	// out long-lived keys.
+	sessionToken bool
+}
+
+func (c *CredentialsOptions) validate() error {
+	if c.RoleARN == "" {
+		if c.ExternalID != "" || c.SessionName != "" {
+			return errors.New("Role options given without a role ARN.")
+		}
+	} else if !strings.HasPrefix(c.RoleARN, "arn:aws:iam::") || !strings.Contains(c.RoleARN, ":role/") {
+		return fmt.Errorf("Invalid role ARN %s.", c.RoleARN)
+	}
+	// STS limits.
+	if c.RoleDuration != 0 && (c.RoleDuration < 15*time.Minute || c.RoleDuration > 12*time.Hour) {
+		return fmt.Errorf("Invalid role duration %s. Should be between 15m and 12h.", c.RoleDuration)
+	}
+	return nil
+}
+
+// Credentials are cached by source so temporary ones are only renewed when
+// they are about to expire.
+var (
+	credentialSourcesMu sync.Mutex
+	credentialSources   = make(map[CredentialsOptions]*credentialSource)
+)
+
+type credentialSource struct {
+	creds *credentials.Credentials
+	// The keys from the environment or a credentials file, the same as creds
+	// unless they are used to get temporary credentials.
+	base   *credentials.Credentials
+	loaded time.Time
+	// Only set when getting temporary credentials from STS.
+	sts *stsProvider
+	// Whether missing credentials are fine, only for the environment.
+	optional bool
+}
+
+func getCredentialSource(opts CredentialsOptions) *credentialSource {
+	credentialSourcesMu.Lock()
+	defer credentialSourcesMu.Unlock()
+
+	if s, ok := credentialSources[opts]; ok {
+		if time.Since(s.loaded) > credentialsReloadInterval {
+			s.base.Expire()
+			s.loaded = time.Now()
+		}
+		return s
+	}
+
+	s := &credentialSource{loaded: time.Now()}
+	if opts.Profile != "" {
+		s.base = credentials.NewSharedCredentials(opts.CredentialsFile, opts.Profile)
+	} else {
+		s.base = credentials.NewEnvCredentials()
+		s.optional = true
+	}
+	s.creds = s.base
+
+	if opts.RoleARN != "" || opts.sessionToken {
+		s.sts = &stsProvider{
+			base:   s.base,
+			opts:   opts,
+			client: &http.Client{Timeout: 30 * time.Second},
+		}
+		s.creds = credentials.NewCredentials(s.sts)
+		s.optional = false
+	}
+
+	credentialSources[opts] = s
+	return s
+}
+
+// Returns the environment variables that pass AWS credentials to
+// `imageName`, and when they expire. The zero time means they do not.
+func credentialsEnv(imageName string, opts CredentialsOptions) ([]string, time.Time, error) {
+	v, expires, ok, err := functionCredentials(imageName, opts)
+	if !ok || err != nil {
+		return nil, time.Time{}, err
+	}
+
+	env := []string{
+		"AWS_ACCESS_KEY_ID=" + v.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + v.SecretAccessKey,
+	}
+	if v.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+v.SessionToken)
+	}
+	return env, expires, nil
+}
+
+// Returns the AWS credentials of `imageName` and when they expire. Not ok
+// means there are none, which is only fine for the environment.
+func functionCredentials(imageName string, opts CredentialsOptions) (credentials.Value, time.Time, bool, error) {
+	if err := opts.validate(); err != nil {
+		return credentials.Value{}, time.Time{}, false, err
+	}
+	if opts.RoleARN != "" && opts.SessionName == "" {
+		opts.SessionName = imageName
+	}
+	if opts.Profile == "" && os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		opts.Profile = os.Getenv("AWS_PROFILE")
+	}
+
+	s := getCredentialSource(opts)
+	v, err := s.creds.Get()
+	if err != nil {
+		if s.optional {
+			return credentials.Value{}, time.Time{}, false, nil
+		}
+		return credentials.Value{}, time.Time{}, false, s.error(opts, err)
+	}
+
+	var expires time.Time
+	if s.sts != nil {
+		expires = s.sts.expiration()
+	}
+	return v, expires, true, nil
+}
+
+func (s *credentialSource) error(opts CredentialsOptions, err error) error {
+	if opts.RoleARN != "" {
+		return fmt.Errorf("Could not assume role %s: %s", opts.RoleARN, err)
+	}
+	if opts.Profile == "" {
+		return fmt.Errorf("Could not get a session token: %s", err)
+	}
+	return fmt.Errorf("Could not load AWS credentials from profile %s: %s", opts.Profile, err)
+}
+
+// Gets temporary credentials from STS, for a role or, without one, a session
+// token for the base credentials.
+type stsProvider struct {
+	base   *credentials.Credentials
+	opts   CredentialsOptions
+	client *http.Client
+
+	mu      sync.Mutex
+	expires time.Time
+}
+
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+type stsResult struct {
+	AccessKeyID     string    `xml:"Credentials>AccessKeyId"`
+	SecretAccessKey string    `xml:"Credentials>SecretAccessKey"`
+	SessionToken    string    `xml:"Credentials>SessionToken"`
+	Expiration      time.Time `xml:"Credentials>Expiration"`
+}
+
+// The response of AssumeRole or GetSessionToken, only one result is set.
+type stsResponse struct {
+	AssumeRole      stsResult `xml:"AssumeRoleResult"`
+	GetSessionToken stsResult `xml:"GetSessionTokenResult"`
+}
+
+// Role session names only allow some characters.
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+func (p *stsProvider) Retrieve() (credentials.Value, error) {
+	base, err := p.base.Get()
+	if err != nil {
+		if p.opts.Profile != "" {
+			return credentials.Value{}, fmt.Errorf("Could not load AWS credentials from profile %s: %s", p.opts.Profile, err)
+		}
+		return credentials.Value{}, fmt.Errorf("No AWS credentials in the environment to call STS with: %s", err)
+	}
+
+	if p.opts.RoleARN == "" && base.SessionToken != "" {
+		// Temporary credentials can not get a session token. We do not know
+		// when they expire, so check back soon.
+		p.mu.Lock()
+		p.expires = time.Now().Add(2 * credentialsExpiryWindow)
+		p.mu.Unlock()
+		return base, nil
+	}
+
+	duration := p.opts.RoleDuration
+	if duration == 0 {
+		duration = DefaultRoleDuration
+	}
+	params := url.Values{
+		"Version":         {"2011-06-15"},
+		"DurationSeconds": {strconv.Itoa(int(duration / time.Second))},
+	}
+	if p.opts.RoleARN != "" {
+		sessionName := invalidSessionNameChars.ReplaceAllString(p.opts.SessionName, "-")
+		if len(sessionName) > 64 {
+			sessionName = sessionName[:64]
+		}
+		params.Set("Action", "AssumeRole")
+		params.Set("RoleArn", p.opts.RoleARN)
+		params.Set("RoleSessionName", sessionName)
+		if p.opts.ExternalID != "" {
+			params.Set("ExternalId", p.opts.ExternalID)
+		}
+	} else {
+		params.Set("Action", "GetSessionToken")
+	}
+
+	endpoint := p.opts.STSEndpoint
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	body := params.Encode()
+	r, err := http.NewRequest("POST", endpoint, strings.NewReader(body))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signer.Sign(r, []byte(body), base, "us-east-1", "sts", time.Now())
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e stsError
+		if err := xml.Unmarshal(b, &e); err != nil || e.Code == "" {
+			return credentials.Value{}, fmt.Errorf("STS returned %s", resp.Status)
+		}
+		return credentials.Value{}, fmt.Errorf("%s: %s", e.Code, e.Message)
+	}
+
+	var response stsResponse
+	if err := xml.Unmarshal(b, &response); err != nil {
+		return credentials.Value{}, fmt.Errorf("Invalid STS response: %s", err)
+	}
+	result := response.AssumeRole
+	if p.opts.RoleARN == "" {
+		result = response.GetSessionToken
+	}
+	if result.AccessKeyID == "" || result.SessionToken == "" {
+		return credentials.Value{}, errors.New("STS response has no credentials")
+	}
+
+	p.mu.Lock()
+	p.expires = result.Expiration
+	p.mu.Unlock()
+
+	return credentials.Value{
+		AccessKeyID:     result.AccessKeyID,
+		SecretAccessKey: result.SecretAccessKey,
+		SessionToken:    result.SessionToken,
+		ProviderName:    "STSProvider",
+	}, nil
+}
+
+func (p *stsProvider) IsExpired() bool {
+	return time.Now().Add(credentialsExpiryWindow).After(p.expiration())
+}
+
+func (p *stsProvider) expiration() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expires
+}
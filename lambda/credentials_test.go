@@ -0,0 +1,193 @@
+package lambda
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setEnv(t *testing.T, env map[string]string) func() {
+	old := make(map[string]string)
+	for k, v := range env {
+		old[k] = os.Getenv(k)
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func hasEnv(env []string, want string) bool {
+	for _, e := range env {
+		if e == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCredentialsEnvSessionToken(t *testing.T) {
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+		"AWS_SESSION_TOKEN":     "TOKEN",
+	})()
+
+	env, expires, err := credentialsEnv("user/fn", CredentialsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasEnv(env, "AWS_SESSION_TOKEN=TOKEN") || !hasEnv(env, "AWS_ACCESS_KEY_ID=AKID") {
+		t.Fatal("Expected session token to be forwarded", env)
+	}
+	if !expires.IsZero() {
+		t.Fatal("Environment credentials should not expire", expires)
+	}
+}
+
+func TestCredentialsEnvProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lambda-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "credentials")
+	ini := "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = x\n\n[dev]\naws_access_key_id = DEV\naws_secret_access_key = y\n"
+	if err := ioutil.WriteFile(file, []byte(ini), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, _, err := credentialsEnv("user/fn", CredentialsOptions{Profile: "dev", CredentialsFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasEnv(env, "AWS_ACCESS_KEY_ID=DEV") {
+		t.Fatal("Expected credentials of profile dev", env)
+	}
+
+	// A missing profile is an error, not a fall back to the environment.
+	_, _, err = credentialsEnv("user/fn", CredentialsOptions{Profile: "missing", CredentialsFile: file})
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatal("Expected error for missing profile", err)
+	}
+
+	// Without keys in the environment, AWS_PROFILE selects the profile, like
+	// it does for the AWS SDKs.
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID": "",
+		"AWS_PROFILE":       "dev",
+	})()
+	env, _, err = credentialsEnv("user/fn", CredentialsOptions{CredentialsFile: file})
+	if err != nil || !hasEnv(env, "AWS_ACCESS_KEY_ID=DEV") {
+		t.Fatal("Expected credentials of profile dev from AWS_PROFILE", env, err)
+	}
+
+	// Rotated keys are picked up once the cached ones are old enough.
+	ini = strings.Replace(ini, "DEV", "ROTATED", 1)
+	if err := ioutil.WriteFile(file, []byte(ini), 0600); err != nil {
+		t.Fatal(err)
+	}
+	credentialSourcesMu.Lock()
+	for _, s := range credentialSources {
+		s.loaded = s.loaded.Add(-credentialsReloadInterval - time.Second)
+	}
+	credentialSourcesMu.Unlock()
+	env, _, err = credentialsEnv("user/fn", CredentialsOptions{Profile: "dev", CredentialsFile: file})
+	if err != nil || !hasEnv(env, "AWS_ACCESS_KEY_ID=ROTATED") {
+		t.Fatal("Expected rotated credentials", env, err)
+	}
+}
+
+func TestCredentialsEnvAssumeRole(t *testing.T) {
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+		"AWS_SESSION_TOKEN":     "",
+	})()
+
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	calls := 0
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKID/") {
+			t.Error("Expected request signed with the environment credentials", r.Header.Get("Authorization"))
+		}
+		r.ParseForm()
+		if r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/fn" || r.Form.Get("RoleSessionName") != "user-fn" || r.Form.Get("ExternalId") != "ext" {
+			t.Error("Unexpected AssumeRole parameters", r.Form)
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>ROLESECRET</SecretAccessKey>
+<SessionToken>ROLETOKEN</SessionToken><Expiration>%s</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`, expiration.Format(time.RFC3339))
+	}))
+	defer sts.Close()
+
+	opts := CredentialsOptions{
+		RoleARN:     "arn:aws:iam::123456789012:role/fn",
+		ExternalID:  "ext",
+		STSEndpoint: sts.URL,
+	}
+	for i := 0; i < 2; i++ {
+		env, expires, err := credentialsEnv("user/fn", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hasEnv(env, "AWS_ACCESS_KEY_ID=ASIA") || !hasEnv(env, "AWS_SESSION_TOKEN=ROLETOKEN") {
+			t.Fatal("Expected role credentials", env)
+		}
+		if !expires.Equal(expiration) {
+			t.Fatal("Unexpected expiration", expires)
+		}
+	}
+	if calls != 1 {
+		t.Fatal("Expected role credentials to be cached, STS was called", calls, "times")
+	}
+}
+
+func TestCredentialsEnvAssumeRoleError(t *testing.T) {
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+	})()
+
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>Not authorized</Message></Error></ErrorResponse>`)
+	}))
+	defer sts.Close()
+
+	_, _, err := credentialsEnv("user/fn", CredentialsOptions{
+		RoleARN:     "arn:aws:iam::123456789012:role/denied",
+		STSEndpoint: sts.URL,
+	})
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatal("Expected STS error", err)
+	}
+}
+
+func TestCredentialsOptionsValidate(t *testing.T) {
+	invalid := []CredentialsOptions{
+		{ExternalID: "ext"},
+		{RoleARN: "fn"},
+		{RoleARN: "arn:aws:iam::123456789012:user/fn"},
+		{RoleARN: "arn:aws:iam::123456789012:role/fn", RoleDuration: time.Minute},
+		{RoleARN: "arn:aws:iam::123456789012:role/fn", RoleDuration: 13 * time.Hour},
+	}
+	for _, opts := range invalid {
+		if err := opts.validate(); err == nil {
+			t.Fatal("Expected error for invalid options", opts)
+		}
+	}
+}
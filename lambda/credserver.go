@@ -0,0 +1,174 @@
+package lambda
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const credentialsPath = "/credentials/"
+
+// Vends AWS credentials to functions over HTTP, like the ECS container
+// credentials endpoint. Containers get AWS_CONTAINER_CREDENTIALS_FULL_URI and
+// AWS_CONTAINER_AUTHORIZATION_TOKEN instead of keys, and the AWS SDKs fetch
+// and refresh temporary credentials from it, so no secret ends up in the
+// container's environment, the image or an IronWorker registration.
+//
+// Functions only get credentials for their own CredentialsOptions. Without a
+// role, our credentials are exchanged for a session token, so functions never
+// see long-lived keys either.
+type CredentialsServer struct {
+	url string
+	key []byte
+
+	mu        sync.Mutex
+	functions map[string]CredentialsOptions
+}
+
+// `url` is where containers reach the server, for example
+// http://169.254.170.2 or the docker bridge address. The authorization tokens
+// are derived from the key in `keyFile`, which is created if it does not
+// exist. Keep it to keep tokens that were registered with IronWorker valid.
+// Empty means a new key, and tokens, every time.
+func NewCredentialsServer(url string, keyFile string) (*CredentialsServer, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("Invalid credentials server URL %s.", url)
+	}
+
+	key, err := loadCredentialsKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CredentialsServer{
+		url:       strings.TrimSuffix(url, "/"),
+		key:       key,
+		functions: make(map[string]CredentialsOptions),
+	}, nil
+}
+
+func loadCredentialsKey(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err == nil {
+			if len(key) < 32 {
+				return nil, fmt.Errorf("Credentials key %s is too short.", keyFile)
+			}
+			return key, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if keyFile != "" {
+		if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// Allows `imageName` to get credentials for `opts` and returns the
+// environment variables that point it at the server.
+func (s *CredentialsServer) Allow(imageName string, opts CredentialsOptions) ([]string, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	opts.sessionToken = opts.RoleARN == ""
+
+	s.mu.Lock()
+	s.functions[imageName] = opts
+	s.mu.Unlock()
+
+	return []string{
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI=" + s.url + credentialsPath + imageName,
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN=" + s.token(imageName),
+	}, nil
+}
+
+func (s *CredentialsServer) token(imageName string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(imageName))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The ECS container credentials response.
+type containerCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+	RoleArn         string `json:"RoleArn,omitempty"`
+}
+
+type credentialsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *CredentialsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeCredentialsError(w, http.StatusMethodNotAllowed, "InvalidMethod", "Only GET is supported.")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, credentialsPath) {
+		writeCredentialsError(w, http.StatusNotFound, "NotFound", "Unknown path.")
+		return
+	}
+	imageName := strings.TrimPrefix(r.URL.Path, credentialsPath)
+
+	// Check the token before the function, so nobody can find out which
+	// functions exist.
+	token := r.Header.Get("Authorization")
+	if !hmac.Equal([]byte(token), []byte(s.token(imageName))) {
+		writeCredentialsError(w, http.StatusForbidden, "AccessDenied", "Invalid authorization token.")
+		return
+	}
+
+	s.mu.Lock()
+	opts, ok := s.functions[imageName]
+	s.mu.Unlock()
+	if !ok {
+		writeCredentialsError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("No credentials for %s.", imageName))
+		return
+	}
+
+	v, expires, ok, err := functionCredentials(imageName, opts)
+	if err == nil && !ok {
+		err = errors.New("No AWS credentials.")
+	}
+	if err != nil {
+		writeCredentialsError(w, http.StatusInternalServerError, "CredentialsError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&containerCredentials{
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		Token:           v.SessionToken,
+		Expiration:      expires.UTC().Format(time.RFC3339),
+		RoleArn:         opts.RoleARN,
+	})
+}
+
+func writeCredentialsError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&credentialsError{Code: code, Message: message})
+}
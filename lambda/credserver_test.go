@@ -0,0 +1,181 @@
+package lambda
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getContainerCredentials(t *testing.T, s *CredentialsServer, env []string) (int, *containerCredentials) {
+	var uri, token string
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		switch parts[0] {
+		case "AWS_CONTAINER_CREDENTIALS_FULL_URI":
+			uri = parts[1]
+		case "AWS_CONTAINER_AUTHORIZATION_TOKEN":
+			token = parts[1]
+		case "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN":
+			t.Fatal("Expected no keys in the environment", env)
+		}
+	}
+	if !strings.HasPrefix(uri, "http://169.254.170.2/credentials/") || token == "" {
+		t.Fatal("Expected credentials endpoint in the environment", env)
+	}
+
+	r, _ := http.NewRequest("GET", uri, nil)
+	r.Header.Set("Authorization", token)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	var creds containerCredentials
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &creds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, &creds
+}
+
+func TestCredentialsServerSessionToken(t *testing.T) {
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+		"AWS_SESSION_TOKEN":     "",
+	})()
+
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "GetSessionToken" {
+			t.Error("Expected GetSessionToken", r.Form)
+		}
+		fmt.Fprintf(w, `<GetSessionTokenResponse><GetSessionTokenResult><Credentials>
+<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>SESSIONSECRET</SecretAccessKey>
+<SessionToken>SESSIONTOKEN</SessionToken><Expiration>%s</Expiration>
+</Credentials></GetSessionTokenResult></GetSessionTokenResponse>`, expiration.Format(time.RFC3339))
+	}))
+	defer sts.Close()
+
+	s, err := NewCredentialsServer("http://169.254.170.2/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := s.Allow("user/fn", CredentialsOptions{STSEndpoint: sts.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, creds := getContainerCredentials(t, s, env)
+	if code != http.StatusOK {
+		t.Fatal("Unexpected status", code)
+	}
+	if creds.AccessKeyID != "ASIA" || creds.Token != "SESSIONTOKEN" || creds.Expiration != expiration.Format(time.RFC3339) {
+		t.Fatal("Expected session credentials", creds)
+	}
+}
+
+func TestCredentialsServerUnauthorized(t *testing.T) {
+	s, err := NewCredentialsServer("http://169.254.170.2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Allow("user/fn", CredentialsOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"/credentials/user/fn":    "wrong",
+		"/credentials/user/other": s.token("user/fn"),
+	}
+	for path, token := range cases {
+		r, _ := http.NewRequest("GET", "http://169.254.170.2"+path, nil)
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Fatal("Expected 403 for", path, "got", w.Code)
+		}
+	}
+
+	// Functions that were never allowed have no credentials, even with the
+	// right token.
+	r, _ := http.NewRequest("GET", "http://169.254.170.2/credentials/user/other", nil)
+	r.Header.Set("Authorization", s.token("user/other"))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatal("Expected 404, got", w.Code)
+	}
+}
+
+func TestCredentialsServerKeyFile(t *testing.T) {
+	if _, err := NewCredentialsServer("169.254.170.2", ""); err == nil {
+		t.Fatal("Expected error for URL without scheme")
+	}
+
+	dir, err := ioutil.TempDir("", "lambda-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "credentials.key")
+	a, err := NewCredentialsServer("http://169.254.170.2", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewCredentialsServer("http://169.254.170.2", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.token("user/fn") != b.token("user/fn") {
+		t.Fatal("Expected tokens to survive restarts with the same key file")
+	}
+	if a.token("user/fn") == a.token("user/other") {
+		t.Fatal("Expected tokens to differ by function")
+	}
+}
+
+func TestRegisterCredentials(t *testing.T) {
+	defer setEnv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKID",
+		"AWS_SECRET_ACCESS_KEY": "SECRET",
+		"AWS_SESSION_TOKEN":     "",
+	})()
+
+	register := func(opts RegisterOptions) (string, error) {
+		data, err := registerData("user/fn", "user/fn:1", opts)
+		if err != nil {
+			return "", err
+		}
+		body, err := json.Marshal(data)
+		return string(body), err
+	}
+
+	// Our keys are never registered.
+	body, err := register(RegisterOptions{})
+	if err != nil || strings.Contains(body, "AKID") || strings.Contains(body, "SECRET") {
+		t.Fatal("Expected no keys to be registered", body, err)
+	}
+
+	s, err := NewCredentialsServer("https://credentials.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = register(RegisterOptions{CredentialsServer: s})
+	if err != nil || strings.Contains(body, "SECRET") || !strings.Contains(body, "AWS_CONTAINER_CREDENTIALS_FULL_URI") {
+		t.Fatal("Expected only the credentials server to be registered", body, err)
+	}
+
+	if _, err := register(RegisterOptions{Credentials: CredentialsOptions{Profile: "dev"}}); err == nil {
+		t.Fatal("Expected credentials without a credentials server to be refused")
+	}
+}
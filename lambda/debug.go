@@ -0,0 +1,102 @@
+package lambda
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Tells the bootstraps to start the runtime's debugger on this port and wait
+// for it to attach: the node debugger (--debug-brk), ptvsd for python and JDWP
+// for java8.
+const debugPortEnv = "LAMBDA_DEBUG_PORT"
+
+// Written to stderr by the bootstraps once the debugger attached, right
+// before the function runs. The timeout starts then.
+const debuggerAttachedMarker = string(markerStart) + markerPrefix + "debugger attached\n"
+
+func validateDebugPort(port int, network NetworkOptions) error {
+	if port == 0 {
+		return nil
+	}
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("Invalid debug port %d.", port)
+	}
+	// Ports can not be published from internal networks or no network.
+	if network.Mode == NetworkNone || len(network.EgressAllow) > 0 {
+		return errors.New("Functions without network, or with egress rules, can not be debugged.")
+	}
+	return nil
+}
+
+// Makes the runtime's debugger listen on `port`, published on the same port
+// of the Docker host's loopback interface, since debuggers can run any code.
+func applyDebug(port int, config *docker.Config, hostConfig *docker.HostConfig) {
+	p := docker.Port(fmt.Sprintf("%d/tcp", port))
+	config.Env = append(config.Env, fmt.Sprintf("%s=%d", debugPortEnv, port))
+	config.ExposedPorts = map[docker.Port]struct{}{p: {}}
+	hostConfig.PortBindings = map[docker.Port][]docker.PortBinding{
+		p: {{HostIP: "127.0.0.1", HostPort: strconv.Itoa(port)}},
+	}
+}
+
+// Returns `w` without the debugger's attach marker, and a channel closed once
+// the marker was written.
+func watchAttach(w io.Writer) (io.Writer, <-chan struct{}) {
+	a := &attachWatcher{w: w, attached: make(chan struct{}), lineStart: true}
+	return a, a.attached
+}
+
+type attachWatcher struct {
+	w        io.Writer
+	attached chan struct{}
+	once     sync.Once
+
+	lineStart bool
+	// A line starting with markerStart, held back until it is complete.
+	marker []byte
+}
+
+func (a *attachWatcher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if a.marker == nil && a.lineStart && p[0] == markerStart {
+			a.marker = make([]byte, 0, len(debuggerAttachedMarker))
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		line := p
+		if i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+		a.lineStart = i >= 0
+
+		if a.marker == nil {
+			if _, err := a.w.Write(line); err != nil {
+				return n - len(p), err
+			}
+			continue
+		}
+
+		a.marker = append(a.marker, line...)
+		if i < 0 {
+			continue
+		}
+		marker := a.marker
+		a.marker = nil
+		if string(marker) == debuggerAttachedMarker {
+			a.once.Do(func() { close(a.attached) })
+			continue
+		}
+		if _, err := a.w.Write(marker); err != nil {
+			return n - len(p), err
+		}
+	}
+	return n, nil
+}
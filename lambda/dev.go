@@ -0,0 +1,173 @@
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Where the source directory of RunOptions.Source is mounted. The
+	// bootstraps load the function from there instead of /app, see
+	// sourceDirEnv.
+	devSourceDir = "/lambda-src"
+	sourceDirEnv = "LAMBDA_SOURCE_DIR"
+)
+
+// How often a DevRunner checks its source directory for changes.
+var DevPollInterval = 500 * time.Millisecond
+
+func validateSource(source string) error {
+	if source == "" {
+		return nil
+	}
+	if !path.IsAbs(source) || path.Clean(source) != source || strings.Contains(source, ":") {
+		return fmt.Errorf("Invalid source directory %s. Should be a clean absolute path.", source)
+	}
+	return nil
+}
+
+// Runs an image with its function's code taken from a local directory, see
+// RunOptions.Source, and runs it again with the last payload whenever a file
+// in the directory changes, so edits can be tried without CreateImage.
+// Invocations do not overlap.
+type DevRunner struct {
+	imageName string
+	opts      RunOptions
+
+	runMu sync.Mutex
+
+	mu      sync.Mutex
+	payload *string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Starts watching `opts.Source`, which must be set. The source is mounted on
+// the Docker host, so it has to be local to the daemon.
+func NewDevRunner(imageName string, opts RunOptions) (*DevRunner, error) {
+	if opts.Source == "" {
+		return nil, errors.New("A source directory is needed to watch.")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	snapshot, err := snapshotSource(opts.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DevRunner{
+		imageName: imageName,
+		opts:      opts,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go d.watch(snapshot)
+	return d, nil
+}
+
+// Runs the function with `payload`, which is run again on changes.
+func (d *DevRunner) Run(payload string) error {
+	d.mu.Lock()
+	d.payload = &payload
+	d.mu.Unlock()
+	return d.run(payload)
+}
+
+func (d *DevRunner) run(payload string) error {
+	d.runMu.Lock()
+	defer d.runMu.Unlock()
+	return RunImageWithOptions(d.imageName, payload, d.opts)
+}
+
+// Stops watching, after a run in progress finished.
+func (d *DevRunner) Close() error {
+	close(d.stop)
+	<-d.done
+	return nil
+}
+
+func (d *DevRunner) watch(last map[string]os.FileInfo) {
+	defer close(d.done)
+	ticker := time.NewTicker(DevPollInterval)
+	defer ticker.Stop()
+
+	changed := false
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+
+		snapshot, err := snapshotSource(d.opts.Source)
+		if err != nil {
+			// Likely a file removed while walking, the next poll sees it.
+			continue
+		}
+		if !sameSnapshot(last, snapshot) {
+			// Wait for the directory to settle, editors and builds write
+			// several files.
+			last = snapshot
+			changed = true
+			continue
+		}
+		if !changed {
+			continue
+		}
+		changed = false
+
+		d.mu.Lock()
+		payload := d.payload
+		d.mu.Unlock()
+		if payload == nil {
+			continue
+		}
+		fmt.Fprintf(d.opts.ErrorStream, "Source changed, running %s again\n", d.imageName)
+		if err := d.run(*payload); err != nil {
+			fmt.Fprintln(d.opts.ErrorStream, err)
+		}
+	}
+}
+
+// The files under `dir` by path. Hidden files and directories, like editor
+// swap files and .git, are left out.
+func snapshotSource(dir string) (map[string]os.FileInfo, error) {
+	snapshot := make(map[string]os.FileInfo)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			snapshot[p] = info
+		}
+		return nil
+	})
+	return snapshot, err
+}
+
+func sameSnapshot(a, b map[string]os.FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, info := range a {
+		other, ok := b[p]
+		if !ok || info.Size() != other.Size() || !info.ModTime().Equal(other.ModTime()) || info.Mode() != other.Mode() {
+			return false
+		}
+	}
+	return true
+}
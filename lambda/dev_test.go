@@ -0,0 +1,110 @@
+package lambda
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lambda-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := func() map[string]os.FileInfo {
+		s, err := snapshotSource(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	write("index.js", "exports.handler = function() {}")
+	write("lib/util.js", "")
+	before := snapshot()
+	if len(before) != 2 {
+		t.Fatal("Expected both files in the snapshot", before)
+	}
+
+	// Hidden files, like editor swap files and .git, are not watched.
+	write(".index.js.swp", "x")
+	write(".git/HEAD", "ref: refs/heads/master")
+	if !sameSnapshot(before, snapshot()) {
+		t.Fatal("Expected hidden files to be ignored")
+	}
+
+	write("lib/util.js", "module.exports = 1")
+	if sameSnapshot(before, snapshot()) {
+		t.Fatal("Expected a changed file to change the snapshot")
+	}
+
+	before = snapshot()
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "index.js"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if sameSnapshot(before, snapshot()) {
+		t.Fatal("Expected a touched file to change the snapshot")
+	}
+
+	before = snapshot()
+	os.Remove(filepath.Join(dir, "lib/util.js"))
+	if sameSnapshot(before, snapshot()) {
+		t.Fatal("Expected a removed file to change the snapshot")
+	}
+}
+
+func TestNewDevRunner(t *testing.T) {
+	if _, err := NewDevRunner("user/fn", RunOptions{}); err == nil {
+		t.Fatal("Expected an error without a source directory")
+	}
+	if _, err := NewDevRunner("user/fn", RunOptions{Source: "/does/not/exist"}); err == nil {
+		t.Fatal("Expected an error for a missing source directory")
+	}
+}
+
+func TestDevSourceNodeModules(t *testing.T) {
+	name := "iron-test/lambda-nodejs-dev"
+	err := buildTestFunction(name, "test.run", map[string]string{
+		"test.js":                   `exports.run = function(event, context) { context.succeed("image") }`,
+		"node_modules/dep/index.js": `module.exports = "dep"`,
+	})
+	if err != nil {
+		t.Fatal("CreateImage failed", err)
+	}
+	defer client.RemoveImage(name)
+
+	dir, err := ioutil.TempDir("", "lambda-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// aws-sdk comes with the image, dep with the function.
+	handler := `exports.run = function(event, context) { context.succeed(typeof require("aws-sdk").KMS + " " + require("dep")) }`
+	if err := ioutil.WriteFile(filepath.Join(dir, "test.js"), []byte(handler), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := RunImageWithOptions(name, `{}`, RunOptions{Source: dir, OutputStream: &stdout, ErrorStream: &stderr}); err != nil {
+		t.Fatal("Expected the function to load its modules", err, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"function dep"`) {
+		t.Fatal("Unexpected output", stdout.String())
+	}
+}
@@ -0,0 +1,277 @@
+// Package egress implements the proxy that enforces egress allow-lists of
+// functions. Functions with an allow-list run on an internal Docker network,
+// which has no route out, with the proxy as the only container on it that can
+// reach other networks.
+package egress
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where functions find the proxy on their network.
+const (
+	ProxyHost = "egress"
+	ProxyPort = "3128"
+)
+
+// Entries are host names, wildcard domains like *.amazonaws.com, IP
+// addresses or CIDR blocks, each optionally with a port:
+//
+//	s3.amazonaws.com
+//	*.amazonaws.com:443
+//	10.0.0.0/8
+//	[2001:db8::1]:5432
+type AllowList struct {
+	entries []string
+	rules   []rule
+}
+
+type rule struct {
+	host     string // Lower case, without the "*." of wildcards, which only match subdomains.
+	wildcard bool
+	ipnet    *net.IPNet
+	port     string // Empty means any port.
+}
+
+func ParseAllowList(entries []string) (AllowList, error) {
+	l := AllowList{entries: append([]string(nil), entries...)}
+	sort.Strings(l.entries)
+
+	for _, e := range entries {
+		r, err := parseRule(strings.TrimSpace(e))
+		if err != nil {
+			return AllowList{}, err
+		}
+		l.rules = append(l.rules, r)
+	}
+	return l, nil
+}
+
+func parseRule(entry string) (rule, error) {
+	host, port := entry, ""
+	if strings.HasPrefix(entry, "[") {
+		i := strings.Index(entry, "]")
+		if i < 0 {
+			return rule{}, fmt.Errorf("Invalid egress rule %s.", entry)
+		}
+		host, port = entry[1:i], strings.TrimPrefix(entry[i+1:], ":")
+	} else if i := strings.LastIndex(entry, ":"); i >= 0 && strings.Count(entry, ":") == 1 {
+		host, port = entry[:i], entry[i+1:]
+	}
+	if port != "" {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return rule{}, fmt.Errorf("Invalid port in egress rule %s.", entry)
+		}
+	}
+
+	r := rule{port: port}
+	if strings.Contains(host, "/") {
+		_, ipnet, err := net.ParseCIDR(host)
+		if err != nil {
+			return rule{}, fmt.Errorf("Invalid CIDR block in egress rule %s.", entry)
+		}
+		r.ipnet = ipnet
+		return r, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		r.ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return r, nil
+	}
+
+	host = strings.ToLower(host)
+	if strings.HasPrefix(host, "*.") {
+		r.wildcard = true
+		host = host[2:]
+	}
+	if host == "" || strings.ContainsAny(host, "*/ ") {
+		return rule{}, fmt.Errorf("Invalid host in egress rule %s.", entry)
+	}
+	r.host = host
+	return r, nil
+}
+
+// The entries the list was parsed from, sorted.
+func (l AllowList) Entries() []string {
+	return l.entries
+}
+
+func (l AllowList) allowsName(host string, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, r := range l.rules {
+		if r.host == "" || (r.port != "" && r.port != port) {
+			continue
+		}
+		if (!r.wildcard && host == r.host) || (r.wildcard && strings.HasSuffix(host, "."+r.host)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l AllowList) allowsIP(ip net.IP, port string) bool {
+	for _, r := range l.rules {
+		if r.ipnet != nil && (r.port == "" || r.port == port) && r.ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether connections to `host`, a name or an IP address, and `port` are
+// allowed. Names are not resolved, see Proxy for names allowed by their
+// addresses.
+func (l AllowList) Allows(host string, port string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		return l.allowsIP(ip, port)
+	}
+	return l.allowsName(host, port)
+}
+
+// An HTTP proxy that only connects to destinations on its allow-list, for
+// plain HTTP requests and CONNECT tunnels. Names are allowed by a name rule,
+// or by a CIDR or IP rule matching an address they resolve to, in which case
+// the proxy connects to that address.
+type Proxy struct {
+	allow     AllowList
+	transport *http.Transport
+
+	// Replaced in tests.
+	lookupIP func(host string) ([]net.IP, error)
+}
+
+func NewProxy(allow AllowList) *Proxy {
+	p := &Proxy{allow: allow, lookupIP: net.LookupIP}
+	p.transport = &http.Transport{Dial: p.dial, TLSHandshakeTimeout: 10 * time.Second}
+	return p
+}
+
+// Returned when the destination is not on the allow-list.
+type ForbiddenError struct {
+	Addr string
+}
+
+func (e *ForbiddenError) Error() string {
+	return fmt.Sprintf("Egress to %s is not allowed.", e.Addr)
+}
+
+func (p *Proxy) dial(network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if p.allow.Allows(host, port) {
+		return net.DialTimeout(network, addr, 30*time.Second)
+	}
+
+	if net.ParseIP(host) == nil {
+		ips, err := p.lookupIP(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if p.allow.allowsIP(ip, port) {
+				return net.DialTimeout(network, net.JoinHostPort(ip.String(), port), 30*time.Second)
+			}
+		}
+	}
+	return nil, &ForbiddenError{addr}
+}
+
+// Headers that only apply to a single connection.
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "CONNECT" {
+		p.serveConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "Only proxy requests are served.", http.StatusBadRequest)
+		return
+	}
+
+	out := new(http.Request)
+	*out = *r
+	out.RequestURI = ""
+	out.Header = make(http.Header)
+	for k, v := range r.Header {
+		out.Header[k] = v
+	}
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		writeDialError(w, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p *Proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial("tcp", r.Host)
+	if err != nil {
+		writeDialError(w, err)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Tunnels are not supported.", http.StatusInternalServerError)
+		return
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buffered)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	// Either side closing ends the tunnel.
+	<-done
+}
+
+func writeDialError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*ForbiddenError); ok {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadGateway)
+}
@@ -0,0 +1,148 @@
+package egress
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAllowList(t *testing.T) {
+	l, err := ParseAllowList([]string{"s3.amazonaws.com", "*.Example.com:443", "10.0.0.0/8", "192.168.1.5:5432", "[2001:db8::1]:80"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		host, port string
+		allowed    bool
+	}{
+		{"s3.amazonaws.com", "443", true},
+		{"S3.amazonaws.com.", "80", true},
+		{"sqs.amazonaws.com", "443", false},
+		{"api.example.com", "443", true},
+		{"a.b.example.com", "443", true},
+		{"example.com", "443", false},
+		{"api.example.com", "80", false},
+		{"10.1.2.3", "22", true},
+		{"11.1.2.3", "22", false},
+		{"192.168.1.5", "5432", true},
+		{"192.168.1.5", "22", false},
+		{"2001:db8::1", "80", true},
+	} {
+		if l.Allows(c.host, c.port) != c.allowed {
+			t.Error("Unexpected decision for", c.host, c.port)
+		}
+	}
+
+	for _, entry := range []string{"", "*", "a.com:http2", "10.0.0.0/33", "[::1", "a b.com", "*.*.com"} {
+		if _, err := ParseAllowList([]string{entry}); err == nil {
+			t.Error("Expected error for rule", entry)
+		}
+	}
+}
+
+func proxyClient(proxy *httptest.Server) *http.Client {
+	proxyURL, _ := url.Parse(proxy.URL)
+	return &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+}
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from ", r.Host)
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	_, port, _ := net.SplitHostPort(backendURL.Host)
+
+	allow, err := ParseAllowList([]string{"127.0.0.0/8:" + port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProxy(allow)
+	p.lookupIP = func(host string) ([]net.IP, error) {
+		if host == "backend.internal" {
+			return []net.IP{net.ParseIP("127.0.0.1")}, nil
+		}
+		return nil, fmt.Errorf("No such host %s", host)
+	}
+	proxy := httptest.NewServer(p)
+	defer proxy.Close()
+	client := proxyClient(proxy)
+
+	// Allowed by address, and by the address a name resolves to.
+	for _, u := range []string{backend.URL, "http://backend.internal:" + port} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), "hello from") {
+			t.Fatal("Unexpected response", u, resp.StatusCode, string(body))
+		}
+	}
+
+	// Other ports are not allowed.
+	resp, err := client.Get("http://127.0.0.1:1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected forbidden, got", resp.StatusCode)
+	}
+}
+
+func TestProxyConnect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "tunneled")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+
+	allow, _ := ParseAllowList([]string{"127.0.0.1"})
+	proxy := httptest.NewServer(NewProxy(allow))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	connect := func(addr string) (net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, resp
+	}
+
+	conn, resp := connect(backendURL.Host)
+	defer conn.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected tunnel, got", resp.StatusCode)
+	}
+	// Plain HTTP through the tunnel stands in for TLS.
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", backendURL.Host)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "tunneled" {
+		t.Fatal("Unexpected response through tunnel", string(body))
+	}
+
+	denied, resp := connect("10.0.0.1:443")
+	denied.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected forbidden tunnel, got", resp.StatusCode)
+	}
+}
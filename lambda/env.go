@@ -0,0 +1,121 @@
+package lambda
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/secrets"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Set by us or the bootstraps, functions can not override them.
+var reservedEnvPrefixes = []string{
+	"AWS_LAMBDA_",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_CONTAINER_",
+	"TASK_",
+	"PAYLOAD_FILE",
+	"LAMBDA_KMS_",
+}
+
+func validateEnv(env map[string]string) error {
+	for name := range env {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("Invalid environment variable name %q.", name)
+		}
+		for _, prefix := range reservedEnvPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return fmt.Errorf("Environment variable %s is reserved.", name)
+			}
+		}
+	}
+	return nil
+}
+
+// The function an image belongs to, its name without the tag. Encrypted
+// environment variables are bound to it.
+func functionName(imageName string) string {
+	if i := strings.LastIndex(imageName, ":"); i > strings.LastIndex(imageName, "/") {
+		return imageName[:i]
+	}
+	return imageName
+}
+
+var (
+	defaultSecretsOnce sync.Once
+	defaultSecrets     *secrets.Resolver
+	defaultSecretsErr  error
+)
+
+// Replaces references to secrets in `env` with the secrets. Nil `resolver`
+// means the default secret store.
+func resolveEnv(env map[string]string, resolver *secrets.Resolver) (map[string]string, error) {
+	if resolver != nil {
+		return resolver.ResolveEnv(env)
+	}
+
+	for _, v := range env {
+		if secrets.IsReference(v) {
+			defaultSecretsOnce.Do(func() {
+				defaultSecrets, defaultSecretsErr = secrets.NewResolver(secrets.Options{})
+			})
+			if defaultSecretsErr != nil {
+				return nil, defaultSecretsErr
+			}
+			return defaultSecrets.ResolveEnv(env)
+		}
+	}
+	return env, nil
+}
+
+// Returns the environment variables of `imageName` with secret references
+// resolved and encrypted values decrypted, those of `opts` and the image's
+// own. The image's plain variables are left to docker.
+func functionEnv(imageName string, opts RunOptions) ([]string, error) {
+	client, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+	image, err := client.InspectImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageEnv []string
+	if image.Config != nil {
+		imageEnv = image.Config.Env
+	}
+	return mergeFunctionEnv(imageName, imageEnv, opts)
+}
+
+// Encrypted values fail without opts.EnvKey, rather than reach the function
+// as ciphertext.
+func mergeFunctionEnv(imageName string, imageEnv []string, opts RunOptions) ([]string, error) {
+	env := make(map[string]string)
+	for _, e := range imageEnv {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 && envcrypt.IsEncrypted(parts[1]) {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	resolved, err := resolveEnv(opts.Environment, opts.Secrets)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", imageName, err)
+	}
+	for name, v := range resolved {
+		env[name] = v
+	}
+
+	decrypted, err := envcrypt.DecryptEnv(opts.EnvKey, functionName(imageName), envcrypt.List(env))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", imageName, err)
+	}
+	return decrypted, nil
+}
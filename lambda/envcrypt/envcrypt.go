@@ -0,0 +1,110 @@
+// Package envcrypt encrypts the values of function environment variables, so
+// secrets never appear in plaintext in images or IronWorker registrations.
+//
+// Values are encrypted for one function, with a local key file or a
+// KMS-compatible endpoint, and decrypted by the runner just before the
+// function's container starts, or, for values registered with IronWorker, by
+// the bootstrap with KMS:
+//
+//	key, err := envcrypt.NewKeyFile("./env.key")
+//	v, err := envcrypt.EncryptValue(key, "user/fn", "hunter2")
+//	// v is "encrypted:..." and can go into images and configs.
+//	plaintext, err := envcrypt.DecryptValue(key, "user/fn", v)
+package envcrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Encrypted values start with Prefix, followed by the base64 encoded
+// ciphertext.
+const Prefix = "encrypted:"
+
+// Returned when decrypting values without a key.
+var ErrorNoKey = errors.New("No key to decrypt environment variables with.")
+
+// Encrypts and decrypts the values of `function`. Ciphertext for one function
+// does not decrypt for another.
+type Key interface {
+	Encrypt(function string, plaintext []byte) ([]byte, error)
+	Decrypt(function string, ciphertext []byte) ([]byte, error)
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+func EncryptValue(key Key, function string, plaintext string) (string, error) {
+	ciphertext, err := key.Encrypt(function, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Returns `value` unchanged if it is not encrypted.
+func DecryptValue(key Key, function string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if key == nil {
+		return "", ErrorNoKey
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", errors.New("Invalid encrypted value.")
+	}
+	plaintext, err := key.Decrypt(function, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Encrypts the values of `env` that are not encrypted yet.
+func EncryptEnv(key Key, function string, env map[string]string) (map[string]string, error) {
+	encrypted := make(map[string]string)
+	for name, v := range env {
+		if !IsEncrypted(v) {
+			var err error
+			if v, err = EncryptValue(key, function, v); err != nil {
+				return nil, fmt.Errorf("Could not encrypt %s: %s", name, err)
+			}
+		}
+		encrypted[name] = v
+	}
+	return encrypted, nil
+}
+
+// Decrypts the encrypted values of `env`, a list of NAME=value entries as
+// docker takes them. Entries that are not encrypted are left alone.
+func DecryptEnv(key Key, function string, env []string) ([]string, error) {
+	decrypted := make([]string, 0, len(env))
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 && IsEncrypted(parts[1]) {
+			v, err := DecryptValue(key, function, parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("Could not decrypt %s: %s", parts[0], err)
+			}
+			e = parts[0] + "=" + v
+		}
+		decrypted = append(decrypted, e)
+	}
+	return decrypted, nil
+}
+
+// Returns the NAME=value entries of `env`, sorted by name.
+func List(env map[string]string) []string {
+	var list []string
+	for name, v := range env {
+		list = append(list, name+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
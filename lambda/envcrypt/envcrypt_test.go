@@ -0,0 +1,144 @@
+package envcrypt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+func newKeyFile(t *testing.T, dir string, name string) *KeyFile {
+	path := filepath.Join(dir, name)
+	if err := GenerateKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateKeyFile(path); err == nil {
+		t.Fatal("Expected error overwriting a key file")
+	}
+	k, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := newKeyFile(t, dir, "a.key")
+	v, err := EncryptValue(key, "user/fn", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(v) || strings.Contains(v, "hunter2") {
+		t.Fatal("Expected encrypted value", v)
+	}
+
+	plaintext, err := DecryptValue(key, "user/fn", v)
+	if err != nil || plaintext != "hunter2" {
+		t.Fatal("Unexpected plaintext", plaintext, err)
+	}
+
+	// Values are bound to the function and the key.
+	if _, err := DecryptValue(key, "user/other", v); err == nil {
+		t.Fatal("Expected error decrypting for another function")
+	}
+	if _, err := DecryptValue(newKeyFile(t, dir, "b.key"), "user/fn", v); err == nil {
+		t.Fatal("Expected error decrypting with another key")
+	}
+	if _, err := DecryptValue(nil, "user/fn", v); err != ErrorNoKey {
+		t.Fatal("Expected ErrorNoKey, got", err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := newKeyFile(t, dir, "a.key")
+
+	env, err := EncryptEnv(key, "user/fn", map[string]string{"CONFIG_PASSWORD": "hunter2", "CONFIG_USER": "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Encrypted values are not encrypted again.
+	again, err := EncryptEnv(key, "user/fn", env)
+	if err != nil || again["CONFIG_USER"] != env["CONFIG_USER"] {
+		t.Fatal("Expected encrypted values to be kept", err)
+	}
+
+	list := append(List(env), "PLAIN=value")
+	decrypted, err := DecryptEnv(key, "user/fn", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"CONFIG_PASSWORD=hunter2", "CONFIG_USER=root", "PLAIN=value"}
+	if strings.Join(decrypted, " ") != strings.Join(expected, " ") {
+		t.Fatal("Unexpected environment", decrypted)
+	}
+
+	if _, err := DecryptEnv(nil, "user/fn", list); err == nil || !strings.Contains(err.Error(), "CONFIG_PASSWORD") {
+		t.Fatal("Expected error naming the variable", err)
+	}
+}
+
+// A KMS stand-in that "encrypts" by prefixing the encryption context.
+func fakeKMS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req kmsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		prefix := req.EncryptionContext["LambdaFunctionName"] + ":"
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(&kmsResponse{CiphertextBlob: append([]byte(prefix), req.Plaintext...)})
+		case "TrentService.Decrypt":
+			if !strings.HasPrefix(string(req.CiphertextBlob), prefix) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type": "InvalidCiphertextException", "message": "Wrong context"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(&kmsResponse{Plaintext: req.CiphertextBlob[len(prefix):]})
+		default:
+			t.Error("Unexpected target", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+}
+
+func TestKMS(t *testing.T) {
+	srv := fakeKMS(t)
+	defer srv.Close()
+
+	key, err := NewKMS(KMSOptions{KeyID: "alias/lambda", JSONOptions: signer.JSONOptions{Endpoint: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := EncryptValue(key, "user/fn", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := DecryptValue(key, "user/fn", v)
+	if err != nil || plaintext != "hunter2" {
+		t.Fatal("Unexpected plaintext", plaintext, err)
+	}
+
+	_, err = DecryptValue(key, "user/other", v)
+	if err == nil || !strings.Contains(err.Error(), "InvalidCiphertextException") {
+		t.Fatal("Expected KMS error", err)
+	}
+}
@@ -0,0 +1,79 @@
+package envcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// A 256 bit AES key, kept base64 encoded in a file. Values are encrypted
+// with AES-GCM, authenticating the function name.
+type KeyFile struct {
+	aead cipher.AEAD
+}
+
+// Writes a new random key to `path`, which must not exist.
+func GenerateKeyFile(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func NewKeyFile(path string) (*KeyFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("Invalid key file %s, expected a base64 encoded 256 bit key.", path)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFile{aead: aead}, nil
+}
+
+// The ciphertext is the random nonce followed by the sealed plaintext.
+func (k *KeyFile) Encrypt(function string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, []byte(function)), nil
+}
+
+func (k *KeyFile) Decrypt(function string, ciphertext []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, errors.New("Ciphertext too short.")
+	}
+	plaintext, err := k.aead.Open(nil, ciphertext[:n], ciphertext[n:], []byte(function))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt with the key file, was the value encrypted for %s with another key?", function)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,94 @@
+package envcrypt
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+type KMSOptions struct {
+	// The key to encrypt with, an ID, ARN or alias. Decrypting does not need
+	// it.
+	KeyID string
+	signer.JSONOptions
+}
+
+// Encrypts with a KMS-compatible endpoint. Like Lambda, the function name is
+// the encryption context, {"LambdaFunctionName": "<function>"}, which is
+// what the bootstraps decrypt values registered with IronWorker with.
+type KMS struct {
+	keyID    string
+	endpoint string
+	client   *signer.JSONClient
+}
+
+func NewKMS(opts KMSOptions) (*KMS, error) {
+	client, err := signer.NewJSONClient("kms", "TrentService", opts.JSONOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &KMS{keyID: opts.KeyID, endpoint: opts.Endpoint, client: client}, nil
+}
+
+func (k *KMS) Region() string {
+	return k.client.Region()
+}
+
+// Empty means the AWS endpoint of the region.
+func (k *KMS) Endpoint() string {
+	return k.endpoint
+}
+
+type kmsRequest struct {
+	KeyID             string            `json:"KeyId,omitempty"`
+	Plaintext         []byte            `json:"Plaintext,omitempty"`
+	CiphertextBlob    []byte            `json:"CiphertextBlob,omitempty"`
+	EncryptionContext map[string]string `json:"EncryptionContext"`
+}
+
+type kmsResponse struct {
+	Plaintext      []byte `json:"Plaintext"`
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+}
+
+func encryptionContext(function string) map[string]string {
+	return map[string]string{"LambdaFunctionName": function}
+}
+
+func (k *KMS) Encrypt(function string, plaintext []byte) ([]byte, error) {
+	if k.keyID == "" {
+		return nil, errors.New("No KMS key to encrypt with.")
+	}
+	resp, err := k.do("Encrypt", &kmsRequest{
+		KeyID:             k.keyID,
+		Plaintext:         plaintext,
+		EncryptionContext: encryptionContext(function),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+func (k *KMS) Decrypt(function string, ciphertext []byte) ([]byte, error) {
+	resp, err := k.do("Decrypt", &kmsRequest{
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: encryptionContext(function),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (k *KMS) do(action string, req *kmsRequest) (*kmsResponse, error) {
+	var resp kmsResponse
+	if err := k.client.Do(action, req, &resp); err != nil {
+		if e, ok := err.(*signer.ServiceError); ok {
+			return nil, fmt.Errorf("KMS %s failed: %s", action, e)
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,118 @@
+// Package events generates the payloads AWS event sources send to Lambda
+// functions, for testing functions locally.
+//
+//	payload, err := events.Generate("s3-put", events.Params{"bucket": "photos", "key": "cat.jpg"})
+//	err = lambda.RunImageWithPayload("user/resize", string(payload))
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	region  = "us-east-1"
+	account = "123456789012"
+)
+
+// Values for the parameters of a template, by name. Missing parameters take
+// their default.
+type Params map[string]string
+
+type Param struct {
+	Name        string
+	Default     string
+	Description string
+}
+
+// Generates the event of one event source.
+type Template struct {
+	Name        string
+	Description string
+	Params      []Param
+
+	generate func(p Params) (interface{}, error)
+}
+
+var templates = make(map[string]*Template)
+
+func register(t *Template) {
+	templates[t.Name] = t
+}
+
+// All templates, sorted by name.
+func Templates() []*Template {
+	var list []*Template
+	for _, t := range templates {
+		list = append(list, t)
+	}
+	sort.Sort(byName(list))
+	return list
+}
+
+type byName []*Template
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func Lookup(name string) (*Template, bool) {
+	t, ok := templates[name]
+	return t, ok
+}
+
+// Generates the JSON payload of template `name`.
+func Generate(name string, params Params) ([]byte, error) {
+	t, ok := templates[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown event template %s.", name)
+	}
+	return t.Generate(params)
+}
+
+func (t *Template) Generate(params Params) ([]byte, error) {
+	p := make(Params)
+	for _, param := range t.Params {
+		p[param.Name] = param.Default
+	}
+	for name, v := range params {
+		if _, ok := p[name]; !ok {
+			return nil, fmt.Errorf("Unknown parameter %s for event template %s, expected one of %s.", name, t.Name, strings.Join(t.paramNames(), ", "))
+		}
+		p[name] = v
+	}
+
+	event, err := t.generate(p)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", t.Name, err)
+	}
+	return json.MarshalIndent(event, "", "  ")
+}
+
+func (t *Template) paramNames() []string {
+	var names []string
+	for _, p := range t.Params {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func (p Params) int(name string) (int, error) {
+	v, err := strconv.Atoi(p[name])
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("Parameter %s must be a non-negative number, got %q.", name, p[name])
+	}
+	return v, nil
+}
+
+// Like int, but at least 1.
+func (p Params) count(name string) (int, error) {
+	v, err := p.int(name)
+	if err == nil && v == 0 {
+		err = fmt.Errorf("Parameter %s must be at least 1.", name)
+	}
+	return v, err
+}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAllTemplates(t *testing.T) {
+	if len(Templates()) < 9 {
+		t.Fatal("Expected templates for all event sources")
+	}
+
+	for _, tmpl := range Templates() {
+		b, err := Generate(tmpl.Name, nil)
+		if err != nil {
+			t.Fatal(tmpl.Name, err)
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			t.Fatal(tmpl.Name, "generated invalid JSON", err)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	b, err := Generate("s3-put", Params{"bucket": "photos", "key": "my cat.jpg", "size": "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s3 struct {
+		Records []struct {
+			EventName string
+			S3        struct {
+				Bucket struct{ Name string }
+				Object struct {
+					Key  string
+					Size int
+					ETag string
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &s3); err != nil {
+		t.Fatal(err)
+	}
+	r := s3.Records[0]
+	if r.EventName != "ObjectCreated:Put" || r.S3.Bucket.Name != "photos" || r.S3.Object.Key != "my+cat.jpg" || r.S3.Object.Size != 5 || r.S3.Object.ETag == "" {
+		t.Fatal("Unexpected S3 record", r)
+	}
+
+	b, err = Generate("kinesis", Params{"data": "hello", "count": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinesis struct {
+		Records []struct {
+			Kinesis struct{ Data string }
+		}
+	}
+	json.Unmarshal(b, &kinesis)
+	if len(kinesis.Records) != 3 || kinesis.Records[0].Kinesis.Data != "aGVsbG8=" {
+		t.Fatal("Unexpected Kinesis records", kinesis)
+	}
+
+	b, err = Generate("apigateway", Params{"method": "post", "path": "/users/42?verbose=1", "resource": "/users/{id}", "body": `{"name": "x"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var api struct {
+		HTTPMethod            string
+		Resource              string
+		Path                  string
+		PathParameters        map[string]string
+		QueryStringParameters map[string]string
+		Body                  string
+	}
+	json.Unmarshal(b, &api)
+	if api.HTTPMethod != "POST" || api.Resource != "/users/{id}" || api.Path != "/users/42" || api.PathParameters["id"] != "42" || api.QueryStringParameters["verbose"] != "1" || api.Body != `{"name": "x"}` {
+		t.Fatal("Unexpected API Gateway request", api)
+	}
+
+	for name, params := range map[string]Params{
+		"s3-put":     {"bucket-name": "photos"},
+		"sqs":        {"count": "0"},
+		"dynamodb":   {"event": "UPSERT"},
+		"apigateway": {"path": "/users/42", "resource": "/groups/{id}"},
+		"unknown":    nil,
+	} {
+		if _, err := Generate(name, params); err == nil {
+			t.Fatal("Expected error for", name, params)
+		}
+	}
+}
+
+func TestCloudWatchLogs(t *testing.T) {
+	b, err := Generate("cloudwatch-logs", Params{"group": "/aws/lambda/hello", "message": "boom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event struct {
+		AWSLogs struct{ Data string } `json:"awslogs"`
+	}
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := base64.StdEncoding.DecodeString(event.AWSLogs.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data LogsData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.MessageType != "DATA_MESSAGE" || data.LogGroup != "/aws/lambda/hello" || len(data.LogEvents) != 1 || data.LogEvents[0].Message != "boom" {
+		t.Fatal("Unexpected log data", data)
+	}
+}
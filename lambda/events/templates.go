@@ -0,0 +1,412 @@
+package events
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iron-io/lambda/lambda/apigateway"
+	"github.com/iron-io/lambda/lambda/s3events"
+	"github.com/iron-io/lambda/lambda/schedule"
+	"github.com/iron-io/lambda/lambda/sqsevents"
+	"github.com/satori/go.uuid"
+)
+
+func init() {
+	register(&Template{
+		Name:        "s3-put",
+		Description: "S3 ObjectCreated:Put notification",
+		Params: []Param{
+			{"bucket", "example-bucket", "Bucket name"},
+			{"key", "test/key", "Object key"},
+			{"size", "1024", "Object size in bytes"},
+			{"etag", "", "Object ETag, defaults to the MD5 sum of the key"},
+		},
+		generate: s3Put,
+	})
+	register(&Template{
+		Name:        "s3-delete",
+		Description: "S3 ObjectRemoved:Delete notification",
+		Params: []Param{
+			{"bucket", "example-bucket", "Bucket name"},
+			{"key", "test/key", "Object key"},
+		},
+		generate: s3Delete,
+	})
+	register(&Template{
+		Name:        "sns",
+		Description: "SNS notification",
+		Params: []Param{
+			{"topic", "arn:aws:sns:us-east-1:123456789012:ExampleTopic", "Topic ARN"},
+			{"subject", "example subject", "Message subject"},
+			{"message", "example message", "Message body"},
+		},
+		generate: sns,
+	})
+	register(&Template{
+		Name:        "sqs",
+		Description: "Batch of SQS messages",
+		Params: []Param{
+			{"queue", "arn:aws:sqs:us-east-1:123456789012:MyQueue", "Queue ARN"},
+			{"body", "Hello from SQS!", "Message body"},
+			{"count", "1", "Number of messages"},
+		},
+		generate: sqs,
+	})
+	register(&Template{
+		Name:        "dynamodb",
+		Description: "DynamoDB Streams record",
+		Params: []Param{
+			{"table", "ExampleTable", "Table name"},
+			{"event", "INSERT", "INSERT, MODIFY or REMOVE"},
+			{"id", "101", "Value of the numeric Id key"},
+			{"message", "New item!", "Value of the Message attribute"},
+		},
+		generate: dynamodb,
+	})
+	register(&Template{
+		Name:        "kinesis",
+		Description: "Batch of Kinesis records",
+		Params: []Param{
+			{"stream", "example-stream", "Stream name"},
+			{"partition-key", "1", "Partition key"},
+			{"data", "Hello, this is a test.", "Record data, base64 encoded in the event"},
+			{"count", "1", "Number of records"},
+		},
+		generate: kinesis,
+	})
+	register(&Template{
+		Name:        "cloudwatch-logs",
+		Description: "CloudWatch Logs subscription, gzipped and base64 encoded",
+		Params: []Param{
+			{"group", "/aws/lambda/example", "Log group"},
+			{"stream", "2016/03/01/[$LATEST]0123456789abcdef", "Log stream"},
+			{"filter", "ExampleFilter", "Subscription filter name"},
+			{"message", "[ERROR] First test message", "Log message"},
+		},
+		generate: cloudwatchLogs,
+	})
+	register(&Template{
+		Name:        "apigateway",
+		Description: "API Gateway Lambda proxy request",
+		Params: []Param{
+			{"method", "GET", "HTTP method"},
+			{"path", "/hello", "Request path, with query string"},
+			{"resource", "", "Resource path such as /users/{id}, defaults to the path"},
+			{"body", "", "Request body"},
+		},
+		generate: apiGateway,
+	})
+	register(&Template{
+		Name:        "scheduled",
+		Description: "CloudWatch Events scheduled event",
+		Params: []Param{
+			{"rule", "example-rule", "Rule name"},
+		},
+		generate: scheduled,
+	})
+}
+
+func s3Put(p Params) (interface{}, error) {
+	size, err := p.int("size")
+	if err != nil {
+		return nil, err
+	}
+
+	eTag := p["etag"]
+	if eTag == "" {
+		sum := md5.Sum([]byte(p["key"]))
+		eTag = hex.EncodeToString(sum[:])
+	}
+	r := s3events.NewRecord(s3events.EventObjectCreatedPut, p["bucket"], p["key"], int64(size), eTag, time.Now())
+	return &s3events.Event{Records: []*s3events.Record{r}}, nil
+}
+
+func s3Delete(p Params) (interface{}, error) {
+	r := s3events.NewRecord(s3events.EventObjectRemovedDelete, p["bucket"], p["key"], 0, "", time.Now())
+	return &s3events.Event{Records: []*s3events.Record{r}}, nil
+}
+
+type snsMessage struct {
+	Type              string                 `json:"Type"`
+	MessageID         string                 `json:"MessageId"`
+	TopicARN          string                 `json:"TopicArn"`
+	Subject           string                 `json:"Subject"`
+	Message           string                 `json:"Message"`
+	Timestamp         string                 `json:"Timestamp"`
+	SignatureVersion  string                 `json:"SignatureVersion"`
+	Signature         string                 `json:"Signature"`
+	SigningCertURL    string                 `json:"SigningCertUrl"`
+	UnsubscribeURL    string                 `json:"UnsubscribeUrl"`
+	MessageAttributes map[string]interface{} `json:"MessageAttributes"`
+}
+
+type snsRecord struct {
+	EventSource          string     `json:"EventSource"`
+	EventVersion         string     `json:"EventVersion"`
+	EventSubscriptionARN string     `json:"EventSubscriptionArn"`
+	SNS                  snsMessage `json:"Sns"`
+}
+
+func sns(p Params) (interface{}, error) {
+	topic := p["topic"]
+	r := &snsRecord{
+		EventSource:          "aws:sns",
+		EventVersion:         "1.0",
+		EventSubscriptionARN: topic + ":" + uuid.NewV4().String(),
+		SNS: snsMessage{
+			Type:              "Notification",
+			MessageID:         uuid.NewV4().String(),
+			TopicARN:          topic,
+			Subject:           p["subject"],
+			Message:           p["message"],
+			Timestamp:         time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+			SignatureVersion:  "1",
+			Signature:         "EXAMPLE",
+			SigningCertURL:    "EXAMPLE",
+			UnsubscribeURL:    "EXAMPLE",
+			MessageAttributes: map[string]interface{}{},
+		},
+	}
+	return map[string][]*snsRecord{"Records": {r}}, nil
+}
+
+func sqs(p Params) (interface{}, error) {
+	count, err := p.count("count")
+	if err != nil {
+		return nil, err
+	}
+
+	arn := p["queue"]
+	sum := md5.Sum([]byte(p["body"]))
+	now := fmt.Sprint(time.Now().UnixNano() / int64(time.Millisecond))
+	event := &sqsevents.Event{}
+	for i := 0; i < count; i++ {
+		event.Records = append(event.Records, &sqsevents.Record{
+			MessageID:     uuid.NewV4().String(),
+			ReceiptHandle: base64.StdEncoding.EncodeToString(uuid.NewV4().Bytes()),
+			Body:          p["body"],
+			Attributes: map[string]string{
+				"ApproximateReceiveCount":          "1",
+				"SentTimestamp":                    now,
+				"SenderId":                         account,
+				"ApproximateFirstReceiveTimestamp": now,
+			},
+			MessageAttributes: map[string]*sqsevents.MessageAttribute{},
+			MD5OfBody:         hex.EncodeToString(sum[:]),
+			EventSource:       "aws:sqs",
+			EventSourceARN:    arn,
+			AWSRegion:         arnRegion(arn),
+		})
+	}
+	return event, nil
+}
+
+// DynamoDB attribute values, like {"S": "text"} or {"N": "101"}.
+type attributes map[string]map[string]string
+
+type streamRecord struct {
+	ApproximateCreationDateTime int64      `json:"ApproximateCreationDateTime"`
+	Keys                        attributes `json:"Keys"`
+	NewImage                    attributes `json:"NewImage,omitempty"`
+	OldImage                    attributes `json:"OldImage,omitempty"`
+	SequenceNumber              string     `json:"SequenceNumber"`
+	SizeBytes                   int        `json:"SizeBytes"`
+	StreamViewType              string     `json:"StreamViewType"`
+}
+
+type dynamodbRecord struct {
+	EventID        string       `json:"eventID"`
+	EventName      string       `json:"eventName"`
+	EventVersion   string       `json:"eventVersion"`
+	EventSource    string       `json:"eventSource"`
+	AWSRegion      string       `json:"awsRegion"`
+	DynamoDB       streamRecord `json:"dynamodb"`
+	EventSourceARN string       `json:"eventSourceARN"`
+}
+
+func dynamodb(p Params) (interface{}, error) {
+	id, err := p.int("id")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := attributes{"Id": {"N": fmt.Sprint(id)}}
+	image := attributes{"Id": {"N": fmt.Sprint(id)}, "Message": {"S": p["message"]}}
+	record := streamRecord{
+		ApproximateCreationDateTime: time.Now().Unix(),
+		Keys:                        keys,
+		SequenceNumber:              fmt.Sprint(time.Now().UnixNano()),
+		StreamViewType:              "NEW_AND_OLD_IMAGES",
+	}
+
+	switch p["event"] {
+	case "INSERT":
+		record.NewImage = image
+	case "MODIFY":
+		record.NewImage = image
+		record.OldImage = attributes{"Id": {"N": fmt.Sprint(id)}, "Message": {"S": "Old item!"}}
+	case "REMOVE":
+		record.OldImage = image
+	default:
+		return nil, fmt.Errorf("Parameter event must be INSERT, MODIFY or REMOVE, got %q.", p["event"])
+	}
+
+	b, _ := json.Marshal(record.Keys)
+	size := len(b)
+	b, _ = json.Marshal(record.NewImage)
+	size += len(b)
+	record.SizeBytes = size
+
+	r := &dynamodbRecord{
+		EventID:        strings.Replace(uuid.NewV4().String(), "-", "", -1),
+		EventName:      p["event"],
+		EventVersion:   "1.1",
+		EventSource:    "aws:dynamodb",
+		AWSRegion:      region,
+		DynamoDB:       record,
+		EventSourceARN: fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/%s/stream/2016-03-01T00:00:00.000", region, account, p["table"]),
+	}
+	return map[string][]*dynamodbRecord{"Records": {r}}, nil
+}
+
+type kinesisData struct {
+	SchemaVersion               string  `json:"kinesisSchemaVersion"`
+	PartitionKey                string  `json:"partitionKey"`
+	SequenceNumber              string  `json:"sequenceNumber"`
+	Data                        string  `json:"data"`
+	ApproximateArrivalTimestamp float64 `json:"approximateArrivalTimestamp"`
+}
+
+type kinesisRecord struct {
+	Kinesis           kinesisData `json:"kinesis"`
+	EventSource       string      `json:"eventSource"`
+	EventVersion      string      `json:"eventVersion"`
+	EventID           string      `json:"eventID"`
+	EventName         string      `json:"eventName"`
+	InvokeIdentityARN string      `json:"invokeIdentityArn"`
+	AWSRegion         string      `json:"awsRegion"`
+	EventSourceARN    string      `json:"eventSourceARN"`
+}
+
+func kinesis(p Params) (interface{}, error) {
+	count, err := p.count("count")
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*kinesisRecord
+	now := time.Now()
+	for i := 0; i < count; i++ {
+		seq := fmt.Sprintf("4959013381%040d", now.UnixNano()+int64(i))
+		records = append(records, &kinesisRecord{
+			Kinesis: kinesisData{
+				SchemaVersion:               "1.0",
+				PartitionKey:                p["partition-key"],
+				SequenceNumber:              seq,
+				Data:                        base64.StdEncoding.EncodeToString([]byte(p["data"])),
+				ApproximateArrivalTimestamp: float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+			},
+			EventSource:       "aws:kinesis",
+			EventVersion:      "1.0",
+			EventID:           "shardId-000000000000:" + seq,
+			EventName:         "aws:kinesis:record",
+			InvokeIdentityARN: fmt.Sprintf("arn:aws:iam::%s:role/lambda-role", account),
+			AWSRegion:         region,
+			EventSourceARN:    fmt.Sprintf("arn:aws:kinesis:%s:%s:stream/%s", region, account, p["stream"]),
+		})
+	}
+	return map[string][]*kinesisRecord{"Records": records}, nil
+}
+
+type logEvent struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// What CloudWatch Logs gzips and base64 encodes into awslogs.data.
+type LogsData struct {
+	MessageType         string     `json:"messageType"`
+	Owner               string     `json:"owner"`
+	LogGroup            string     `json:"logGroup"`
+	LogStream           string     `json:"logStream"`
+	SubscriptionFilters []string   `json:"subscriptionFilters"`
+	LogEvents           []logEvent `json:"logEvents"`
+}
+
+func cloudwatchLogs(p Params) (interface{}, error) {
+	now := time.Now()
+	data := &LogsData{
+		MessageType:         "DATA_MESSAGE",
+		Owner:               account,
+		LogGroup:            p["group"],
+		LogStream:           p["stream"],
+		SubscriptionFilters: []string{p["filter"]},
+		LogEvents: []logEvent{{
+			ID:        fmt.Sprintf("%056d", now.UnixNano()),
+			Timestamp: now.UnixNano() / int64(time.Millisecond),
+			Message:   p["message"],
+		}},
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return map[string]map[string]string{
+		"awslogs": {"data": base64.StdEncoding.EncodeToString(buf.Bytes())},
+	}, nil
+}
+
+func apiGateway(p Params) (interface{}, error) {
+	if !strings.HasPrefix(p["path"], "/") {
+		return nil, fmt.Errorf("Parameter path must start with /, got %q.", p["path"])
+	}
+
+	r, err := http.NewRequest(strings.ToUpper(p["method"]), "http://localhost"+p["path"], strings.NewReader(p["body"]))
+	if err != nil {
+		return nil, err
+	}
+	r.RemoteAddr = "127.0.0.1:12345"
+	r.Header.Set("User-Agent", "generate-event")
+	if p["body"] != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+
+	route := &apigateway.Route{Method: r.Method, Path: p["resource"]}
+	if route.Path == "" {
+		route.Path = r.URL.Path
+	}
+	params, err := apigateway.PathParameters(route.Path, r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	return apigateway.NewRequest(r, route, params, []byte(p["body"])), nil
+}
+
+func scheduled(p Params) (interface{}, error) {
+	return schedule.NewEvent(p["rule"], region, account, time.Now()), nil
+}
+
+func arnRegion(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) > 3 && parts[3] != "" {
+		return parts[3]
+	}
+	return region
+}
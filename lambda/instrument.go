@@ -0,0 +1,104 @@
+package lambda
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda/metrics"
+	"github.com/iron-io/lambda/lambda/trace"
+)
+
+// Invocation metrics in metrics.Default, labelled like the CloudWatch Lambda
+// metrics by function, the image name without tag, and version, the tag.
+var (
+	invocationsMetric = metrics.Default.NewCounterVec("lambda_invocations_total",
+		"Invocations of a function, successful or not.", "function", "version")
+	errorsMetric = metrics.Default.NewCounterVec("lambda_errors_total",
+		"Invocations that failed, including timeouts.", "function", "version")
+	timeoutsMetric = metrics.Default.NewCounterVec("lambda_timeouts_total",
+		"Invocations killed for running longer than their timeout.", "function", "version")
+	throttlesMetric = metrics.Default.NewCounterVec("lambda_throttles_total",
+		"Invocations rejected or delayed by a concurrency limit.", "function", "version")
+	startsMetric = metrics.Default.NewCounterVec("lambda_starts_total",
+		"Invocations by whether they started a new container (cold) or reused one (warm).", "function", "version", "start")
+	durationMetric = metrics.Default.NewHistogramVec("lambda_duration_seconds",
+		"Time functions ran for.",
+		[]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		"function", "version")
+	memoryMetric = metrics.Default.NewHistogramVec("lambda_max_memory_used_bytes",
+		"Peak memory use of the function's container during an invocation.",
+		memoryBuckets(),
+		"function", "version")
+	concurrentMetric = metrics.Default.NewGaugeVec("lambda_concurrent_executions",
+		"Invocations in flight.", "function", "version")
+)
+
+// Every memory size a function can have.
+func memoryBuckets() []float64 {
+	var buckets []float64
+	for mb := MinMemorySize; mb <= MaxMemorySize; mb += MemorySizeStep {
+		buckets = append(buckets, float64(mb*1024*1024))
+	}
+	return buckets
+}
+
+func metricLabels(imageName string) (string, string) {
+	function := functionName(imageName)
+	version := strings.TrimPrefix(imageName, function)
+	if version == "" {
+		return function, "$LATEST"
+	}
+	return function, version[1:]
+}
+
+// Counts an invocation of `imageName` as in flight until the returned
+// function is called with whether it started a new container, its report, nil
+// if it did not get to run, and its error.
+func startInvocationMetrics(imageName string) func(bool, *invocationReport, error) {
+	function, version := metricLabels(imageName)
+	concurrentMetric.Inc(function, version)
+
+	return func(cold bool, report *invocationReport, err error) {
+		concurrentMetric.Dec(function, version)
+		invocationsMetric.Inc(function, version)
+
+		start := "warm"
+		if cold {
+			start = "cold"
+		}
+		startsMetric.Inc(function, version, start)
+
+		if err != nil {
+			errorsMetric.Inc(function, version)
+		}
+		if err == ErrorTimeout {
+			timeoutsMetric.Inc(function, version)
+		}
+		if report != nil && report.duration > 0 {
+			durationMetric.Observe(report.duration.Seconds(), function, version)
+			memoryMetric.Observe(float64(report.maxMemoryUsed), function, version)
+		}
+	}
+}
+
+// Counts an invocation of `imageName` that was throttled before it ran.
+func RecordThrottle(imageName string) {
+	throttlesMetric.Inc(metricLabels(imageName))
+}
+
+// Starts the span of invocation `requestID` of `imageName`, in trace `tc` or a
+// new one if nil. Attributes follow the OpenTelemetry FaaS conventions.
+func startInvocationSpan(exporter trace.Exporter, tc *trace.Context, imageName string, requestID string, cold bool) *trace.Span {
+	parent := trace.New()
+	if tc != nil {
+		parent = *tc
+	}
+
+	function, version := metricLabels(imageName)
+	span := trace.Start(exporter, parent, "invoke")
+	span.SetAttribute("faas.name", function)
+	span.SetAttribute("faas.version", version)
+	span.SetAttribute("faas.invocation_id", requestID)
+	span.SetAttribute("faas.coldstart", strconv.FormatBool(cold))
+	return span
+}
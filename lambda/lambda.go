@@ -16,10 +16,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/iron-io/iron_go3/worker"
-	"github.com/satori/go.uuid"
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/secrets"
 )
 
 type FileLike interface {
@@ -33,10 +33,15 @@ var ErrorNoFiles = errors.New("No files to add to image")
 // expectation is that the base image sets up the current working directory
 // inside the image correctly.  `handler` is set to be passed to node-lambda
 // for now, but we may have to change this to accomodate other stacks.
-func makeDockerfile(base string, package_ string, handler string, files ...FileLike) ([]byte, error) {
+func makeDockerfile(base string, package_ string, handler string, env []string, files ...FileLike) ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("FROM %s\n", base))
 
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		buf.WriteString(fmt.Sprintf("ENV %s=%q\n", parts[0], parts[1]))
+	}
+
 	for _, file := range files {
 		// FIXME(nikhil): Validate path, no parent paths etc.
 		info, err := file.Stat()
@@ -167,6 +172,11 @@ type CreateImageOptions struct {
 	Handler       string
 	OutputStream  io.Writer
 	RawJSONStream bool
+
+	// Environment variables baked into the image. Values are encrypted with
+	// EnvKey first, which is required if there are any.
+	Environment map[string]string
+	EnvKey      envcrypt.Key
 }
 
 type PushImageOptions struct {
@@ -184,7 +194,12 @@ func CreateImage(opts CreateImageOptions, files ...FileLike) error {
 		return ErrorNoFiles
 	}
 
-	df, err := makeDockerfile(opts.Base, opts.Package, opts.Handler, files...)
+	env, err := encryptEnv(opts.Name, opts.Environment, opts.EnvKey)
+	if err != nil {
+		return err
+	}
+
+	df, err := makeDockerfile(opts.Base, opts.Package, opts.Handler, envcrypt.List(env), files...)
 	if err != nil {
 		return err
 	}
@@ -213,6 +228,20 @@ func CreateImage(opts CreateImageOptions, files ...FileLike) error {
 	return nil
 }
 
+// Encrypts the values of `env` for the function of `imageName`.
+func encryptEnv(imageName string, env map[string]string, key envcrypt.Key) (map[string]string, error) {
+	if len(env) == 0 {
+		return nil, nil
+	}
+	if err := validateEnv(env); err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, errors.New("Environment variables need a key to be encrypted with.")
+	}
+	return envcrypt.EncryptEnv(key, functionName(imageName), env)
+}
+
 func ImageExists(imageName string) (bool, error) {
 	client, err := getClient()
 	if err != nil {
@@ -227,112 +256,37 @@ func ImageExists(imageName string) (bool, error) {
 	return len(images) > 0, nil
 }
 
-func RunImageWithPayload(imageName string, payload string) error {
-	// FIXME(nikhil): Should we bother validating JSON here?
-
-	// Write payload to temp file.
-	fp, _ := filepath.Abs("./")
-	payloadDir, err := ioutil.TempDir(fp, "iron-lambda-")
-	if err != nil {
-		return err
-	}
-	defer func() {
-		os.RemoveAll(payloadDir)
-	}()
-
-	payloadFilePath := filepath.Join(payloadDir, "payload.json")
-
-	err = ioutil.WriteFile(payloadFilePath, []byte(payload), 0644)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Error writing payload to file: %s", err.Error()))
-	}
-
-	client, err := getClient()
-	if err != nil {
-		return err
-	}
-
-	var allocatedMemory = int64(300 * 1024 * 1024)
-	envs := []string{"PAYLOAD_FILE=/mnt/payload.json"}
-	envs = append(envs, "AWS_LAMBDA_FUNCTION_NAME="+imageName)
-	envs = append(envs, "AWS_LAMBDA_FUNCTION_VERSION=$LATEST")
-	envs = append(envs, "TASK_ID="+uuid.NewV4().String())
-	envs = append(envs, fmt.Sprintf("TASK_MAXRAM=%d", allocatedMemory))
-	// Try to forward AWS credentials.
-	{
-		creds := credentials.NewEnvCredentials()
-		v, err := creds.Get()
-		if err == nil {
-			envs = append(envs, "AWS_ACCESS_KEY_ID="+v.AccessKeyID)
-			envs = append(envs, "AWS_SECRET_ACCESS_KEY="+v.SecretAccessKey)
-		}
-	}
-
-	opts := docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Env:       envs,
-			Memory:    allocatedMemory,
-			CPUShares: 2,
-			Hostname:  "Hello",
-			Image:     imageName,
-			Volumes: map[string]struct{}{
-				"/mnt": {},
-			},
-		},
-		HostConfig: &docker.HostConfig{
-			Binds: []string{payloadDir + ":/mnt:ro"},
-		},
-	}
-
-	container, err := client.CreateContainer(opts)
-	if err != nil {
-		fmt.Println("CreateContainer error")
-		return err
-	}
-
-	defer func() {
-		client.RemoveContainer(docker.RemoveContainerOptions{
-			ID: container.ID, RemoveVolumes: true, Force: true,
-		})
-	}()
-
-	err = client.StartContainer(container.ID, nil)
-	if err != nil {
-		fmt.Println("StartContainer error")
-		return err
-	}
-
-	attachOpts := docker.AttachToContainerOptions{
-		Container:    container.ID,
-		OutputStream: os.Stdout,
-		ErrorStream:  os.Stderr,
-		Logs:         true,
-		Stream:       true,
-		Stdout:       true,
-		Stderr:       true,
-	}
-
-	err = client.AttachToContainer(attachOpts)
-	if err != nil {
-		return err
-	}
-
-	exitCode, err := client.WaitContainer(container.ID)
-	if err != nil {
-		return err
-	}
-
-	if exitCode != 0 {
-		return errors.New(fmt.Sprintf("Container exited with non-zero exit code %d", exitCode))
-	}
-
-	return nil
+type RegisterOptions struct {
+	// The AWS credentials the worker gets from CredentialsServer. Keys are
+	// never registered, anyone with access to the project could read them.
+	Credentials CredentialsOptions
+	// If set, the worker gets its credentials from this server, which must be
+	// reachable from IronWorker. Without it, the worker gets no AWS
+	// credentials.
+	CredentialsServer *CredentialsServer
+
+	// Environment variables of the worker. They are registered encrypted with
+	// EnvKey, which must be set if there are any, and the bootstrap decrypts
+	// them with KMS before the function is loaded.
+	Environment map[string]string
+	EnvKey      *envcrypt.KMS
+	// Resolves values of Environment that refer to secrets before they are
+	// encrypted. Nil means the default secret store.
+	Secrets *secrets.Resolver
+
+	// IronWorker can not mount anything into workers, so registering a
+	// function with mounts fails rather than have it run without them.
+	Mounts []Mount
 }
 
 // Registers public docker image named `imageNameVersion` as a IronWorker called `imageName`.
 // For example,
-//	  RegisterWithIron("foo/myimage:1", credentials.NewEnvCredentials()) will register a worker called "foo/myimage" that will use Docker Image "foo/myimage:1".
+//	  RegisterWithIron("foo/myimage:1") will register a worker called "foo/myimage" that will use Docker Image "foo/myimage:1".
 func RegisterWithIron(imageNameVersion string) error {
+	return RegisterWithIronOptions(imageNameVersion, RegisterOptions{})
+}
+
+func RegisterWithIronOptions(imageNameVersion string, opts RegisterOptions) error {
 	tokens := strings.Split(imageNameVersion, ":")
 	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
 		return errors.New("Invalid image name. Should be of the form \"name:version\".")
@@ -340,27 +294,21 @@ func RegisterWithIron(imageNameVersion string) error {
 
 	imageName := tokens[0]
 
+	if len(opts.Mounts) > 0 {
+		if err := validateMounts(opts.Mounts, false); err != nil {
+			return err
+		}
+		return errors.New("IronWorker can not mount volumes or host directories. Copy the files into the image instead.")
+	}
+
+	registerOpts, err := registerData(imageName, imageNameVersion, opts)
+	if err != nil {
+		return err
+	}
+
 	// Worker API doesn't have support for register yet, but we use it to extract the configuration.
 	w := worker.New()
 	url := fmt.Sprintf("https://%s/2/projects/%s/codes?oauth=%s", w.Settings.Host, w.Settings.ProjectId, w.Settings.Token)
-	registerOpts := map[string]interface{}{
-		"name":  imageName,
-		"image": imageNameVersion,
-		"env_vars": map[string]string{
-			"AWS_LAMBDA_FUNCTION_NAME":    imageName,
-			"AWS_LAMBDA_FUNCTION_VERSION": "1", // FIXME: swapi does not allow $ right now.
-		},
-	}
-
-	// Try to forward AWS credentials.
-	{
-		creds := credentials.NewEnvCredentials()
-		v, err := creds.Get()
-		if err == nil {
-			registerOpts["env_vars"].(map[string]string)["AWS_ACCESS_KEY_ID"] = v.AccessKeyID
-			registerOpts["env_vars"].(map[string]string)["AWS_SECRET_ACCESS_KEY"] = v.SecretAccessKey
-		}
-	}
 
 	marshal, err := json.Marshal(registerOpts)
 	var body bytes.Buffer
@@ -383,6 +331,62 @@ func RegisterWithIron(imageNameVersion string) error {
 	return err
 }
 
+// Where the bootstraps decrypt registered environment variables.
+const (
+	kmsRegionEnv   = "LAMBDA_KMS_REGION"
+	kmsEndpointEnv = "LAMBDA_KMS_ENDPOINT"
+)
+
+// The code IronWorker registers for `imageName`.
+func registerData(imageName string, imageNameVersion string, opts RegisterOptions) (map[string]interface{}, error) {
+	var credsEnv []string
+	if opts.CredentialsServer != nil {
+		var err error
+		credsEnv, err = opts.CredentialsServer.Allow(imageName, opts.Credentials)
+		if err != nil {
+			return nil, err
+		}
+	} else if opts.Credentials != (CredentialsOptions{}) {
+		return nil, errors.New("AWS credentials can only be registered with IronWorker through a credentials server, registered keys can be read by anyone with access to the project.")
+	}
+
+	envVars := map[string]string{
+		"AWS_LAMBDA_FUNCTION_NAME":    imageName,
+		"AWS_LAMBDA_FUNCTION_VERSION": "1", // FIXME: swapi does not allow $ right now.
+	}
+	for _, env := range credsEnv {
+		parts := strings.SplitN(env, "=", 2)
+		envVars[parts[0]] = parts[1]
+	}
+
+	if len(opts.Environment) > 0 {
+		if opts.EnvKey == nil {
+			return nil, errors.New("Environment variables are registered encrypted, and need a KMS key the worker can decrypt them with.")
+		}
+		env, err := resolveEnv(opts.Environment, opts.Secrets)
+		if err != nil {
+			return nil, err
+		}
+		env, err = encryptEnv(imageName, env, opts.EnvKey)
+		if err != nil {
+			return nil, err
+		}
+		for name, v := range env {
+			envVars[name] = v
+		}
+		envVars[kmsRegionEnv] = opts.EnvKey.Region()
+		if endpoint := opts.EnvKey.Endpoint(); endpoint != "" {
+			envVars[kmsEndpointEnv] = endpoint
+		}
+	}
+
+	return map[string]interface{}{
+		"name":     imageName,
+		"image":    imageNameVersion,
+		"env_vars": envVars,
+	}, nil
+}
+
 func PushImage(in PushImageOptions) error {
 	client, err := getClient()
 	if err != nil {
@@ -1,14 +1,25 @@
 package lambda
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"flag"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/secrets"
+	"github.com/iron-io/lambda/lambda/signer"
 )
 
 var baseImage string = "iron/lambda-nodejs"
@@ -49,11 +60,37 @@ func buildAndClean(name, base, handler, testdir string) error {
 		return err
 	}
 
-	return CreateImage(CreateImageOptions{name, base, "", handler, ioutil.Discard, false}, files...)
+	return CreateImage(CreateImageOptions{Name: name, Base: base, Handler: handler, OutputStream: ioutil.Discard}, files...)
+}
+
+// Builds image `name` on the nodejs base image from `files`, contents by path.
+// Remove it with client.RemoveImage.
+func buildTestFunction(name, handler string, files map[string]string) error {
+	dir, err := ioutil.TempDir("", "lambda-function")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for p, content := range files {
+		p = filepath.Join(dir, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	in, err := everythingIn(dir)
+	if err != nil {
+		return err
+	}
+	return CreateImage(CreateImageOptions{Name: name, Base: baseImage, Handler: handler, OutputStream: ioutil.Discard}, in...)
 }
 
 func TestCreateImageEmpty(t *testing.T) {
-	err := CreateImage(CreateImageOptions{"iron-test/lambda-nodejs-empty", baseImage, "", "test.run", ioutil.Discard, false})
+	err := CreateImage(CreateImageOptions{Name: "iron-test/lambda-nodejs-empty", Base: baseImage, Handler: "test.run", OutputStream: ioutil.Discard})
 	if err == nil {
 		t.Fatal("Expected error when no files passed")
 	}
@@ -80,6 +117,138 @@ func TestCreateImageWithDir(t *testing.T) {
 	}
 }
 
+func TestRunOptionsValidate(t *testing.T) {
+	opts := RunOptions{}
+	if err := opts.Validate(); err != nil {
+		t.Fatal("Empty options should be valid", err)
+	}
+	if opts.MemorySize != DefaultMemorySize || opts.Timeout != DefaultTimeout {
+		t.Fatal("Expected defaults to be filled in", opts)
+	}
+	if opts.cpuQuota() != cpuPeriod*DefaultMemorySize/MaxMemorySize {
+		t.Fatal("Unexpected CPU quota", opts.cpuQuota())
+	}
+
+	invalid := []RunOptions{
+		{MemorySize: 64},
+		{MemorySize: 200},
+		{MemorySize: MaxMemorySize + MemorySizeStep},
+		{Timeout: 500 * time.Millisecond},
+		{Timeout: MaxTimeout + time.Second},
+		{Network: NetworkOptions{Mode: "host"}},
+		{Network: NetworkOptions{Mode: NetworkNone, DNS: []string{"10.0.0.2"}}},
+		{Network: NetworkOptions{Mode: "vpc", EgressAllow: []string{"s3.amazonaws.com"}}},
+		{Network: NetworkOptions{DNS: []string{"dns.example.com"}}},
+		{Network: NetworkOptions{EgressAllow: []string{"10.0.0.0/33"}}},
+		{Mounts: []Mount{{Source: "data", Target: "mnt/data"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/mnt/data/"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/app/data"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/lambda-payload"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/tmp"}}, Sandbox: true},
+		{Mounts: []Mount{{Source: "data", Target: "/mnt/a"}, {Source: "/srv", Target: "/mnt/a"}}},
+		{Mounts: []Mount{{Source: "", Target: "/mnt/data"}}},
+		{Mounts: []Mount{{Source: "/srv/../etc", Target: "/mnt/data"}}},
+		{Mounts: []Mount{{Source: "data", Target: "/lambda-src"}}},
+		{Source: "src"},
+		{Source: "/home/user/fn/"},
+		{DebugPort: 70000},
+		{DebugPort: 5858, Network: NetworkOptions{Mode: NetworkNone}},
+		{DebugPort: 5858, Network: NetworkOptions{EgressAllow: []string{"s3.amazonaws.com"}}},
+	}
+	for _, opts := range invalid {
+		if err := opts.Validate(); err == nil {
+			t.Fatal("Expected error for invalid options", opts)
+		}
+	}
+
+	opts = RunOptions{Network: NetworkOptions{DNS: []string{"10.0.0.2"}, EgressAllow: []string{"*.amazonaws.com:443", "10.0.0.0/16"}}}
+	if err := opts.Validate(); err != nil {
+		t.Fatal("Expected egress rules to be valid", err)
+	}
+
+	mounts := []Mount{{Source: "shared-data", Target: "/mnt/data", ReadOnly: true}, {Source: "/srv/models", Target: "/opt/models"}, {Source: "cache", Target: "/tmp"}}
+	opts = RunOptions{Mounts: mounts}
+	if err := opts.Validate(); err != nil {
+		t.Fatal("Expected mounts to be valid", err)
+	}
+	if mounts[0].String() != "shared-data:/mnt/data:ro" || mounts[1].String() != "/srv/models:/opt/models:rw" {
+		t.Fatal("Unexpected binds", mounts)
+	}
+
+	if err := RegisterWithIronOptions("user/fn:1", RegisterOptions{Mounts: mounts[:1]}); err == nil || !strings.Contains(err.Error(), "IronWorker") {
+		t.Fatal("Expected mounts to be refused by IronWorker", err)
+	}
+}
+
+func TestApplyDebug(t *testing.T) {
+	config := &docker.Config{Env: []string{"TASK_ID=1"}}
+	hostConfig := &docker.HostConfig{}
+	applyDebug(5858, config, hostConfig)
+
+	if config.Env[len(config.Env)-1] != "LAMBDA_DEBUG_PORT=5858" {
+		t.Fatal("Expected the debug port in the environment", config.Env)
+	}
+	if _, ok := config.ExposedPorts["5858/tcp"]; !ok {
+		t.Fatal("Expected the debug port to be exposed", config.ExposedPorts)
+	}
+	bindings := hostConfig.PortBindings["5858/tcp"]
+	if len(bindings) != 1 || bindings[0].HostIP != "127.0.0.1" || bindings[0].HostPort != "5858" {
+		t.Fatal("Expected the debug port to be published on loopback", bindings)
+	}
+}
+
+func TestWatchAttach(t *testing.T) {
+	var out bytes.Buffer
+	w, attached := watchAttach(&out)
+
+	// The marker may be split across writes, other marked lines are kept.
+	for _, s := range []string{"log line\n\x1elambda ready\n", "\x1elambda debu", "gger attached\n", "after\n"} {
+		if _, err := io.WriteString(w, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-attached:
+	default:
+		t.Fatal("Expected the debugger to be attached")
+	}
+	if out.String() != "log line\n\x1elambda ready\nafter\n" {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+}
+
+func TestMakePayloadTar(t *testing.T) {
+	r, err := makePayloadTar(`{"key": "value"}`)
+	if err != nil {
+		t.Fatal("makePayloadTar failed", err)
+	}
+
+	tr := tar.NewReader(r)
+	var names []string
+	var contents []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Reading tar failed", err)
+		}
+		names = append(names, header.Name)
+		if header.Typeflag != tar.TypeDir {
+			contents, _ = ioutil.ReadAll(tr)
+		}
+	}
+
+	if len(names) != 2 || names[1] != "lambda-payload/payload.json" {
+		t.Fatal("Unexpected tar entries", names)
+	}
+	if string(contents) != `{"key": "value"}` {
+		t.Fatal("Unexpected payload in tar", string(contents))
+	}
+}
+
 func ensureBaseImage(name string) error {
 	filteropts := docker.ListImagesOptions{
 		Filter: name,
@@ -102,6 +271,25 @@ func ensureBaseImage(name string) error {
 	return nil
 }
 
+func TestWritePayloadDir(t *testing.T) {
+	dir, err := writePayloadDir(`{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Readable by the sandbox's user, whatever the owner.
+	for _, p := range []string{dir, filepath.Join(dir, payloadFileName)} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm()&0004 == 0 {
+			t.Fatal("Expected payload to be readable by others", p, info.Mode())
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	// Set up docker client to run clean up in individual tests.
@@ -118,3 +306,140 @@ func TestMain(m *testing.M) {
 
 	os.Exit(m.Run())
 }
+
+func TestBilledDuration(t *testing.T) {
+	cases := map[time.Duration]time.Duration{
+		0:                      100 * time.Millisecond,
+		time.Millisecond:       100 * time.Millisecond,
+		100 * time.Millisecond: 100 * time.Millisecond,
+		101 * time.Millisecond: 200 * time.Millisecond,
+		3 * time.Second:        3 * time.Second,
+	}
+	for d, expected := range cases {
+		if billed := billedDuration(d); billed != expected {
+			t.Fatalf("Expected %s to be billed as %s, got %s", d, expected, billed)
+		}
+	}
+}
+
+func TestEncryptEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lambda-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "env.key")
+	if err := envcrypt.GenerateKeyFile(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	key, err := envcrypt.NewKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"CONFIG_PASSWORD": "hunter2"}
+	if _, err := encryptEnv("user/fn:1", env, nil); err == nil {
+		t.Fatal("Expected error for environment without key")
+	}
+	if _, err := encryptEnv("user/fn:1", map[string]string{"AWS_LAMBDA_FUNCTION_NAME": "x"}, key); err == nil {
+		t.Fatal("Expected error for reserved variable")
+	}
+
+	encrypted, err := encryptEnv("user/fn:1", env, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	df, err := makeDockerfile("iron/lambda-nodejs", "", "index.handler", envcrypt.List(encrypted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(df), "hunter2") || !strings.Contains(string(df), "ENV CONFIG_PASSWORD=\""+envcrypt.Prefix) {
+		t.Fatal("Expected encrypted ENV line", string(df))
+	}
+
+	// Bound to the function, whatever the tag.
+	decrypted, err := envcrypt.DecryptValue(key, functionName("user/fn:2"), encrypted["CONFIG_PASSWORD"])
+	if err != nil || decrypted != "hunter2" {
+		t.Fatal("Unexpected plaintext", decrypted, err)
+	}
+	if functionName("localhost:5000/user/fn") != "localhost:5000/user/fn" {
+		t.Fatal("Registry port mistaken for a tag")
+	}
+
+	// The image's encrypted values are decrypted, and fail without a key.
+	imageEnv := []string{"PATH=/usr/bin", "CONFIG_PASSWORD=" + encrypted["CONFIG_PASSWORD"]}
+	merged, err := mergeFunctionEnv("user/fn:2", imageEnv, RunOptions{EnvKey: key})
+	if err != nil || len(merged) != 1 || merged[0] != "CONFIG_PASSWORD=hunter2" {
+		t.Fatal("Unexpected function environment", merged, err)
+	}
+	if _, err := mergeFunctionEnv("user/fn:2", imageEnv, RunOptions{}); err == nil || !strings.Contains(err.Error(), envcrypt.ErrorNoKey.Error()) {
+		t.Fatal("Expected an error for encrypted values without a key", err)
+	}
+}
+
+type mapProvider map[string]string
+
+func (p mapProvider) Get(name string) (string, error) {
+	v, ok := p[name]
+	if !ok {
+		return "", secrets.ErrorNotFound
+	}
+	return v, nil
+}
+
+func TestRegisterEnv(t *testing.T) {
+	env := map[string]string{"CONFIG_PASSWORD": "hunter2"}
+	if _, err := registerData("user/fn", "user/fn:1", RegisterOptions{Environment: env}); err == nil {
+		t.Fatal("Expected error for environment without a KMS key")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Plaintext         []byte
+			EncryptionContext map[string]string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.EncryptionContext["LambdaFunctionName"] != "user/fn" {
+			t.Error("Unexpected encryption context", req.EncryptionContext)
+		}
+		if string(req.Plaintext) != "hunter2" {
+			t.Error("Expected the secret to be resolved before it is encrypted", string(req.Plaintext))
+		}
+		w.Write([]byte(`{"CiphertextBlob": "c2VhbGVk"}`))
+	}))
+	defer srv.Close()
+	key, err := envcrypt.NewKMS(envcrypt.KMSOptions{KeyID: "alias/lambda", JSONOptions: signer.JSONOptions{Region: "eu-west-1", Endpoint: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := registerData("user/fn", "user/fn:1", RegisterOptions{Environment: env, EnvKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := data["env_vars"].(map[string]string)
+	if vars["CONFIG_PASSWORD"] != envcrypt.Prefix+"c2VhbGVk" {
+		t.Fatal("Expected the value to be registered encrypted", vars)
+	}
+	if vars["LAMBDA_KMS_REGION"] != "eu-west-1" || vars["LAMBDA_KMS_ENDPOINT"] != srv.URL {
+		t.Fatal("Expected the bootstrap to be told where to decrypt", vars)
+	}
+	b, _ := json.Marshal(data)
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatal("Plaintext in the register body", string(b))
+	}
+
+	// Secret references are resolved before they are encrypted.
+	resolver, err := secrets.NewResolver(secrets.Options{Providers: map[string]secrets.Provider{"secret": mapProvider{"db/password": "hunter2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := map[string]string{"CONFIG_PASSWORD": "secret:db/password"}
+	if _, err := registerData("user/fn", "user/fn:1", RegisterOptions{Environment: ref, EnvKey: key, Secrets: resolver}); err != nil {
+		t.Fatal(err)
+	}
+	ref["CONFIG_PASSWORD"] = "secret:db/missing"
+	if _, err := registerData("user/fn", "user/fn:1", RegisterOptions{Environment: ref, EnvKey: key, Secrets: resolver}); err == nil || !strings.Contains(err.Error(), "CONFIG_PASSWORD") {
+		t.Fatal("Expected an error naming the unresolved variable", err)
+	}
+}
@@ -0,0 +1,126 @@
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Returned by Limiter.Acquire when a function is over its concurrency limit.
+var ErrorThrottled = errors.New("Rate Exceeded.")
+
+// Limits concurrent invocations the way Lambda does. Functions with reserved
+// concurrency can always run that many invocations, and never more. All other
+// functions share what is left of the global ceiling.
+type Limiter struct {
+	max int // Zero means no global ceiling.
+
+	mu                 sync.Mutex
+	reserved           map[string]int
+	totalReserved      int
+	inFlight           map[string]int
+	unreservedInFlight int
+}
+
+type ConcurrencyStats struct {
+	Max       int                         `json:"max"`
+	InFlight  int                         `json:"in_flight"`
+	Functions map[string]FunctionInFlight `json:"functions"`
+}
+
+type FunctionInFlight struct {
+	InFlight int `json:"in_flight"`
+	Reserved int `json:"reserved,omitempty"`
+}
+
+// `max` is the global concurrency ceiling, zero means no ceiling.
+func NewLimiter(max int) *Limiter {
+	return &Limiter{
+		max:      max,
+		reserved: make(map[string]int),
+		inFlight: make(map[string]int),
+	}
+}
+
+// Reserves `n` concurrent invocations for `function`. Zero removes the
+// reservation.
+func (l *Limiter) Reserve(function string, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n < 0 {
+		return fmt.Errorf("Invalid reserved concurrency %d for %s.", n, function)
+	}
+
+	total := l.totalReserved - l.reserved[function] + n
+	if l.max > 0 && total > l.max {
+		return fmt.Errorf("Reserving %d for %s would reserve %d, more than the maximum concurrency %d.", n, function, total, l.max)
+	}
+
+	if n == 0 {
+		delete(l.reserved, function)
+	} else {
+		l.reserved[function] = n
+	}
+	l.totalReserved = total
+	return nil
+}
+
+// Takes a slot for an invocation of `function`, or returns ErrorThrottled if
+// there are none. Every successful Acquire must be followed by a Release.
+func (l *Limiter) Acquire(function string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if reserved, ok := l.reserved[function]; ok {
+		if l.inFlight[function] >= reserved {
+			return ErrorThrottled
+		}
+	} else {
+		if l.max > 0 && l.unreservedInFlight >= l.max-l.totalReserved {
+			return ErrorThrottled
+		}
+		l.unreservedInFlight++
+	}
+
+	l.inFlight[function]++
+	return nil
+}
+
+func (l *Limiter) Release(function string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[function] == 0 {
+		panic("Release without Acquire for " + function)
+	}
+
+	if _, ok := l.reserved[function]; !ok {
+		l.unreservedInFlight--
+	}
+	l.inFlight[function]--
+	if l.inFlight[function] == 0 {
+		delete(l.inFlight, function)
+	}
+}
+
+// The current number of invocations in flight, overall and by function.
+func (l *Limiter) Stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := ConcurrencyStats{
+		Max:       l.max,
+		Functions: make(map[string]FunctionInFlight),
+	}
+	for function, n := range l.inFlight {
+		stats.InFlight += n
+		stats.Functions[function] = FunctionInFlight{InFlight: n}
+	}
+	for function, reserved := range l.reserved {
+		f := stats.Functions[function]
+		f.Reserved = reserved
+		stats.Functions[function] = f
+	}
+	return stats
+}
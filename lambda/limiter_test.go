@@ -0,0 +1,80 @@
+package lambda
+
+import (
+	"testing"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(3)
+	if err := l.Reserve("reserved", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reserve("other", 2); err == nil {
+		t.Fatal("Expected error reserving more than the maximum")
+	}
+
+	// One slot is left for unreserved functions.
+	if err := l.Acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire("b"); err != ErrorThrottled {
+		t.Fatal("Expected b to be throttled", err)
+	}
+
+	// Reserved slots are available regardless of other functions, but capped.
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire("reserved"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Acquire("reserved"); err != ErrorThrottled {
+		t.Fatal("Expected reserved to be throttled", err)
+	}
+
+	stats := l.Stats()
+	if stats.InFlight != 3 || stats.Functions["reserved"].InFlight != 2 || stats.Functions["reserved"].Reserved != 2 {
+		t.Fatal("Unexpected stats", stats)
+	}
+
+	l.Release("a")
+	if err := l.Acquire("b"); err != nil {
+		t.Fatal("Expected b to run after a was released", err)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(0)
+	for i := 0; i < 100; i++ {
+		if err := l.Acquire("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l.Stats().InFlight != 100 {
+		t.Fatal("Unexpected in flight count", l.Stats())
+	}
+}
+
+func TestRunnersThrottle(t *testing.T) {
+	l := NewLimiter(1)
+	if err := l.Acquire("user/fn"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Throttled before a container is started.
+	if err := RunImageWithOptions("user/fn", `{}`, RunOptions{Limiter: l}); err != ErrorThrottled {
+		t.Fatal("Expected run to be throttled", err)
+	}
+
+	p := NewPool(PoolOptions{})
+	defer p.Close()
+	if err := p.Provision("user/fn", 0, RunOptions{Limiter: l}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run("user/fn", `{}`, nil, nil); err != ErrorThrottled {
+		t.Fatal("Expected pool run to be throttled", err)
+	}
+
+	if l.Stats().InFlight != 1 {
+		t.Fatal("Throttled runs should not take slots", l.Stats())
+	}
+}
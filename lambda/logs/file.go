@@ -0,0 +1,117 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	DefaultMaxSize  = 100 * 1024 * 1024
+	DefaultMaxFiles = 5
+)
+
+type FileOptions struct {
+	// Bytes after which the file is rotated. Zero means DefaultMaxSize.
+	MaxSize int64
+	// Rotated files to keep, as path.1 (the newest) to path.N. Zero means
+	// DefaultMaxFiles.
+	MaxFiles int
+}
+
+// Appends records to a file as JSON lines, rotating it when it gets too big.
+type FileSink struct {
+	path string
+	opts FileOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func NewFileSink(path string, opts FileOptions) (*FileSink, error) {
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = DefaultMaxFiles
+	}
+	if opts.MaxSize < 0 || opts.MaxFiles < 0 {
+		return nil, fmt.Errorf("Invalid rotation for log file %s.", path)
+	}
+
+	s := &FileSink{path: path, opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(r *Record) error {
+	line, err := r.MarshalLine()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return fmt.Errorf("Log file %s is closed.", s.path)
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.opts.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Shifts path.N-1 to path.N and so on, dropping the oldest, and starts a new
+// file.
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+
+	for i := s.opts.MaxFiles; i > 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", s.path, i-1), fmt.Sprintf("%s.%d", s.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,133 @@
+// Package logs captures the output of function invocations as records and
+// ships them to sinks: JSON-lines files with rotation, syslog or a TCP line
+// protocol.
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+
+	// Longer lines are split into several records, like CloudWatch Logs
+	// does with events over its size limit.
+	MaxMessageSize = 256 * 1024
+)
+
+// One line of output of an invocation.
+type Record struct {
+	Time      time.Time `json:"timestamp"`
+	RequestID string    `json:"request_id"`
+	Function  string    `json:"function"`
+	Stream    string    `json:"stream"`
+	Message   string    `json:"message"`
+}
+
+func (r *Record) MarshalLine() ([]byte, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Receives the records of all invocations, concurrently.
+type Sink interface {
+	Write(r *Record) error
+	Close() error
+}
+
+// Turns the output of one stream of an invocation into records, a record per
+// line. Errors of the sink are logged rather than returned, so they never
+// interrupt the invocation.
+type Writer struct {
+	sink      Sink
+	function  string
+	requestID string
+	stream    string
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func NewWriter(sink Sink, function string, requestID string, stream string) *Writer {
+	return &Writer{sink: sink, function: function, requestID: requestID, stream: stream}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 || i > MaxMessageSize {
+			if w.buf.Len() >= MaxMessageSize {
+				w.emit(w.buf.Next(MaxMessageSize))
+				continue
+			}
+			break
+		}
+		line := w.buf.Next(i + 1)
+		w.emit(line[:i])
+	}
+	return len(p), nil
+}
+
+// Emits the last line if it did not end with a newline.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.emit(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	return nil
+}
+
+func (w *Writer) emit(line []byte) {
+	err := w.sink.Write(&Record{
+		Time:      time.Now().UTC(),
+		RequestID: w.requestID,
+		Function:  w.function,
+		Stream:    w.stream,
+		Message:   string(bytes.TrimSuffix(line, []byte("\r"))),
+	})
+	if err != nil {
+		log.Printf("Could not write log record of %s: %s", w.requestID, err)
+	}
+}
+
+// Writes every record to all of `sinks`.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Write(r *Record) error {
+	var first error
+	for _, s := range m {
+		if err := s.Write(r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m multiSink) Close() error {
+	var first error
+	for _, s := range m {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,161 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func (s *memorySink) Write(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestWriter(t *testing.T) {
+	sink := &memorySink{}
+	w := NewWriter(sink, "user/fn", "req-1", Stderr)
+
+	fmt.Fprint(w, "START\nhello ")
+	fmt.Fprint(w, "world\r\nno newline")
+	if len(sink.records) != 2 {
+		t.Fatal("Expected a record per complete line", sink.records)
+	}
+	w.Close()
+
+	expected := []string{"START", "hello world", "no newline"}
+	if len(sink.records) != len(expected) {
+		t.Fatal("Expected the last line on close", sink.records)
+	}
+	for i, r := range sink.records {
+		if r.Message != expected[i] || r.Function != "user/fn" || r.RequestID != "req-1" || r.Stream != Stderr || r.Time.IsZero() {
+			t.Fatal("Unexpected record", r)
+		}
+	}
+
+	// Long lines are split.
+	sink.records = nil
+	w.Write([]byte(strings.Repeat("x", MaxMessageSize+10) + "\n"))
+	if len(sink.records) != 2 || len(sink.records[0].Message) != MaxMessageSize || len(sink.records[1].Message) != 10 {
+		t.Fatal("Expected long line to be split", len(sink.records))
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "functions.log")
+	s, err := NewFileSink(path, FileOptions{MaxSize: 200, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Write(&Record{RequestID: "req", Message: fmt.Sprintf("line %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal("Expected rotated file", err)
+		}
+		if info.Size() > 200 {
+			t.Fatal("File bigger than max size", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("Expected only 2 rotated files to be kept")
+	}
+
+	// The newest record is last in the current file.
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var r Record
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Message != "line 9" {
+		t.Fatal("Unexpected last record", r)
+	}
+}
+
+func TestTCPSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			scanner.Scan()
+			received <- scanner.Text()
+			// Drop the connection after one record to force a reconnect.
+			conn.Close()
+		}
+	}()
+
+	s, err := NewTCPSink(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		msg := fmt.Sprintf("line %d", i)
+		// Writes to a connection the other side closed may only fail on
+		// the next write, so keep writing until the record arrives.
+		var line string
+		for line == "" {
+			s.Write(&Record{Message: msg})
+			select {
+			case line = <-received:
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Message != msg {
+			t.Fatal("Unexpected record", r)
+		}
+	}
+
+	if _, err := NewTCPSink("no-port"); err == nil {
+		t.Fatal("Expected error for address without port")
+	}
+}
@@ -0,0 +1,102 @@
+package logs
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+
+// Sends records as JSON messages to syslog, stderr at priority err and stdout
+// at info.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// Empty `network` and `addr` mean the local syslog daemon. Otherwise
+// `network` is udp or tcp.
+func NewSyslogSink(network string, addr string, tag string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+func (s *SyslogSink) Write(r *Record) error {
+	line, err := r.MarshalLine()
+	if err != nil {
+		return err
+	}
+	if r.Stream == Stderr {
+		return s.w.Err(string(line))
+	}
+	return s.w.Info(string(line))
+}
+
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
+
+// Sends records as JSON lines over TCP. The connection is reopened when it
+// breaks, records written while the other side is unreachable are lost.
+type TCPSink struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewTCPSink(addr string) (*TCPSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("Invalid log address %s: %s", addr, err)
+	}
+	return &TCPSink{addr: addr}, nil
+}
+
+func (s *TCPSink) Write(r *Record) error {
+	line, err := r.MarshalLine()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retry once on a new connection, the old one may have been closed by
+	// the other side since the last record.
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout("tcp", s.addr, dialTimeout)
+			if err != nil {
+				return err
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+		_, err = s.conn.Write(line)
+		if err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (s *TCPSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,256 @@
+// Package metrics keeps counters, gauges and histograms with labels and
+// exposes them in the Prometheus text format.
+//
+//	invocations := metrics.Default.NewCounterVec("invocations_total", "Invocations.", "function")
+//	invocations.Inc("hello")
+//	http.Handle("/metrics", metrics.Default)
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The registry the runner's metrics are kept in.
+var Default = NewRegistry()
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// All series of one metric, by their label values.
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64 // Only for histograms.
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Only for histograms, cumulative counts are computed when written.
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[m.name] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name))
+	}
+	r.names[m.name] = true
+	m.series = make(map[string]*series)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Returns the series for `labelValues`, creating it. Must be called with
+// m.mu held.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == typeHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(v float64, labelValues []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += v
+}
+
+type CounterVec struct {
+	m *metric
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&metric{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.m.add(1, labelValues)
+}
+
+// Counters only go up, negative `v` panics.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s can not decrease", c.m.name))
+	}
+	c.m.add(v, labelValues)
+}
+
+type GaugeVec struct {
+	m *metric
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&metric{name: name, help: help, typ: typeGauge, labels: labels})}
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.get(labelValues).value = v
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.m.add(v, labelValues)
+}
+
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.m.add(1, labelValues)
+}
+
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.m.add(-1, labelValues)
+}
+
+type HistogramVec struct {
+	m *metric
+}
+
+// `buckets` are the upper bounds, in increasing order. The +Inf bucket is
+// added.
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	return &HistogramVec{r.register(&metric{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	s := h.m.get(labelValues)
+	s.value += v
+	s.count++
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+}
+
+// Writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		m.write(&buf)
+	}
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+func (m *metric) write(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.typ)
+
+	var keys []string
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != typeHistogram {
+			fmt.Fprintf(buf, "%s%s %s\n", m.name, m.labelString(s.labelValues, ""), formatValue(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, upper := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labelString(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", m.name, m.labelString(s.labelValues, ""), formatValue(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", m.name, m.labelString(s.labelValues, ""), s.count)
+	}
+}
+
+// Formats the labels of a series, with the le label of histogram buckets if
+// `le` is set.
+func (m *metric) labelString(values []string, le string) string {
+	var pairs []string
+	for i, name := range m.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	invocations := r.NewCounterVec("invocations_total", "Invocations.", "function")
+	inFlight := r.NewGaugeVec("in_flight", "In flight.", "function")
+	duration := r.NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1}, "function")
+
+	invocations.Inc("b")
+	invocations.Inc("a")
+	invocations.Add(2, `quote"d`)
+	inFlight.Inc("a")
+	inFlight.Inc("a")
+	inFlight.Dec("a")
+	duration.Observe(0.05, "a")
+	duration.Observe(0.1, "a")
+	duration.Observe(5, "a")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP invocations_total Invocations.
+# TYPE invocations_total counter
+invocations_total{function="a"} 1
+invocations_total{function="b"} 1
+invocations_total{function="quote\"d"} 2
+# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight{function="a"} 1
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{function="a",le="0.1"} 2
+duration_seconds_bucket{function="a",le="1"} 2
+duration_seconds_bucket{function="a",le="+Inf"} 3
+duration_seconds_sum{function="a"} 5.15
+duration_seconds_count{function="a"} 3
+`
+	if buf.String() != expected {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("total", "Total.", "function")
+
+	for name, f := range map[string]func(){
+		"duplicate":       func() { r.NewCounterVec("total", "Again.") },
+		"label count":     func() { c.Inc("a", "b") },
+		"negative add":    func() { c.Add(-1, "a") },
+		"unsorted bucket": func() { r.NewHistogramVec("h", "H.", []float64{1, 0.1}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("Expected panic for", name)
+				}
+			}()
+			f()
+		}()
+	}
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	if strings.Contains(buf.String(), "Again") {
+		t.Fatal("Duplicate metric was registered")
+	}
+}
@@ -0,0 +1,95 @@
+package lambda
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Where the function and its bootstrap live in every image.
+const appDir = "/app"
+
+// Docker's rules for volume names.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// A volume or host directory mounted into the function's container, like an
+// EFS file system of a Lambda function, which Lambda mounts under /mnt.
+type Mount struct {
+	// The name of an existing Docker volume, or an absolute path on the
+	// Docker host for a bind mount.
+	Source string
+	// Absolute path in the container.
+	Target   string
+	ReadOnly bool
+}
+
+func (m Mount) bind() bool {
+	return strings.HasPrefix(m.Source, "/")
+}
+
+func (m Mount) String() string {
+	mode := "rw"
+	if m.ReadOnly {
+		mode = "ro"
+	}
+	return m.Source + ":" + m.Target + ":" + mode
+}
+
+// Whether `p` is `dir` or inside it.
+func within(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/") || dir == "/"
+}
+
+// Checks that the mounts do not shadow what functions need: the function in
+// /app or its source directory, the payload, and the sandbox's /tmp.
+func validateMounts(mounts []Mount, sandbox bool) error {
+	reserved := []string{appDir, devSourceDir, payloadCopyDir, payloadBindDir}
+	if sandbox {
+		reserved = append(reserved, sandboxTmpDir)
+	}
+
+	targets := make(map[string]bool)
+	for _, m := range mounts {
+		if !path.IsAbs(m.Target) || path.Clean(m.Target) != m.Target || strings.Contains(m.Target, ":") {
+			return fmt.Errorf("Invalid mount target %s. Should be a clean absolute path.", m.Target)
+		}
+		for _, dir := range reserved {
+			if within(m.Target, dir) || within(dir, m.Target) {
+				return fmt.Errorf("Mount target %s would shadow %s.", m.Target, dir)
+			}
+		}
+		if targets[m.Target] {
+			return fmt.Errorf("Duplicate mount target %s.", m.Target)
+		}
+		targets[m.Target] = true
+
+		if m.bind() {
+			if path.Clean(m.Source) != m.Source || strings.Contains(m.Source, ":") {
+				return fmt.Errorf("Invalid mount source %s. Should be a clean absolute path.", m.Source)
+			}
+		} else if !volumeNamePattern.MatchString(m.Source) {
+			return fmt.Errorf("Invalid mount source %q. Should be a volume name or an absolute path.", m.Source)
+		}
+	}
+	return nil
+}
+
+// Adds the mounts to the container. Named volumes must exist, like the file
+// systems of Lambda functions, rather than be created empty by Docker.
+func applyMounts(client *docker.Client, mounts []Mount, hostConfig *docker.HostConfig) error {
+	for _, m := range mounts {
+		if !m.bind() {
+			if _, err := client.InspectVolume(m.Source); err != nil {
+				if err == docker.ErrNoSuchVolume {
+					return fmt.Errorf("Volume %s does not exist.", m.Source)
+				}
+				return err
+			}
+		}
+		hostConfig.Binds = append(hostConfig.Binds, m.String())
+	}
+	return nil
+}
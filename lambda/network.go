@@ -0,0 +1,178 @@
+package lambda
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/iron-io/lambda/lambda/egress"
+)
+
+const (
+	// Docker's default bridge, with full network access.
+	NetworkBridge = "bridge"
+	// Only a loopback interface, like a Lambda function that can not reach
+	// anything.
+	NetworkNone = "none"
+
+	// Networks and proxies of egress allow-lists carry this label.
+	egressLabel = "io.iron.lambda.egress"
+)
+
+// The image egress proxies run, built from images/egress.
+var EgressProxyImage = "iron/lambda-egress"
+
+// The network a function's container is attached to.
+type NetworkOptions struct {
+	// NetworkBridge, or empty, NetworkNone, or the name of an existing
+	// Docker network, for example one that reaches the resources of a VPC.
+	Mode string
+
+	// DNS servers, as IP addresses, and search domains used instead of the
+	// Docker daemon's.
+	DNS       []string
+	DNSSearch []string
+
+	// If set, the function can only connect to these destinations, see
+	// egress.AllowList, through the proxy in HTTP_PROXY and HTTPS_PROXY. The
+	// container runs on an internal network shared by functions with the
+	// same rules, where the proxy is the only way out.
+	EgressAllow []string
+}
+
+func (o NetworkOptions) validate() error {
+	switch {
+	case o.Mode == "host" || strings.HasPrefix(o.Mode, "container:"):
+		return fmt.Errorf("Invalid network %s. Functions can not share the network of the host or other containers.", o.Mode)
+	case o.Mode == NetworkNone && (len(o.DNS) > 0 || len(o.DNSSearch) > 0 || len(o.EgressAllow) > 0):
+		return errors.New("Functions without network can not have DNS settings or egress rules.")
+	case len(o.EgressAllow) > 0 && o.Mode != "" && o.Mode != NetworkBridge:
+		return fmt.Errorf("Functions with egress rules run on their own network and can not use network %s.", o.Mode)
+	}
+
+	for _, server := range o.DNS {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("Invalid DNS server %s. Should be an IP address.", server)
+		}
+	}
+
+	_, err := egress.ParseAllowList(o.EgressAllow)
+	return err
+}
+
+// Attaches the container to its network, creating the network of its egress
+// rules if needed, and returns the environment the function needs.
+func (o NetworkOptions) apply(client *docker.Client, hostConfig *docker.HostConfig) ([]string, error) {
+	hostConfig.DNS = o.DNS
+	hostConfig.DNSSearch = o.DNSSearch
+
+	switch {
+	case len(o.EgressAllow) > 0:
+		network, err := egressNetwork(client, o.EgressAllow)
+		if err != nil {
+			return nil, err
+		}
+		hostConfig.NetworkMode = network
+
+		proxy := "http://" + egress.ProxyHost + ":" + egress.ProxyPort
+		// Tools disagree on the case of these.
+		return []string{
+			"HTTP_PROXY=" + proxy, "http_proxy=" + proxy,
+			"HTTPS_PROXY=" + proxy, "https_proxy=" + proxy,
+			"NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1",
+		}, nil
+	case o.Mode == "" || o.Mode == NetworkBridge:
+	case o.Mode == NetworkNone:
+		hostConfig.NetworkMode = NetworkNone
+	default:
+		if _, err := client.NetworkInfo(o.Mode); err != nil {
+			if _, ok := err.(*docker.NoSuchNetwork); ok {
+				return nil, fmt.Errorf("Network %s does not exist.", o.Mode)
+			}
+			return nil, err
+		}
+		hostConfig.NetworkMode = o.Mode
+	}
+	return nil, nil
+}
+
+// Serializes setting up egress networks, so concurrent invocations do not
+// race to create the same one.
+var egressMu sync.Mutex
+
+// Returns the internal network of the allow-list `rules`, creating it and
+// starting its proxy if needed. The proxy is also attached to the default
+// bridge, its way out. Both are named after the rules, so they are reused
+// across invocations and restarts, and are left running.
+func egressNetwork(client *docker.Client, rules []string) (string, error) {
+	allow, err := egress.ParseAllowList(rules)
+	if err != nil {
+		return "", err
+	}
+	entries := strings.Join(allow.Entries(), ",")
+	name := fmt.Sprintf("iron-lambda-egress-%x", sha256.Sum256([]byte(entries)))[:31]
+	labels := map[string]string{egressLabel: entries}
+
+	egressMu.Lock()
+	defer egressMu.Unlock()
+
+	if _, err := client.NetworkInfo(name); err != nil {
+		if _, ok := err.(*docker.NoSuchNetwork); !ok {
+			return "", err
+		}
+		_, err := client.CreateNetwork(docker.CreateNetworkOptions{
+			Name:           name,
+			Driver:         "bridge",
+			Internal:       true,
+			CheckDuplicate: true,
+			Labels:         labels,
+		})
+		if err != nil {
+			return "", fmt.Errorf("Could not create egress network %s: %s", name, err)
+		}
+	}
+
+	proxy, err := client.InspectContainer(name)
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		proxy, err = createEgressProxy(client, name, entries, labels)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !proxy.State.Running {
+		if err := client.StartContainer(proxy.ID, nil); err != nil {
+			return "", fmt.Errorf("Could not start egress proxy %s: %s", name, err)
+		}
+	}
+	return name, nil
+}
+
+func createEgressProxy(client *docker.Client, name string, entries string, labels map[string]string) (*docker.Container, error) {
+	proxy, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name: name,
+		Config: &docker.Config{
+			Image:  EgressProxyImage,
+			Env:    []string{"EGRESS_ALLOW=" + entries},
+			Labels: labels,
+		},
+		HostConfig: &docker.HostConfig{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not create egress proxy from %s: %s", EgressProxyImage, err)
+	}
+
+	err = client.ConnectNetwork(name, docker.NetworkConnectionOptions{
+		Container:      proxy.ID,
+		EndpointConfig: &docker.EndpointConfig{Aliases: []string{egress.ProxyHost}},
+	})
+	if err != nil {
+		client.RemoveContainer(docker.RemoveContainerOptions{ID: proxy.ID, Force: true})
+		return nil, fmt.Errorf("Could not attach egress proxy to %s: %s", name, err)
+	}
+	return proxy, nil
+}
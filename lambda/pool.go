@@ -0,0 +1,695 @@
+package lambda
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/iron-io/lambda/lambda/trace"
+	"github.com/satori/go.uuid"
+)
+
+// Warm containers keep the runtime, and the function once it has been loaded,
+// alive between invocations. They are started with PAYLOAD_STREAM=1, which
+// tells the bootstrap to read one invocation per line from stdin instead of a
+// single payload:
+//
+//	{"id": "<request id>", "deadline": <unix time in ms>, "payload": "<payload>", "trace": "<X-Amzn-Trace-Id>", "traceparent": "<traceparent>"}
+//
+// The bootstrap sets _X_AMZN_TRACE_ID and TRACEPARENT to the trace fields for
+// the invocation, and unsets them when they are empty.
+//
+// The bootstrap writes "\x1elambda ready\n" to stdout once it can accept
+// invocations, and "\x1elambda done <request id> <exit code>\n" to both stdout
+// and stderr after each invocation, with exit code 1 if the handler could not
+// be loaded or threw. Only the nodejs and python bootstraps support this.
+
+const (
+	markerStart  = '\x1e'
+	markerPrefix = "lambda "
+)
+
+// Lambda keeps idle containers around for a few minutes.
+const DefaultIdleTimeout = 5 * time.Minute
+
+var ErrorPoolClosed = errors.New("Pool is closed")
+
+type PoolOptions struct {
+	// Idle containers beyond the provisioned count are recycled after this
+	// long. Zero means DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// Containers are recycled after this many invocations. Zero means no
+	// limit.
+	MaxUses int
+}
+
+// A Pool runs invocations in long-lived containers, so only the first
+// invocation on each container pays for starting the runtime. A number of
+// containers can be kept started ahead of time for each function image.
+type Pool struct {
+	opts PoolOptions
+
+	mu        sync.Mutex
+	functions map[string]*functionPool
+	closed    bool
+	done      chan struct{}
+}
+
+type functionPool struct {
+	imageName  string
+	runOpts    RunOptions
+	warm       int
+	generation int // Incremented when runOpts change.
+	idle       []*warmContainer
+	total      int // Idle, busy and starting containers.
+}
+
+func NewPool(opts PoolOptions) *Pool {
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+
+	p := &Pool{
+		opts:      opts,
+		functions: make(map[string]*functionPool),
+		done:      make(chan struct{}),
+	}
+	go p.reap()
+	return p
+}
+
+// Keeps `warm` started containers for `imageName` ready, run with `opts`.
+// Changing the options of a function recycles its containers. The streams in
+// `opts` are ignored, output goes to the streams passed to Run, and so is the
+// trace, which is passed to RunWithTrace.
+func (p *Pool) Provision(imageName string, warm int, opts RunOptions) error {
+	opts.OutputStream, opts.ErrorStream = nil, nil
+	opts.Trace = nil
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if warm < 0 {
+		return fmt.Errorf("Invalid warm container count %d.", warm)
+	}
+	if opts.DebugPort != 0 {
+		return errors.New("Warm containers can not be debugged, they would share the debug port.")
+	}
+	if opts.Source != "" {
+		return errors.New("Warm containers can not load source from a directory, they would keep running the first version.")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrorPoolClosed
+	}
+
+	fp := p.function(imageName)
+	if !reflect.DeepEqual(fp.runOpts, opts) {
+		fp.runOpts = opts
+		fp.generation++
+		// Busy containers are recycled when they are released.
+		for _, c := range fp.idle {
+			go c.destroy()
+		}
+		fp.total -= len(fp.idle)
+		fp.idle = nil
+	}
+	fp.warm = warm
+	p.replenish(fp)
+	return nil
+}
+
+// Runs `imageName` with `payload` in an idle container, starting a new one if
+// there are none. Functions that were not provisioned use the default
+// RunOptions. Invocations over the concurrency limit of the Limiter in the
+// RunOptions, if any, fail with ErrorThrottled. The function's result is
+// written to `stdout` and its log to `stderr`, nil means os.Stdout and
+// os.Stderr respectively.
+func (p *Pool) Run(imageName string, payload string, stdout, stderr io.Writer) error {
+	return p.RunWithTrace(imageName, payload, nil, stdout, stderr)
+}
+
+// Like Run, with the invocation part of trace `tc`, a new trace if nil. The
+// invocation is traced with spans for starting a container, if there was no
+// idle one, and running the handler.
+func (p *Pool) RunWithTrace(imageName string, payload string, tc *trace.Context, stdout, stderr io.Writer) (err error) {
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	opts := p.options(imageName)
+	if opts.Limiter != nil {
+		if err := opts.Limiter.Acquire(imageName); err != nil {
+			return err
+		}
+		defer opts.Limiter.Release(imageName)
+	}
+
+	requestID := uuid.NewV4().String()
+	span := startInvocationSpan(opts.Tracer, tc, imageName, requestID, false)
+	defer func() { span.Finish(err) }()
+
+	endMetrics := startInvocationMetrics(imageName)
+	start := time.Now()
+	fp, c, cold, err := p.acquire(imageName)
+	if cold || err != nil {
+		// Only invocations that had to start a container get the span.
+		startSpan := span.Child("container start")
+		startSpan.Start = start
+		startSpan.Finish(err)
+	}
+	if err != nil {
+		endMetrics(true, nil, err)
+		return err
+	}
+	if cold {
+		span.SetAttribute("faas.coldstart", "true")
+	}
+
+	handlerSpan := span.Child("handler")
+	report, err := c.invoke(requestID, payload, handlerSpan.Context(), stdout, stderr)
+	handlerSpan.Finish(err)
+	endMetrics(cold, report, err)
+	p.release(fp, c)
+	return err
+}
+
+// Removes all idle containers. Busy containers are removed when their
+// invocation finishes.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+
+	var idle []*warmContainer
+	for _, fp := range p.functions {
+		idle = append(idle, fp.idle...)
+		fp.total -= len(fp.idle)
+		fp.idle = nil
+	}
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.destroy()
+	}
+}
+
+// The options `imageName` was provisioned with, or the defaults.
+func (p *Pool) options(imageName string) RunOptions {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fp, ok := p.functions[imageName]; ok {
+		return fp.runOpts
+	}
+	return RunOptions{}
+}
+
+// Must be called with p.mu held.
+func (p *Pool) function(imageName string) *functionPool {
+	fp, ok := p.functions[imageName]
+	if !ok {
+		fp = &functionPool{imageName: imageName}
+		fp.runOpts.Validate()
+		p.functions[imageName] = fp
+	}
+	return fp
+}
+
+// Also returns whether the container was started for this invocation.
+func (p *Pool) acquire(imageName string) (*functionPool, *warmContainer, bool, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, nil, false, ErrorPoolClosed
+	}
+
+	fp := p.function(imageName)
+	// Most recently used first, so the rest can go idle and be recycled.
+	for len(fp.idle) > 0 {
+		c := fp.idle[len(fp.idle)-1]
+		fp.idle = fp.idle[:len(fp.idle)-1]
+		if c.unusable() {
+			fp.total--
+			go c.destroy()
+			continue
+		}
+		p.mu.Unlock()
+		return fp, c, false, nil
+	}
+
+	fp.total++
+	opts, generation := fp.runOpts, fp.generation
+	p.mu.Unlock()
+
+	c, err := startWarmContainer(imageName, opts)
+	if err != nil {
+		p.mu.Lock()
+		fp.total--
+		p.mu.Unlock()
+		return nil, nil, false, err
+	}
+	c.generation = generation
+	return fp, c, true, nil
+}
+
+func (p *Pool) release(fp *functionPool, c *warmContainer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	used := p.opts.MaxUses > 0 && c.uses >= p.opts.MaxUses
+	if p.closed || used || c.unusable() || c.generation != fp.generation {
+		fp.total--
+		go c.destroy()
+		p.replenish(fp)
+		return
+	}
+
+	c.lastUsed = time.Now()
+	fp.idle = append(fp.idle, c)
+}
+
+// Starts containers until the function has as many as were provisioned. Must
+// be called with p.mu held.
+func (p *Pool) replenish(fp *functionPool) {
+	if p.closed {
+		return
+	}
+
+	for fp.total < fp.warm {
+		fp.total++
+		go p.startIdle(fp, fp.runOpts, fp.generation)
+	}
+}
+
+func (p *Pool) startIdle(fp *functionPool, opts RunOptions, generation int) {
+	c, err := startWarmContainer(fp.imageName, opts)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		// The reaper will try again.
+		fp.total--
+		log.Println("Could not start warm container for", fp.imageName, err)
+		return
+	}
+
+	c.generation = generation
+	if p.closed || generation != fp.generation {
+		fp.total--
+		go c.destroy()
+		p.replenish(fp)
+		return
+	}
+
+	c.lastUsed = time.Now()
+	fp.idle = append(fp.idle, c)
+}
+
+// Recycles containers that have been idle for too long, unless they are
+// provisioned, and tops functions back up to their provisioned count.
+func (p *Pool) reap() {
+	interval := p.opts.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for _, fp := range p.functions {
+				// Provisioned containers are kept however long they are
+				// idle, least recently used ones are recycled first.
+				excess := fp.total - fp.warm
+				kept := fp.idle[:0]
+				for _, c := range fp.idle {
+					switch {
+					case c.unusable():
+					case now.Sub(c.lastUsed) > p.opts.IdleTimeout && excess > 0:
+						excess--
+					default:
+						kept = append(kept, c)
+						continue
+					}
+					fp.total--
+					go c.destroy()
+				}
+				fp.idle = kept
+				p.replenish(fp)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+type streamInvocation struct {
+	ID       string `json:"id"`
+	Deadline int64  `json:"deadline"` // Unix time in milliseconds.
+	Payload  string `json:"payload"`
+
+	Trace       string `json:"trace,omitempty"`
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+type warmContainer struct {
+	client    *docker.Client
+	id        string
+	imageName string
+	opts      RunOptions
+	stdin     *io.PipeWriter
+	attached  docker.CloseWaiter
+	stdout    *markerWriter
+	stderr    *markerWriter
+	memory    *memoryWatcher
+	exited    chan struct{} // Closed when the container exits.
+	exitCode  int           // Only valid once exited is closed.
+	// When the AWS credentials in the container's environment expire, zero
+	// if they do not.
+	credsExpire time.Time
+
+	// Owned by the Pool.
+	generation int
+	uses       int
+	lastUsed   time.Time
+}
+
+// Creates and starts a container and waits for its bootstrap to be ready for
+// invocations.
+func startWarmContainer(imageName string, opts RunOptions) (*warmContainer, error) {
+	client, err := getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	createOpts, credsExpire, err := createContainerOptions(imageName, opts)
+	if err != nil {
+		return nil, err
+	}
+	createOpts.Config.Env = append(createOpts.Config.Env, "PAYLOAD_STREAM=1")
+	createOpts.Config.OpenStdin = true
+	createOpts.Config.AttachStdin = true
+
+	container, err := client.CreateContainer(createOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	stdinReader, stdinWriter := io.Pipe()
+	c := &warmContainer{
+		client:    client,
+		id:        container.ID,
+		opts:      opts,
+		imageName: imageName,
+		stdin:     stdinWriter,
+		stdout:    newMarkerWriter(),
+		stderr:    newMarkerWriter(),
+		exited:    make(chan struct{}),
+
+		credsExpire: credsExpire,
+	}
+
+	c.attached, err = client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+		Container:    container.ID,
+		InputStream:  stdinReader,
+		OutputStream: c.stdout,
+		ErrorStream:  c.stderr,
+		Stream:       true,
+		Stdin:        true,
+		Stdout:       true,
+		Stderr:       true,
+	})
+	if err != nil {
+		c.destroy()
+		return nil, err
+	}
+
+	if err := client.StartContainer(container.ID, nil); err != nil {
+		c.destroy()
+		return nil, err
+	}
+	c.memory = watchMemory(client, container.ID, false)
+
+	go func() {
+		exitCode, err := client.WaitContainer(container.ID)
+		if err != nil {
+			exitCode = -1
+		}
+		c.exitCode = exitCode
+		close(c.exited)
+	}()
+
+	// Bootstraps without warm container support wait for stdin to be closed
+	// and never get ready.
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+	select {
+	case marker := <-c.stdout.markers:
+		if marker == "ready" {
+			return c, nil
+		}
+		err = fmt.Errorf("Unexpected marker %q from %s while waiting for it to be ready", marker, imageName)
+	case <-c.exited:
+		err = fmt.Errorf("Container for %s exited with code %d before it was ready", imageName, c.exitCode)
+	case <-timer.C:
+		err = fmt.Errorf("Container for %s was not ready after %s. Does the image support warm containers?", imageName, opts.Timeout)
+	}
+
+	c.destroy()
+	return nil, err
+}
+
+// Runs invocation `requestID` in trace `tc` and returns its report.
+func (c *warmContainer) invoke(requestID string, payload string, tc trace.Context, stdout, stderr io.Writer) (*invocationReport, error) {
+	c.uses++
+
+	stdout, stderr, flushLogs := captureLogs(c.opts, c.imageName, requestID, stdout, stderr)
+	defer flushLogs()
+	stdout, stderr, violations := watchViolations(c.opts, stdout, stderr)
+
+	c.stdout.setTarget(stdout)
+	c.stderr.setTarget(stderr)
+	defer c.stdout.setTarget(nil)
+	defer c.stderr.setTarget(nil)
+
+	report := &invocationReport{requestID: requestID, memorySize: c.opts.MemorySize}
+	c.memory.reset()
+
+	start := time.Now()
+	frame, err := json.Marshal(streamInvocation{
+		ID:       requestID,
+		Deadline: start.Add(c.opts.Timeout).UnixNano() / int64(time.Millisecond),
+		Payload:  payload,
+
+		Trace:       tc.XRay(),
+		Traceparent: tc.Traceparent(),
+	})
+	if err != nil {
+		return report, err
+	}
+
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+
+	report.writeStart(stderr)
+	// Writing blocks until the container reads stdin, which a stuck
+	// bootstrap never does.
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.stdin.Write(append(frame, '\n'))
+		written <- err
+	}()
+	exitCode := 0
+	select {
+	case err = <-written:
+		if err != nil {
+			c.kill()
+		} else {
+			exitCode, err = c.waitDone(requestID, timer.C)
+		}
+	case <-timer.C:
+		err = ErrorTimeout
+	}
+	report.duration = time.Since(start)
+	report.maxMemoryUsed = c.memory.peak()
+	if err == ErrorTimeout {
+		c.kill()
+	}
+	report.writeEnd(stderr)
+
+	if err == ErrorTimeout {
+		report.writeTimedOut(stderr, c.opts.Timeout)
+		return report, err
+	}
+	if err != nil {
+		// The container may have crashed.
+		return report, violations.wrap(err, c.memory.peakPids())
+	}
+
+	if exitCode != 0 {
+		return report, violations.wrap(&ExitError{exitCode}, c.memory.peakPids())
+	}
+	return report, nil
+}
+
+// Waits for both output streams to report the end of the invocation, and
+// returns the exit code the bootstrap reported.
+func (c *warmContainer) waitDone(requestID string, timeout <-chan time.Time) (int, error) {
+	exitCode := 0
+	for _, w := range []*markerWriter{c.stdout, c.stderr} {
+		select {
+		case marker := <-w.markers:
+			fields := strings.Fields(marker)
+			if len(fields) != 3 || fields[0] != "done" || fields[1] != requestID {
+				c.kill()
+				return 0, fmt.Errorf("Unexpected marker %q while waiting for %s", marker, requestID)
+			}
+			code, err := strconv.Atoi(fields[2])
+			if err != nil {
+				c.kill()
+				return 0, fmt.Errorf("Invalid exit code in marker %q", marker)
+			}
+			exitCode = code
+		case <-c.exited:
+			// Make sure all output has been copied.
+			c.attached.Wait()
+			return 0, &ExitError{c.exitCode}
+		case <-timeout:
+			return 0, ErrorTimeout
+		}
+	}
+	return exitCode, nil
+}
+
+// Whether the container can not take another invocation, because it exited
+// or its credentials could expire during the invocation.
+func (c *warmContainer) unusable() bool {
+	if c.dead() {
+		return true
+	}
+	return !c.credsExpire.IsZero() && time.Now().Add(c.opts.Timeout).After(c.credsExpire)
+}
+
+func (c *warmContainer) dead() bool {
+	select {
+	case <-c.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// Kills the container and waits for all its output to be copied.
+func (c *warmContainer) kill() {
+	c.client.KillContainer(docker.KillContainerOptions{ID: c.id, Signal: docker.SIGKILL})
+	<-c.exited
+	c.attached.Wait()
+}
+
+func (c *warmContainer) destroy() {
+	c.stdin.Close()
+	if c.memory != nil {
+		c.memory.stop()
+	}
+	if c.attached != nil {
+		c.attached.Close()
+	}
+	c.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID: c.id, RemoveVolumes: true, Force: true,
+	})
+}
+
+// Forwards container output to the current invocation's writer and picks out
+// the markers written by the bootstrap.
+type markerWriter struct {
+	mu       sync.Mutex
+	target   io.Writer
+	inMarker bool
+	marker   []byte
+	markers  chan string
+}
+
+func newMarkerWriter() *markerWriter {
+	return &markerWriter{markers: make(chan string, 4)}
+}
+
+func (w *markerWriter) setTarget(target io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.target = target
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		if w.inMarker {
+			i := bytes.IndexByte(p, '\n')
+			if i < 0 {
+				w.marker = append(w.marker, p...)
+				break
+			}
+			w.marker = append(w.marker, p[:i]...)
+			w.endMarker()
+			p = p[i+1:]
+			continue
+		}
+
+		i := bytes.IndexByte(p, markerStart)
+		if i < 0 {
+			w.forward(p)
+			break
+		}
+		w.forward(p[:i])
+		w.inMarker = true
+		w.marker = w.marker[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (w *markerWriter) endMarker() {
+	w.inMarker = false
+	if !bytes.HasPrefix(w.marker, []byte(markerPrefix)) {
+		// Not ours, pass it through untouched.
+		w.forward([]byte{markerStart})
+		w.forward(w.marker)
+		w.forward([]byte{'\n'})
+		return
+	}
+
+	select {
+	case w.markers <- string(w.marker[len(markerPrefix):]):
+	default:
+		log.Println("Dropping unexpected marker", string(w.marker))
+	}
+}
+
+func (w *markerWriter) forward(p []byte) {
+	if w.target != nil && len(p) > 0 {
+		w.target.Write(p)
+	}
+}
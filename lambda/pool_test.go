@@ -0,0 +1,90 @@
+package lambda
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMarkerWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newMarkerWriter()
+	w.setTarget(&out)
+
+	// Markers can be split across writes and follow output without a newline.
+	w.Write([]byte("hello\n{\"result\": 1}\x1elambda do"))
+	w.Write([]byte("ne abc 0\nafter\x1enot a marker\n"))
+
+	if out.String() != "hello\n{\"result\": 1}after\x1enot a marker\n" {
+		t.Fatalf("Unexpected output %q", out.String())
+	}
+
+	select {
+	case marker := <-w.markers:
+		if marker != "done abc 0" {
+			t.Fatalf("Unexpected marker %q", marker)
+		}
+	default:
+		t.Fatal("Expected a marker")
+	}
+
+	select {
+	case marker := <-w.markers:
+		t.Fatalf("Unexpected second marker %q", marker)
+	default:
+	}
+}
+
+func TestMemoryWatcherPerInvocation(t *testing.T) {
+	m := &memoryWatcher{}
+	m.sample(100, 500)
+	m.sample(300, 500)
+	if m.peak() != 300 {
+		t.Fatal("Expected the cgroup's lifetime peak to be ignored", m.peak())
+	}
+
+	// The next invocation starts from the memory the container holds.
+	m.sample(120, 500)
+	m.reset()
+	if m.peak() != 120 {
+		t.Fatal("Expected the peak to start from the last sample", m.peak())
+	}
+	m.sample(200, 500)
+	if m.peak() != 200 {
+		t.Fatal("Unexpected peak", m.peak())
+	}
+
+	m.samplePids(1024)
+	m.samplePids(3)
+	m.reset()
+	if m.peakPids() != 3 {
+		t.Fatal("Expected the pids peak to start from the last sample", m.peakPids())
+	}
+
+	single := &memoryWatcher{single: true}
+	single.sample(100, 500)
+	if single.peak() != 500 {
+		t.Fatal("Expected the cgroup's peak for a single invocation", single.peak())
+	}
+}
+
+func TestPoolHandlerThrows(t *testing.T) {
+	name := "iron-test/lambda-nodejs-throws"
+	err := buildTestFunction(name, "test.run", map[string]string{
+		"test.js": `exports.run = function(event, context) { throw new Error("FAIL") }`,
+	})
+	if err != nil {
+		t.Fatal("CreateImage failed", err)
+	}
+	defer client.RemoveImage(name)
+
+	p := NewPool(PoolOptions{})
+	defer p.Close()
+	// The first invocation starts the container, the second one is warm.
+	for i := 0; i < 2; i++ {
+		err := p.Run(name, `{}`, ioutil.Discard, ioutil.Discard)
+		if e, ok := err.(*ExitError); !ok || e.Code != 1 {
+			t.Fatal("Expected the throwing handler to fail", i, err)
+		}
+	}
+}
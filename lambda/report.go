@@ -0,0 +1,153 @@
+package lambda
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Timestamp format used at the start of Lambda log lines.
+const logTimestampFormat = "2006-01-02T15:04:05.000Z"
+
+// Lambda bills in 100ms increments.
+const billingIncrement = 100 * time.Millisecond
+
+// The START, END and REPORT lines CloudWatch logs around every invocation.
+type invocationReport struct {
+	requestID     string
+	memorySize    int64 // In MB.
+	duration      time.Duration
+	maxMemoryUsed uint64 // In bytes.
+}
+
+func (r *invocationReport) writeStart(w io.Writer) {
+	fmt.Fprintf(w, "START RequestId: %s Version: $LATEST\n", r.requestID)
+}
+
+func (r *invocationReport) writeEnd(w io.Writer) {
+	fmt.Fprintf(w, "END RequestId: %s\n", r.requestID)
+	fmt.Fprintf(w, "REPORT RequestId: %s\tDuration: %.2f ms\tBilled Duration: %d ms \tMemory Size: %d MB\tMax Memory Used: %d MB\t\n",
+		r.requestID,
+		float64(r.duration)/float64(time.Millisecond),
+		billedDuration(r.duration)/time.Millisecond,
+		r.memorySize,
+		r.maxMemoryUsed/(1024*1024))
+}
+
+// Same line AWS logs after the REPORT, so output can be compared.
+func (r *invocationReport) writeTimedOut(w io.Writer, timeout time.Duration) {
+	fmt.Fprintf(w, "%s %s Task timed out after %.2f seconds\n", time.Now().UTC().Format(logTimestampFormat), r.requestID, timeout.Seconds())
+}
+
+// Rounds up to the next billing increment. Lambda bills at least one.
+func billedDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return billingIncrement
+	}
+	return ((d + billingIncrement - 1) / billingIncrement) * billingIncrement
+}
+
+// Tracks the peak memory usage of a running container from docker stats, and
+// the most processes and threads it ran, which confirm sandbox violations.
+type memoryWatcher struct {
+	done     chan bool
+	finished chan struct{}
+	// Whether the container runs a single invocation, so the cgroup's high
+	// water mark is the invocation's peak.
+	single bool
+
+	mu    sync.Mutex
+	usage uint64 // The last sample.
+	max   uint64
+	pids  uint64 // The last sample.
+	// The most pids seen so far.
+	maxPids uint64
+}
+
+func watchMemory(client *docker.Client, containerID string, single bool) *memoryWatcher {
+	m := &memoryWatcher{
+		done:     make(chan bool),
+		finished: make(chan struct{}),
+		single:   single,
+	}
+
+	stats := make(chan *docker.Stats)
+	go func() {
+		// Stats closes the channel when it returns.
+		client.Stats(docker.StatsOptions{
+			ID:     containerID,
+			Stats:  stats,
+			Stream: true,
+			Done:   m.done,
+		})
+	}()
+
+	go func() {
+		defer close(m.finished)
+		for s := range stats {
+			m.sample(s.MemoryStats.Usage, s.MemoryStats.MaxUsage)
+			m.samplePids(s.PidsStats.Current)
+		}
+	}()
+
+	return m
+}
+
+func (m *memoryWatcher) sample(usage uint64, maxUsage uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage = usage
+	peak := usage
+	// MaxUsage is the cgroup's high water mark, so a single sample is
+	// enough even for short invocations.
+	if m.single && maxUsage > peak {
+		peak = maxUsage
+	}
+	if peak > m.max {
+		m.max = peak
+	}
+}
+
+func (m *memoryWatcher) samplePids(pids uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pids = pids
+	if pids > m.maxPids {
+		m.maxPids = pids
+	}
+}
+
+// Starts measuring the next invocation of a container that runs several. The
+// memory the container holds when the invocation starts counts, since
+// samples are too far apart for short invocations.
+func (m *memoryWatcher) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.max = m.usage
+	m.maxPids = m.pids
+}
+
+// The peak memory usage in bytes seen so far.
+func (m *memoryWatcher) peak() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.max
+}
+
+func (m *memoryWatcher) peakPids() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.maxPids
+}
+
+// Stops watching and returns the peak memory usage in bytes.
+func (m *memoryWatcher) stop() uint64 {
+	close(m.done)
+	<-m.finished
+	return m.peak()
+}
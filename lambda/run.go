@@ -0,0 +1,598 @@
+package lambda
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/logs"
+	"github.com/iron-io/lambda/lambda/secrets"
+	"github.com/iron-io/lambda/lambda/trace"
+	"github.com/satori/go.uuid"
+)
+
+// Returned when a function was killed for running longer than its timeout.
+var ErrorTimeout = errors.New("Task timed out")
+
+// Returned when a function ran to completion but failed.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("Container exited with non-zero exit code %d", e.Code)
+}
+
+const (
+	// Lambda allocates memory in 128MB steps between MinMemorySize and
+	// MaxMemorySize. CPU is allocated proportionally, with a function at
+	// MaxMemorySize getting one full CPU.
+	MinMemorySize  = 128
+	MaxMemorySize  = 1536
+	MemorySizeStep = 128
+
+	// Lambda does not allow functions to run longer than 5 minutes.
+	MaxTimeout = 300 * time.Second
+
+	// Lambda's defaults.
+	DefaultMemorySize = 128
+	DefaultTimeout    = 3 * time.Second
+
+	cpuPeriod = 100000 // microseconds, the docker default.
+)
+
+// How the payload gets into the container. All the bootstraps read
+// PAYLOAD_FILE if it is set and stdin otherwise.
+type PayloadDelivery int
+
+const (
+	// Write the payload to the container's stdin. Nothing touches the disk.
+	PayloadStdin PayloadDelivery = iota
+	// Copy the payload into the container before it starts.
+	PayloadCopy
+	// Write the payload to a host temp dir and bind mount it at
+	// /lambda-payload. Does not work with remote docker daemons.
+	PayloadBind
+)
+
+const (
+	payloadFileName = "payload.json"
+	payloadCopyDir  = "/lambda-payload"
+	// Not /mnt, where the python bootstrap looks for modules and Lambda
+	// mounts file systems.
+	payloadBindDir = "/lambda-payload"
+)
+
+type RunOptions struct {
+	MemorySize int64         // In MB. Zero means DefaultMemorySize.
+	Timeout    time.Duration // Zero means DefaultTimeout.
+	Payload    PayloadDelivery
+
+	// The bootstraps write the function's result to stdout and its log to
+	// stderr. Nil means os.Stdout and os.Stderr respectively.
+	OutputStream io.Writer
+	ErrorStream  io.Writer
+
+	// The AWS credentials the function gets.
+	Credentials CredentialsOptions
+	// If set, the function gets its credentials from this server instead of
+	// environment variables holding them.
+	CredentialsServer *CredentialsServer
+
+	// If set, the output of every invocation is also written to the sink as
+	// records.
+	LogSink logs.Sink
+
+	// Environment variables of the function. Values encrypted with
+	// envcrypt, here or in the image, are decrypted with EnvKey just before
+	// the container starts.
+	Environment map[string]string
+	EnvKey      envcrypt.Key
+	// Resolves values of Environment that refer to secrets, like
+	// `secret:db/password` or `ssm:/prod/api-key`, when the container is
+	// created. Nil means the default secret store.
+	Secrets *secrets.Resolver
+
+	// If set, every invocation takes a slot for the image from the limiter,
+	// and fails with ErrorThrottled, without starting a container, when there
+	// is none. Set it to keep bursts from starting containers until the host
+	// runs out of memory.
+	Limiter *Limiter
+
+	// Docker's default bridge with full network access if unset.
+	Network NetworkOptions
+
+	// Volumes and host directories mounted into the container.
+	Mounts []Mount
+
+	// If set, a directory on the Docker host the function's code is loaded
+	// from instead of the image, for trying changes without building an
+	// image, see DevRunner. It is mounted read-only. Not supported by Pool.
+	Source string
+
+	// If set, the runtime's debugger listens on this port, published on the
+	// Docker host's loopback interface, and the function only runs once a
+	// debugger attached. The timeout, and the duration in the REPORT line,
+	// start then, so raise the timeout to step through the function. Not
+	// supported by Pool.
+	DebugPort int
+
+	// Runs the function as restricted as Lambda does, see applySandbox.
+	// Functions that fail after running into a restriction return a
+	// SandboxError.
+	Sandbox bool
+
+	// If set, spans of every invocation are exported to it.
+	Tracer trace.Exporter
+	// The trace the invocation is part of, passed to the function as
+	// _X_AMZN_TRACE_ID and TRACEPARENT. Nil starts a new trace. Ignored by
+	// Pool.Provision, see Pool.RunWithTrace.
+	Trace *trace.Context
+}
+
+// Fills in defaults for unset fields and checks the remaining ones are values
+// Lambda would accept.
+func (opts *RunOptions) Validate() error {
+	if opts.MemorySize == 0 {
+		opts.MemorySize = DefaultMemorySize
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	if opts.MemorySize < MinMemorySize || opts.MemorySize > MaxMemorySize || opts.MemorySize%MemorySizeStep != 0 {
+		return fmt.Errorf("Invalid memory size %dMB. Should be a multiple of %dMB between %dMB and %dMB.", opts.MemorySize, MemorySizeStep, MinMemorySize, MaxMemorySize)
+	}
+
+	if opts.Timeout < time.Second || opts.Timeout > MaxTimeout {
+		return fmt.Errorf("Invalid timeout %s. Should be between 1s and %s.", opts.Timeout, MaxTimeout)
+	}
+
+	switch opts.Payload {
+	case PayloadStdin, PayloadCopy, PayloadBind:
+	default:
+		return fmt.Errorf("Invalid payload delivery %d.", opts.Payload)
+	}
+	if opts.Sandbox && opts.Payload == PayloadCopy {
+		return errors.New("The payload can not be copied into a sandbox, its root filesystem is read-only.")
+	}
+
+	if err := opts.Credentials.validate(); err != nil {
+		return err
+	}
+
+	if err := validateEnv(opts.Environment); err != nil {
+		return err
+	}
+
+	if err := opts.Network.validate(); err != nil {
+		return err
+	}
+
+	if err := validateMounts(opts.Mounts, opts.Sandbox); err != nil {
+		return err
+	}
+
+	if err := validateSource(opts.Source); err != nil {
+		return err
+	}
+
+	if err := validateDebugPort(opts.DebugPort, opts.Network); err != nil {
+		return err
+	}
+
+	if opts.OutputStream == nil {
+		opts.OutputStream = os.Stdout
+	}
+	if opts.ErrorStream == nil {
+		opts.ErrorStream = os.Stderr
+	}
+
+	return nil
+}
+
+func (opts RunOptions) memoryBytes() int64 {
+	return opts.MemorySize * 1024 * 1024
+}
+
+// The share of a single CPU the function gets, as a CFS quota over cpuPeriod.
+func (opts RunOptions) cpuQuota() int64 {
+	return cpuPeriod * opts.MemorySize / MaxMemorySize
+}
+
+// Whole seconds, rounded up, since that is what the bootstraps understand.
+func (opts RunOptions) timeoutSeconds() int64 {
+	return int64((opts.Timeout + time.Second - 1) / time.Second)
+}
+
+// Runs `imageName` once with `payload` as the event, using the default
+// RunOptions.
+func RunImageWithPayload(imageName string, payload string) error {
+	return RunImageWithOptions(imageName, payload, RunOptions{})
+}
+
+// Runs `imageName` once with `payload` as the event. The memory size and
+// timeout in `opts` are used both for the container limits and for the
+// TASK_MAXRAM/TASK_TIMEOUT environment the bootstraps read. A function that
+// runs longer than the timeout is killed and ErrorTimeout is returned.
+//
+// The function's log is framed by the START, END and REPORT lines CloudWatch
+// logs for every invocation.
+//
+// The invocation is throttled with ErrorThrottled when the image is over the
+// concurrency limit of opts.Limiter.
+//
+// The invocation is traced with spans for starting the container, running the
+// handler and removing the container.
+func RunImageWithOptions(imageName string, payload string, opts RunOptions) (err error) {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	if opts.Limiter != nil {
+		if err := opts.Limiter.Acquire(imageName); err != nil {
+			return err
+		}
+		defer opts.Limiter.Release(imageName)
+	}
+
+	// Every run starts a new container.
+	var report *invocationReport
+	endMetrics := startInvocationMetrics(imageName)
+	defer func() { endMetrics(true, report, err) }()
+
+	taskID := uuid.NewV4().String()
+	span := startInvocationSpan(opts.Tracer, opts.Trace, imageName, taskID, true)
+	defer func() { span.Finish(err) }()
+	startSpan := span.Child("container start")
+	// Created now so the function can be given its context, started when the
+	// container is.
+	handlerSpan := span.Child("handler")
+
+	// FIXME(nikhil): Should we bother validating JSON here?
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	var flushLogs func()
+	opts.OutputStream, opts.ErrorStream, flushLogs = captureLogs(opts, imageName, taskID, opts.OutputStream, opts.ErrorStream)
+	defer flushLogs()
+	var violations *violationWatcher
+	opts.OutputStream, opts.ErrorStream, violations = watchViolations(opts, opts.OutputStream, opts.ErrorStream)
+	var debuggerAttached <-chan struct{}
+	if opts.DebugPort != 0 {
+		opts.ErrorStream, debuggerAttached = watchAttach(opts.ErrorStream)
+	}
+
+	createOpts, _, err := createContainerOptions(imageName, opts)
+	if err != nil {
+		startSpan.Finish(err)
+		return err
+	}
+	createOpts.Config.Env = append(createOpts.Config.Env, "TASK_ID="+taskID)
+	createOpts.Config.Env = append(createOpts.Config.Env, handlerSpan.Context().Env()...)
+
+	attachOpts := docker.AttachToContainerOptions{
+		OutputStream: opts.OutputStream,
+		ErrorStream:  opts.ErrorStream,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+	}
+
+	switch opts.Payload {
+	case PayloadStdin:
+		// StdinOnce closes the container's stdin when our side of the attach
+		// is done writing, so the bootstrap sees EOF after the payload.
+		createOpts.Config.OpenStdin = true
+		createOpts.Config.StdinOnce = true
+		createOpts.Config.AttachStdin = true
+		attachOpts.Stdin = true
+		attachOpts.InputStream = strings.NewReader(payload)
+	case PayloadCopy:
+		createOpts.Config.Env = append(createOpts.Config.Env, "PAYLOAD_FILE="+payloadCopyDir+"/"+payloadFileName)
+	case PayloadBind:
+		payloadDir, err := writePayloadDir(payload)
+		if err != nil {
+			startSpan.Finish(err)
+			return err
+		}
+		defer os.RemoveAll(payloadDir)
+
+		createOpts.Config.Env = append(createOpts.Config.Env, "PAYLOAD_FILE="+payloadBindDir+"/"+payloadFileName)
+		createOpts.Config.Volumes = map[string]struct{}{payloadBindDir: {}}
+		createOpts.HostConfig.Binds = append(createOpts.HostConfig.Binds, payloadDir+":"+payloadBindDir+":ro")
+	}
+
+	container, err := client.CreateContainer(createOpts)
+	if err != nil {
+		err = fmt.Errorf("Could not create container for %s: %s", imageName, err)
+		startSpan.Finish(err)
+		return err
+	}
+
+	defer func() {
+		teardownSpan := span.Child("teardown")
+		err := client.RemoveContainer(docker.RemoveContainerOptions{
+			ID: container.ID, RemoveVolumes: true, Force: true,
+		})
+		teardownSpan.Finish(err)
+	}()
+
+	if opts.Payload == PayloadCopy {
+		if err := copyPayload(client, container.ID, payload); err != nil {
+			startSpan.Finish(err)
+			return err
+		}
+	}
+
+	// Attach before starting so no output is lost and stdin is connected by
+	// the time the bootstrap reads it.
+	attachOpts.Container = container.ID
+	attached, err := client.AttachToContainerNonBlocking(attachOpts)
+	if err != nil {
+		startSpan.Finish(err)
+		return err
+	}
+	defer attached.Close()
+
+	report = &invocationReport{requestID: taskID, memorySize: opts.MemorySize}
+	report.writeStart(opts.ErrorStream)
+	if opts.DebugPort != 0 {
+		fmt.Fprintf(opts.ErrorStream, "Waiting for a debugger on 127.0.0.1:%d\n", opts.DebugPort)
+	}
+
+	start := time.Now()
+	err = client.StartContainer(container.ID, nil)
+	if err != nil {
+		err = fmt.Errorf("Could not start container for %s: %s", imageName, err)
+	}
+	startSpan.Finish(err)
+	if err != nil {
+		report.writeEnd(opts.ErrorStream)
+		return err
+	}
+	memory := watchMemory(client, container.ID, true)
+	handlerSpan.Start = start
+	defer func() { handlerSpan.Finish(err) }()
+
+	exited := make(chan containerExit, 1)
+	go func() {
+		exitCode, err := client.WaitContainer(container.ID)
+		exited <- containerExit{exitCode, err}
+	}()
+
+	timer := time.NewTimer(opts.Timeout)
+	defer timer.Stop()
+	timeout := timer.C
+	if opts.DebugPort != 0 {
+		// Waiting for the debugger does not count.
+		timer.Stop()
+		timeout = nil
+	}
+
+	var exit containerExit
+	timedOut := false
+wait:
+	for {
+		select {
+		case exit = <-exited:
+			break wait
+		case <-debuggerAttached:
+			debuggerAttached = nil
+			start = time.Now()
+			timer.Reset(opts.Timeout)
+			timeout = timer.C
+		case <-timeout:
+			timedOut = true
+			err := client.KillContainer(docker.KillContainerOptions{ID: container.ID, Signal: docker.SIGKILL})
+			if err != nil {
+				report.duration = time.Since(start)
+				report.maxMemoryUsed = memory.stop()
+				report.writeEnd(opts.ErrorStream)
+				return err
+			}
+			break wait
+		}
+	}
+	report.duration = time.Since(start)
+	report.maxMemoryUsed = memory.stop()
+
+	// Make sure all output has been copied before ending the invocation log.
+	attached.Wait()
+	report.writeEnd(opts.ErrorStream)
+
+	if timedOut {
+		report.writeTimedOut(opts.ErrorStream, opts.Timeout)
+		return ErrorTimeout
+	}
+
+	if exit.err != nil {
+		return exit.err
+	}
+
+	if exit.code != 0 {
+		return violations.wrap(&ExitError{exit.code}, memory.peakPids())
+	}
+
+	return nil
+}
+
+// The container configuration shared by one-off runs and warm containers.
+// Per-invocation settings like TASK_ID and the payload are left to the caller.
+// Also returns when the function's AWS credentials expire, the zero time if
+// they do not.
+func createContainerOptions(imageName string, opts RunOptions) (docker.CreateContainerOptions, time.Time, error) {
+	envs := []string{}
+	envs = append(envs, "AWS_LAMBDA_FUNCTION_NAME="+imageName)
+	envs = append(envs, "AWS_LAMBDA_FUNCTION_VERSION=$LATEST")
+	// All three bootstraps understand the 'm' suffix, the python one does not
+	// understand plain bytes.
+	envs = append(envs, fmt.Sprintf("TASK_MAXRAM=%dm", opts.MemorySize))
+	envs = append(envs, fmt.Sprintf("TASK_TIMEOUT=%d", opts.timeoutSeconds()))
+
+	var credsEnv []string
+	var credsExpire time.Time
+	var err error
+	if opts.CredentialsServer != nil {
+		// The SDKs refresh credentials from the server themselves.
+		credsEnv, err = opts.CredentialsServer.Allow(imageName, opts.Credentials)
+	} else {
+		credsEnv, credsExpire, err = credentialsEnv(imageName, opts.Credentials)
+	}
+	if err != nil {
+		return docker.CreateContainerOptions{}, time.Time{}, err
+	}
+	envs = append(envs, credsEnv...)
+
+	functionEnvs, err := functionEnv(imageName, opts)
+	if err != nil {
+		return docker.CreateContainerOptions{}, time.Time{}, err
+	}
+	envs = append(envs, functionEnvs...)
+
+	hostConfig := &docker.HostConfig{
+		// Lambda has no swap.
+		Memory:     opts.memoryBytes(),
+		MemorySwap: opts.memoryBytes(),
+		CPUPeriod:  cpuPeriod,
+		CPUQuota:   opts.cpuQuota(),
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return docker.CreateContainerOptions{}, time.Time{}, err
+	}
+	networkEnvs, err := opts.Network.apply(client, hostConfig)
+	if err != nil {
+		return docker.CreateContainerOptions{}, time.Time{}, err
+	}
+	envs = append(envs, networkEnvs...)
+
+	if err := applyMounts(client, opts.Mounts, hostConfig); err != nil {
+		return docker.CreateContainerOptions{}, time.Time{}, err
+	}
+	if opts.Source != "" {
+		source := Mount{Source: opts.Source, Target: devSourceDir, ReadOnly: true}
+		hostConfig.Binds = append(hostConfig.Binds, source.String())
+		envs = append(envs, sourceDirEnv+"="+devSourceDir)
+	}
+
+	config := &docker.Config{
+		Env:      envs,
+		Hostname: "Hello",
+		Image:    imageName,
+	}
+	if opts.Sandbox {
+		applySandbox(config, hostConfig)
+	}
+	if opts.DebugPort != 0 {
+		applyDebug(opts.DebugPort, config, hostConfig)
+	}
+
+	return docker.CreateContainerOptions{
+		Config:     config,
+		HostConfig: hostConfig,
+	}, credsExpire, nil
+}
+
+type containerExit struct {
+	code int
+	err  error
+}
+
+// Also writes the output of invocation `requestID` to opts.LogSink, if set.
+// The returned function emits the last lines that did not end with a newline
+// and must be called once the output is complete.
+func captureLogs(opts RunOptions, imageName string, requestID string, stdout, stderr io.Writer) (io.Writer, io.Writer, func()) {
+	if opts.LogSink == nil {
+		return stdout, stderr, func() {}
+	}
+
+	stdoutLog := logs.NewWriter(opts.LogSink, imageName, requestID, logs.Stdout)
+	stderrLog := logs.NewWriter(opts.LogSink, imageName, requestID, logs.Stderr)
+	flush := func() {
+		stdoutLog.Close()
+		stderrLog.Close()
+	}
+	return io.MultiWriter(stdout, stdoutLog), io.MultiWriter(stderr, stderrLog), flush
+}
+
+// Writes the payload to a new directory under the system temp dir. The
+// caller is responsible for removing it.
+func writePayloadDir(payload string) (string, error) {
+	payloadDir, err := ioutil.TempDir("", "iron-lambda-")
+	if err != nil {
+		return "", err
+	}
+	// TempDir is only accessible to its owner, the sandbox's unprivileged
+	// user has to be able to read the payload too.
+	if err := os.Chmod(payloadDir, 0755); err != nil {
+		os.RemoveAll(payloadDir)
+		return "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(payloadDir, payloadFileName), []byte(payload), 0644)
+	if err != nil {
+		os.RemoveAll(payloadDir)
+		return "", errors.New(fmt.Sprintf("Error writing payload to file: %s", err.Error()))
+	}
+
+	return payloadDir, nil
+}
+
+// Uploads the payload into a created, but not yet started, container as
+// payloadCopyDir/payloadFileName.
+func copyPayload(client *docker.Client, containerID string, payload string) error {
+	r, err := makePayloadTar(payload)
+	if err != nil {
+		return err
+	}
+
+	err = client.UploadToContainer(containerID, docker.UploadToContainerOptions{
+		InputStream: r,
+		Path:        "/",
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error copying payload to container: %s", err.Error()))
+	}
+	return nil
+}
+
+func makePayloadTar(payload string) (io.Reader, error) {
+	var tarred bytes.Buffer
+	tarrer := tar.NewWriter(&tarred)
+
+	now := time.Now()
+	dir := strings.TrimPrefix(payloadCopyDir, "/")
+	err := tarrer.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: now})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tarrer.WriteHeader(&tar.Header{Name: dir + "/" + payloadFileName, Size: int64(len(payload)), Mode: 0644, ModTime: now})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(tarrer, payload); err != nil {
+		return nil, err
+	}
+
+	if err := tarrer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &tarred, nil
+}
@@ -0,0 +1,110 @@
+// Package s3events invokes functions with S3 event notifications for objects
+// in local buckets: directories that are watched for changes, or buckets of an
+// S3-compatible server that posts its notifications to us.
+package s3events
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	EventObjectCreatedPut    = "ObjectCreated:Put"
+	EventObjectRemovedDelete = "ObjectRemoved:Delete"
+
+	region = "us-east-1"
+)
+
+// The payload functions receive. S3 sends one record per event.
+type Event struct {
+	Records []*Record `json:"Records"`
+}
+
+type Record struct {
+	EventVersion      string            `json:"eventVersion"`
+	EventSource       string            `json:"eventSource"`
+	AWSRegion         string            `json:"awsRegion"`
+	EventTime         string            `json:"eventTime"`
+	EventName         string            `json:"eventName"`
+	UserIdentity      Identity          `json:"userIdentity"`
+	RequestParameters map[string]string `json:"requestParameters"`
+	ResponseElements  map[string]string `json:"responseElements"`
+	S3                Entity            `json:"s3"`
+}
+
+type Identity struct {
+	PrincipalID string `json:"principalId"`
+}
+
+type Entity struct {
+	SchemaVersion   string `json:"s3SchemaVersion"`
+	ConfigurationID string `json:"configurationId"`
+	Bucket          Bucket `json:"bucket"`
+	Object          Object `json:"object"`
+}
+
+type Bucket struct {
+	Name          string   `json:"name"`
+	OwnerIdentity Identity `json:"ownerIdentity"`
+	ARN           string   `json:"arn"`
+}
+
+// Size and ETag are only set for ObjectCreated events.
+type Object struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size,omitempty"`
+	ETag      string `json:"eTag,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// Creates a record for an event on `key` like S3 does, `eventName` is for
+// example EventObjectCreatedPut. ETags are the hex MD5 sum of the object.
+func NewRecord(eventName string, bucket string, key string, size int64, eTag string, t time.Time) *Record {
+	return &Record{
+		EventVersion: "2.0",
+		EventSource:  "aws:s3",
+		AWSRegion:    region,
+		EventTime:    t.UTC().Format("2006-01-02T15:04:05.000Z"),
+		EventName:    eventName,
+		UserIdentity: Identity{"EXAMPLE"},
+		RequestParameters: map[string]string{
+			"sourceIPAddress": "127.0.0.1",
+		},
+		ResponseElements: map[string]string{
+			"x-amz-request-id": strings.ToUpper(strings.Replace(uuid.NewV4().String(), "-", "", -1)[:16]),
+			"x-amz-id-2":       uuid.NewV4().String(),
+		},
+		S3: Entity{
+			SchemaVersion: "1.0",
+			Bucket: Bucket{
+				Name:          bucket,
+				OwnerIdentity: Identity{"EXAMPLE"},
+				ARN:           "arn:aws:s3:::" + bucket,
+			},
+			Object: Object{
+				Key:       encodeKey(key),
+				Size:      size,
+				ETag:      eTag,
+				Sequencer: fmt.Sprintf("%016X", t.UnixNano()),
+			},
+		},
+	}
+}
+
+// Keys in S3 events are URL encoded, with spaces as +.
+func encodeKey(key string) string {
+	return strings.Replace(url.QueryEscape(key), "%2F", "/", -1)
+}
+
+// The key of the object a record is about, decoded.
+func (r *Record) Key() string {
+	key, err := url.QueryUnescape(r.S3.Object.Key)
+	if err != nil {
+		return r.S3.Object.Key
+	}
+	return key
+}
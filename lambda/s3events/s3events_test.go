@@ -0,0 +1,145 @@
+package s3events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poll := func(w *watcher) []*Record {
+		records, err := w.poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	write("existing.txt", "old")
+	w, err := newWatcher("photos", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("photos/my photo.jpg", "hello")
+	write(".hidden", "ignored")
+	if records := poll(w); len(records) != 0 {
+		t.Fatal("Expected new objects to be reported once stable, got", len(records))
+	}
+
+	records := poll(w)
+	if len(records) != 1 {
+		t.Fatal("Expected one record, got", len(records))
+	}
+	r := records[0]
+	if r.EventName != EventObjectCreatedPut || r.S3.Bucket.Name != "photos" || r.S3.Bucket.ARN != "arn:aws:s3:::photos" {
+		t.Fatal("Unexpected record", r)
+	}
+	if r.S3.Object.Key != "photos/my+photo.jpg" || r.Key() != "photos/my photo.jpg" {
+		t.Fatal("Unexpected key", r.S3.Object.Key)
+	}
+	if r.S3.Object.Size != 5 || r.S3.Object.ETag != "5d41402abc4b2a76b9719d911017c592" {
+		t.Fatal("Unexpected size or eTag", r.S3.Object.Size, r.S3.Object.ETag)
+	}
+
+	if records := poll(w); len(records) != 0 {
+		t.Fatal("Expected no records for unchanged objects, got", len(records))
+	}
+
+	os.Remove(filepath.Join(dir, "existing.txt"))
+	records = poll(w)
+	if len(records) != 1 || records[0].EventName != EventObjectRemovedDelete || records[0].Key() != "existing.txt" {
+		t.Fatal("Expected a delete record, got", records)
+	}
+	b, _ := json.Marshal(records[0].S3.Object)
+	if strings.Contains(string(b), "size") || strings.Contains(string(b), "eTag") {
+		t.Fatal("Delete records should not have size and eTag", string(b))
+	}
+}
+
+func TestNotificationFilters(t *testing.T) {
+	now := time.Now()
+	put := NewRecord(EventObjectCreatedPut, "photos", "uploads/cat.jpg", 1, "x", now)
+	del := NewRecord(EventObjectRemovedDelete, "photos", "uploads/cat.jpg", 0, "", now)
+
+	tests := []struct {
+		n        Notification
+		put, del bool
+	}{
+		{Notification{}, true, false},
+		{Notification{Events: []string{"s3:ObjectRemoved:*"}}, false, true},
+		{Notification{Events: []string{"s3:ObjectCreated:Put", "s3:ObjectRemoved:Delete"}}, true, true},
+		{Notification{Events: []string{"s3:ObjectCreated:Copy"}}, false, false},
+		{Notification{Prefix: "uploads/", Suffix: ".jpg"}, true, false},
+		{Notification{Prefix: "thumbnails/"}, false, false},
+		{Notification{Suffix: ".png"}, false, false},
+	}
+	for _, test := range tests {
+		if test.n.matches(put) != test.put || test.n.matches(del) != test.del {
+			t.Fatal("Unexpected match for", test.n)
+		}
+	}
+}
+
+func TestPostedNotifications(t *testing.T) {
+	var invoked []string
+	var event Event
+	trigger, err := NewTrigger(func(function string, payload string) error {
+		invoked = append(invoked, function)
+		return json.Unmarshal([]byte(payload), &event)
+	}, []*BucketConfig{{
+		Name: "photos",
+		Notifications: []*Notification{
+			{ID: "resize", Function: "resize", Suffix: ".jpg"},
+			{Function: "cleanup", Events: []string{"s3:ObjectRemoved:*"}},
+		},
+	}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trigger.Close()
+
+	body, _ := json.Marshal(&Event{Records: []*Record{
+		NewRecord(EventObjectCreatedPut, "photos", "cat.jpg", 1, "x", time.Now()),
+		NewRecord(EventObjectCreatedPut, "photos", "cat.txt", 1, "x", time.Now()),
+		NewRecord(EventObjectCreatedPut, "unknown", "cat.jpg", 1, "x", time.Now()),
+	}})
+	r, _ := http.NewRequest("POST", "/", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	trigger.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Unexpected status", w.Code)
+	}
+	if len(invoked) != 1 || invoked[0] != "resize" {
+		t.Fatal("Unexpected invocations", invoked)
+	}
+	if len(event.Records) != 1 || event.Records[0].S3.ConfigurationID != "resize" || event.Records[0].Key() != "cat.jpg" {
+		t.Fatal("Unexpected event", event)
+	}
+
+	if _, err := NewTrigger(nil, []*BucketConfig{{Name: "b", Notifications: []*Notification{{Function: "f", Events: []string{"s3:ReducedRedundancyLostObject"}}}}}, Options{}); err == nil {
+		t.Fatal("Expected error for unsupported event type")
+	}
+}
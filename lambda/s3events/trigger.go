@@ -0,0 +1,208 @@
+package s3events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPollInterval = time.Second
+
+	// Largest notification body accepted from S3-compatible servers.
+	maxNotificationBody = 1024 * 1024
+)
+
+// Queues an Event invocation of `function`.
+type InvokeFunc func(function string, payload string) error
+
+// A local bucket. Buckets with a directory are watched for changes, others get
+// their events from an S3-compatible server, see Trigger.ServeHTTP.
+type BucketConfig struct {
+	Name          string          `json:"name"`
+	Dir           string          `json:"dir"`
+	Notifications []*Notification `json:"notifications"`
+}
+
+// Invokes a function for events on matching objects, like a Lambda function
+// configuration in a bucket notification configuration.
+type Notification struct {
+	ID       string `json:"id"`
+	Function string `json:"function"`
+	// Event types such as s3:ObjectCreated:* or s3:ObjectRemoved:Delete.
+	// Empty means s3:ObjectCreated:*.
+	Events []string `json:"events"`
+	Prefix string   `json:"prefix"`
+	Suffix string   `json:"suffix"`
+}
+
+func (n *Notification) validate() error {
+	if n.Function == "" {
+		return errors.New("Every bucket notification needs a function.")
+	}
+	for _, e := range n.Events {
+		if !strings.HasPrefix(e, "s3:ObjectCreated:") && !strings.HasPrefix(e, "s3:ObjectRemoved:") {
+			return fmt.Errorf("Unsupported event type %s, expected s3:ObjectCreated:* or s3:ObjectRemoved:*.", e)
+		}
+	}
+	return nil
+}
+
+func (n *Notification) matches(r *Record) bool {
+	key := r.Key()
+	if !strings.HasPrefix(key, n.Prefix) || !strings.HasSuffix(key, n.Suffix) {
+		return false
+	}
+
+	events := n.Events
+	if len(events) == 0 {
+		events = []string{"s3:ObjectCreated:*"}
+	}
+	for _, e := range events {
+		e = strings.TrimPrefix(e, "s3:")
+		if e == r.EventName || (strings.HasSuffix(e, ":*") && strings.HasPrefix(r.EventName, strings.TrimSuffix(e, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+type Options struct {
+	// How often bucket directories are checked for changes. Zero means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+type Trigger struct {
+	invoke  InvokeFunc
+	opts    Options
+	buckets map[string]*BucketConfig
+
+	done     chan struct{}
+	closing  sync.Once
+	watchers sync.WaitGroup
+}
+
+// Starts watching the bucket directories.
+func NewTrigger(invoke InvokeFunc, buckets []*BucketConfig, opts Options) (*Trigger, error) {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+
+	t := &Trigger{
+		invoke:  invoke,
+		opts:    opts,
+		buckets: make(map[string]*BucketConfig),
+		done:    make(chan struct{}),
+	}
+
+	for _, b := range buckets {
+		if b.Name == "" {
+			return nil, errors.New("Every bucket needs a name.")
+		}
+		if t.buckets[b.Name] != nil {
+			return nil, fmt.Errorf("Duplicate bucket %s.", b.Name)
+		}
+		for _, n := range b.Notifications {
+			if err := n.validate(); err != nil {
+				return nil, fmt.Errorf("%s: %s", b.Name, err)
+			}
+		}
+		t.buckets[b.Name] = b
+	}
+
+	for _, b := range buckets {
+		if b.Dir == "" {
+			continue
+		}
+		w, err := newWatcher(b.Name, b.Dir)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.watchers.Add(1)
+		go t.watch(w)
+	}
+	return t, nil
+}
+
+// Stops watching the bucket directories.
+func (t *Trigger) Close() {
+	t.closing.Do(func() { close(t.done) })
+	t.watchers.Wait()
+}
+
+func (t *Trigger) watch(w *watcher) {
+	defer t.watchers.Done()
+
+	ticker := time.NewTicker(t.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.done:
+			return
+		}
+
+		records, err := w.poll()
+		if err != nil {
+			log.Printf("Bucket %s: error watching %s: %s", w.bucket, w.dir, err)
+			continue
+		}
+		for _, r := range records {
+			t.Notify(r)
+		}
+	}
+}
+
+// Invokes the functions whose notifications match `r`.
+func (t *Trigger) Notify(r *Record) {
+	b, ok := t.buckets[r.S3.Bucket.Name]
+	if !ok {
+		return
+	}
+
+	for _, n := range b.Notifications {
+		if !n.matches(r) {
+			continue
+		}
+
+		record := *r
+		record.S3.ConfigurationID = n.ID
+		payload, err := json.Marshal(&Event{Records: []*Record{&record}})
+		if err != nil {
+			panic(err)
+		}
+		if err := t.invoke(n.Function, string(payload)); err != nil {
+			log.Printf("Bucket %s: error invoking %s for %s %s: %s", b.Name, n.Function, r.EventName, r.Key(), err)
+		}
+	}
+}
+
+// Accepts event notifications posted by S3-compatible servers, for example
+// Minio's webhook target. The body is an S3 event with one or more records.
+func (t *Trigger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Notifications must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var event Event
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNotificationBody)).Decode(&event); err != nil {
+		http.Error(w, "Invalid S3 event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, record := range event.Records {
+		if record != nil {
+			t.Notify(record)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
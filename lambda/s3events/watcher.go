@@ -0,0 +1,139 @@
+package s3events
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type objectInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+// Turns changes to the files in a directory into S3 events. Keys are the
+// slash separated paths relative to the directory.
+type watcher struct {
+	bucket string
+	dir    string
+
+	// Objects as of the last reported events.
+	objects map[string]objectInfo
+	// Objects as of the last poll, changes are only reported once they have
+	// been stable for a poll so files being written are not seen half done.
+	last map[string]objectInfo
+}
+
+// Objects that already exist do not cause events.
+func newWatcher(bucket string, dir string) (*watcher, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("Bucket %s: %s is not a directory.", bucket, dir)
+	}
+
+	w := &watcher{bucket: bucket, dir: dir}
+	if w.objects, err = w.scan(); err != nil {
+		return nil, err
+	}
+	w.last = w.objects
+	return w, nil
+}
+
+func (w *watcher) scan() (map[string]objectInfo, error) {
+	objects := make(map[string]objectInfo)
+	err := filepath.Walk(w.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear while we walk.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// Hidden files are usually temporary files of editors and tools.
+		if strings.HasPrefix(fi.Name(), ".") && path != w.dir {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			return err
+		}
+		objects[filepath.ToSlash(rel)] = objectInfo{fi.Size(), fi.ModTime()}
+		return nil
+	})
+	return objects, err
+}
+
+func (w *watcher) poll() ([]*Record, error) {
+	current, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	var records []*Record
+	for key, info := range current {
+		if reported, ok := w.objects[key]; ok && reported == info {
+			continue
+		}
+		if last, ok := w.last[key]; !ok || last != info {
+			continue
+		}
+
+		eTag, err := w.eTag(key)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		records = append(records, NewRecord(EventObjectCreatedPut, w.bucket, key, info.size, eTag, now))
+		w.objects[key] = info
+	}
+
+	for key := range w.objects {
+		if _, ok := current[key]; !ok {
+			records = append(records, NewRecord(EventObjectRemovedDelete, w.bucket, key, 0, "", now))
+			delete(w.objects, key)
+		}
+	}
+	w.last = current
+
+	sort.Sort(byKey(records))
+	return records, nil
+}
+
+func (w *watcher) eTag(key string) (string, error) {
+	f, err := os.Open(filepath.Join(w.dir, filepath.FromSlash(key)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type byKey []*Record
+
+func (s byKey) Len() int           { return len(s) }
+func (s byKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byKey) Less(i, j int) bool { return s[i].S3.Object.Key < s[j].S3.Object.Key }
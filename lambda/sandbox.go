@@ -0,0 +1,204 @@
+package lambda
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// The limits of the sandbox, those of Lambda.
+const (
+	SandboxTmpSize   = 512 // In MB.
+	SandboxPidsLimit = 1024
+	SandboxUser      = "1000:1000"
+)
+
+const (
+	sandboxTmpDir = "/tmp"
+	// Longer lines of output are checked for violations in pieces.
+	maxViolationLine = 1024
+)
+
+// Returned when a function failed after running into a limit of the sandbox.
+type SandboxError struct {
+	// How the function failed, usually an *ExitError.
+	Err error
+	// What the function did that the sandbox does not allow.
+	Violation string
+	// The line of output the violation was found in.
+	Output string
+}
+
+func (e *SandboxError) Error() string {
+	return fmt.Sprintf("%s: Sandbox violation: %s Output: %s", e.Err, e.Violation, e.Output)
+}
+
+// The errors functions get when they run into a limit of the sandbox, as
+// the runtimes print them, lower case. Errors as likely to come from
+// elsewhere, like EACCES or EPERM, are not guessed at.
+const readOnlyError = "read-only file system"
+const noSpaceError = "no space left on device"
+
+// Failing to start processes or threads has other causes too, so these only
+// count when the container is at its pids limit.
+var pidsErrors = []string{"resource temporarily unavailable", "eagain", "unable to create new native thread"}
+
+var (
+	readOnlyViolation = "The function wrote outside /tmp, the only writable directory."
+	noSpaceViolation  = fmt.Sprintf("The function wrote more than %dMB to /tmp.", SandboxTmpSize)
+	pidsViolation     = fmt.Sprintf("The function started more than %d processes and threads.", SandboxPidsLimit)
+)
+
+// The first absolute path in a line of output, like '/app/out.txt' in
+// "EROFS: read-only file system, open '/app/out.txt'".
+var outputPath = regexp.MustCompile(`(?:^|[\s'"(=:])(/[^/\s'"():,][^\s'"():,]*)`)
+
+// Makes the container as restricted as a Lambda function: everything but a
+// size limited /tmp is read-only, it runs as an unprivileged user without
+// Linux capabilities or a way to gain privileges, and with limited processes.
+func applySandbox(config *docker.Config, hostConfig *docker.HostConfig) {
+	config.User = SandboxUser
+	hostConfig.ReadonlyRootfs = true
+	hostConfig.Tmpfs = map[string]string{sandboxTmpDir: fmt.Sprintf("rw,nosuid,nodev,mode=1777,size=%dm", SandboxTmpSize)}
+	hostConfig.CapDrop = []string{"ALL"}
+	hostConfig.SecurityOpt = []string{"no-new-privileges"}
+	hostConfig.PidsLimit = SandboxPidsLimit
+}
+
+// Looks for sandbox violations in the output of an invocation, so a failed
+// invocation can say why it failed. Only the first is kept.
+type violationWatcher struct {
+	streams []*violationStream
+	mounts  []Mount
+
+	mu        sync.Mutex
+	violation string
+	output    string
+	// The first line that may be a pids violation.
+	pidsOutput string
+}
+
+// Returns `stdout` and `stderr` also written to a new watcher if `opts` runs
+// in a sandbox, and nil for the watcher otherwise.
+func watchViolations(opts RunOptions, stdout, stderr io.Writer) (io.Writer, io.Writer, *violationWatcher) {
+	if !opts.Sandbox {
+		return stdout, stderr, nil
+	}
+	w := &violationWatcher{mounts: opts.Mounts}
+	// Separate line buffers, so the streams' lines do not mix.
+	w.streams = []*violationStream{{w: w}, {w: w}}
+	return io.MultiWriter(stdout, w.streams[0]), io.MultiWriter(stderr, w.streams[1]), w
+}
+
+// Wraps `err` in a SandboxError if the function failed and a violation was
+// found. `pids` is the most processes and threads the container was seen
+// running. Must be called once all output has been written.
+func (w *violationWatcher) wrap(err error, pids uint64) error {
+	if w == nil {
+		return err
+	}
+	if _, ok := err.(*ExitError); !ok {
+		return err
+	}
+
+	// The last lines may not end with a newline.
+	for _, s := range w.streams {
+		if len(s.line) > 0 {
+			w.check(string(s.line))
+			s.line = s.line[:0]
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.violation == "" && w.pidsOutput != "" && pids >= SandboxPidsLimit {
+		w.violation, w.output = pidsViolation, w.pidsOutput
+	}
+	if w.violation == "" {
+		return err
+	}
+	return &SandboxError{Err: err, Violation: w.violation, Output: w.output}
+}
+
+func (w *violationWatcher) check(line string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.violation != "" {
+		return
+	}
+
+	lower := strings.ToLower(line)
+	p := ""
+	if m := outputPath.FindStringSubmatch(line); m != nil {
+		p = path.Clean(m[1])
+	}
+	switch {
+	case strings.Contains(lower, readOnlyError):
+		// Read-only mounts are the caller's, not the sandbox's.
+		if p != "" && !within(p, sandboxTmpDir) && !w.onMount(p) {
+			w.violation, w.output = readOnlyViolation, strings.TrimSpace(line)
+		}
+	case strings.Contains(lower, noSpaceError):
+		// Failed writes often do not say where, which can only be /tmp if
+		// no mount is writable.
+		if p == "" && !w.writableMount() || p != "" && within(p, sandboxTmpDir) && !w.onMount(p) {
+			w.violation, w.output = noSpaceViolation, strings.TrimSpace(line)
+		}
+	case w.pidsOutput == "":
+		for _, e := range pidsErrors {
+			if strings.Contains(lower, e) {
+				w.pidsOutput = strings.TrimSpace(line)
+				return
+			}
+		}
+	}
+}
+
+func (w *violationWatcher) onMount(p string) bool {
+	for _, m := range w.mounts {
+		if within(p, path.Clean(m.Target)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *violationWatcher) writableMount() bool {
+	for _, m := range w.mounts {
+		if !m.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+type violationStream struct {
+	w    *violationWatcher
+	line []byte
+}
+
+func (s *violationStream) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.line = append(s.line, p...)
+			break
+		}
+		s.line = append(s.line, p[:i]...)
+		s.w.check(string(s.line))
+		s.line = s.line[:0]
+		p = p[i+1:]
+	}
+	if len(s.line) > maxViolationLine {
+		s.w.check(string(s.line))
+		s.line = s.line[:0]
+	}
+	return n, nil
+}
@@ -0,0 +1,95 @@
+package lambda
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestApplySandbox(t *testing.T) {
+	config, hostConfig := &docker.Config{}, &docker.HostConfig{}
+	applySandbox(config, hostConfig)
+	if config.User != SandboxUser || !hostConfig.ReadonlyRootfs || hostConfig.PidsLimit != SandboxPidsLimit {
+		t.Fatal("Unexpected sandbox", config, hostConfig)
+	}
+	if !strings.Contains(hostConfig.Tmpfs["/tmp"], "size=512m") {
+		t.Fatal("Expected a 512MB /tmp", hostConfig.Tmpfs)
+	}
+	if len(hostConfig.CapDrop) != 1 || hostConfig.CapDrop[0] != "ALL" || hostConfig.SecurityOpt[0] != "no-new-privileges" {
+		t.Fatal("Expected no capabilities and privileges", hostConfig)
+	}
+
+	opts := RunOptions{Sandbox: true, Payload: PayloadCopy}
+	if err := opts.Validate(); err == nil {
+		t.Fatal("Expected error for copying the payload into a sandbox")
+	}
+}
+
+func TestViolationWatcher(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	sandbox := RunOptions{Sandbox: true}
+	out, errOut, w := watchViolations(sandbox, &stdout, &stderr)
+
+	fmt.Fprint(out, `{"errorMessage": "done"}`)
+	fmt.Fprintln(errOut, "Error: EACCES: permission denied, open '/app/secret.txt'")
+	fmt.Fprint(errOut, "Error: EROFS: read-only ")
+	fmt.Fprint(errOut, "file system, open '/app/out.txt'\n")
+	fmt.Fprintln(errOut, "Error: ENOSPC: no space left on device")
+	if stderr.Len() == 0 || stdout.Len() == 0 {
+		t.Fatal("Expected output to be passed on")
+	}
+
+	// Only failed invocations are violations.
+	if err := w.wrap(ErrorTimeout, 0); err != ErrorTimeout {
+		t.Fatal("Expected timeouts to be kept", err)
+	}
+	err, ok := w.wrap(&ExitError{1}, 0).(*SandboxError)
+	if !ok {
+		t.Fatal("Expected a sandbox error", err)
+	}
+	if !strings.Contains(err.Violation, "outside /tmp") || err.Output != "Error: EROFS: read-only file system, open '/app/out.txt'" {
+		t.Fatal("Unexpected violation", err)
+	}
+	if !strings.HasPrefix(err.Error(), "Container exited with non-zero exit code 1: Sandbox violation:") {
+		t.Fatal("Unexpected message", err.Error())
+	}
+
+	// Errors that are not tied to the sandbox are not guessed at.
+	sandbox.Mounts = []Mount{{Source: "models", Target: "/opt/models", ReadOnly: true}, {Source: "cache", Target: "/mnt/cache"}}
+	_, errOut, w = watchViolations(sandbox, &stdout, &stderr)
+	fmt.Fprintln(errOut, "Error: EACCES: permission denied, open '/app/secret.txt'")
+	fmt.Fprintln(errOut, "PermissionError: [Errno 1] Operation not permitted")
+	fmt.Fprintln(errOut, "OSError: [Errno 30] Read-only file system: '/opt/models/new.bin'")
+	fmt.Fprintln(errOut, "Error: EROFS: read-only file system, open 'out.txt'")
+	fmt.Fprintln(errOut, "Error: ENOSPC: no space left on device, write")
+	fmt.Fprintln(errOut, "OSError: [Errno 28] No space left on device: '/mnt/cache/x'")
+	if err := w.wrap(&ExitError{1}, 0); err.Error() != (&ExitError{1}).Error() {
+		t.Fatal("Expected no violation", err)
+	}
+
+	// /tmp filling up, even with a writable mount.
+	_, errOut, w = watchViolations(sandbox, &stdout, &stderr)
+	fmt.Fprintln(errOut, "OSError: [Errno 28] No space left on device: '/tmp/big.bin'")
+	if err, ok := w.wrap(&ExitError{1}, 0).(*SandboxError); !ok || !strings.Contains(err.Violation, "512MB") {
+		t.Fatal("Expected /tmp violation", err)
+	}
+
+	// EAGAIN is only a violation at the pids limit. The last line is
+	// checked without a newline.
+	_, errOut, w = watchViolations(RunOptions{Sandbox: true}, &stdout, &stderr)
+	fmt.Fprint(errOut, "OSError: [Errno 11] Resource temporarily unavailable")
+	if err := w.wrap(&ExitError{1}, 12); err.Error() != (&ExitError{1}).Error() {
+		t.Fatal("Expected no violation below the pids limit", err)
+	}
+	if err, ok := w.wrap(&ExitError{1}, SandboxPidsLimit).(*SandboxError); !ok || !strings.Contains(err.Violation, "processes") {
+		t.Fatal("Expected pids violation", err)
+	}
+
+	_, _, w = watchViolations(RunOptions{}, &stdout, &stderr)
+	if err := w.wrap(&ExitError{1}, SandboxPidsLimit); err.Error() != (&ExitError{1}).Error() {
+		t.Fatal("Expected no watcher without sandbox", err)
+	}
+}
@@ -0,0 +1,245 @@
+// Package schedule invokes functions periodically, the way CloudWatch Events
+// rules with schedule expressions do.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When a rule runs next.
+type Schedule interface {
+	// Returns the first run time after t, or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// Parses a CloudWatch Events schedule expression, either
+// `rate(5 minutes)` or `cron(0 12 * * ? *)`. Cron expressions are in UTC.
+func Parse(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case strings.HasPrefix(expr, "rate(") && strings.HasSuffix(expr, ")"):
+		return parseRate(expr[len("rate(") : len(expr)-1])
+	case strings.HasPrefix(expr, "cron(") && strings.HasSuffix(expr, ")"):
+		return parseCron(expr[len("cron(") : len(expr)-1])
+	}
+	return nil, fmt.Errorf("Invalid schedule expression %q, expected rate(...) or cron(...).", expr)
+}
+
+type rateSchedule time.Duration
+
+func (r rateSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(r))
+}
+
+func parseRate(s string) (Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Invalid rate %q, expected a value and a unit.", s)
+	}
+
+	value, err := strconv.Atoi(fields[0])
+	if err != nil || value <= 0 {
+		return nil, fmt.Errorf("Invalid rate value %q, expected a positive number.", fields[0])
+	}
+
+	// Like CloudWatch Events, a value of 1 needs the singular unit and
+	// anything else the plural.
+	unit := fields[1]
+	if value != 1 {
+		if !strings.HasSuffix(unit, "s") {
+			return nil, fmt.Errorf("Invalid rate unit %q, use the plural for values other than 1.", unit)
+		}
+		unit = strings.TrimSuffix(unit, "s")
+	} else if strings.HasSuffix(unit, "s") {
+		return nil, fmt.Errorf("Invalid rate unit %q, use the singular for a value of 1.", unit)
+	}
+
+	var d time.Duration
+	switch unit {
+	case "minute":
+		d = time.Minute
+	case "hour":
+		d = time.Hour
+	case "day":
+		d = 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("Invalid rate unit %q, expected minutes, hours or days.", fields[1])
+	}
+	return rateSchedule(time.Duration(value) * d), nil
+}
+
+// A set of allowed values for one cron field.
+type cronField struct {
+	any    bool // `?`, only for day-of-month and day-of-week.
+	values map[int]bool
+}
+
+func (f *cronField) matches(v int) bool {
+	return f.values[v]
+}
+
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays, years *cronField
+}
+
+// CloudWatch Events supports years up to 2199.
+const (
+	minCronYear = 1970
+	maxCronYear = 2199
+)
+
+var (
+	monthNames   = []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	weekdayNames = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+func parseCron(s string) (Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("Invalid cron expression %q, expected 6 fields: minutes hours day-of-month month day-of-week year.", s)
+	}
+
+	var (
+		c   cronSchedule
+		err error
+	)
+	if c.minutes, err = parseCronField(fields[0], 0, 59, nil, false); err != nil {
+		return nil, err
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23, nil, false); err != nil {
+		return nil, err
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31, nil, true); err != nil {
+		return nil, err
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12, monthNames, false); err != nil {
+		return nil, err
+	}
+	// Days of the week are numbered from 1 for Sunday.
+	if c.weekdays, err = parseCronField(fields[4], 1, 7, weekdayNames, true); err != nil {
+		return nil, err
+	}
+	if c.years, err = parseCronField(fields[5], minCronYear, maxCronYear, nil, false); err != nil {
+		return nil, err
+	}
+
+	if c.days.any == c.weekdays.any {
+		return nil, fmt.Errorf("Invalid cron expression %q, exactly one of day-of-month and day-of-week must be ?.", s)
+	}
+	return &c, nil
+}
+
+func parseCronField(s string, min, max int, names []string, allowAny bool) (*cronField, error) {
+	f := &cronField{values: make(map[int]bool)}
+	if s == "?" {
+		if !allowAny {
+			return nil, fmt.Errorf("Invalid cron field %q, ? is only allowed for day-of-month and day-of-week.", s)
+		}
+		f.any = true
+		return f, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("Invalid step in cron field %q.", s)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			if hi, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return nil, err
+			}
+			if lo > hi {
+				return nil, fmt.Errorf("Invalid range %q in cron field %q.", part, s)
+			}
+		default:
+			var err error
+			if lo, err = parseCronValue(part, min, max, names); err != nil {
+				return nil, err
+			}
+			// `5/15` means every 15 starting at 5.
+			if step > 1 {
+				hi = max
+			} else {
+				hi = lo
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+func parseCronValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		// L, W and # are not supported.
+		return 0, fmt.Errorf("Invalid or unsupported cron value %q.", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("Cron value %d out of range %d-%d.", v, min, max)
+	}
+	return v, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	if c.days.any {
+		return c.weekdays.matches(int(t.Weekday()) + 1)
+	}
+	return c.days.matches(t.Day())
+}
+
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// Move forward one field at a time, resetting the smaller fields whenever
+	// a larger one changes.
+	for t.Year() <= maxCronYear {
+		if !c.years.matches(t.Year()) {
+			t = time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.months.matches(int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.hours.matches(t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.minutes.matches(t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
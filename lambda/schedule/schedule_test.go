@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	valid := map[string]time.Duration{
+		"rate(1 minute)":   time.Minute,
+		"rate(5 minutes)":  5 * time.Minute,
+		"rate(1 hour)":     time.Hour,
+		"rate(2 days)":     48 * time.Hour,
+		" rate(10 hours) ": 10 * time.Hour,
+	}
+	start := time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC)
+	for expr, d := range valid {
+		s, err := Parse(expr)
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if next := s.Next(start); !next.Equal(start.Add(d)) {
+			t.Fatal(expr, "next run", next)
+		}
+	}
+
+	for _, expr := range []string{"rate(1 minutes)", "rate(5 minute)", "rate(0 minutes)", "rate(5 seconds)", "rate(5)", "every 5 minutes"} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatal("Expected error for", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2016, 3, 1, 10, 30, 15, 0, time.UTC) // A Tuesday.
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"cron(0 12 * * ? *)", time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"cron(0/15 * * * ? *)", time.Date(2016, 3, 1, 10, 45, 0, 0, time.UTC)},
+		{"cron(0 8 ? * MON-FRI *)", time.Date(2016, 3, 2, 8, 0, 0, 0, time.UTC)},
+		{"cron(0 8 ? * 1 *)", time.Date(2016, 3, 6, 8, 0, 0, 0, time.UTC)},
+		{"cron(15 10 1 * ? *)", time.Date(2016, 4, 1, 10, 15, 0, 0, time.UTC)},
+		{"cron(0 0 29 FEB ? *)", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"cron(0 18 ? * 2,4 2017)", time.Date(2017, 1, 2, 18, 0, 0, 0, time.UTC)},
+		{"cron(0 0 1 1 ? 2015)", time.Time{}},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Fatal(test.expr, err)
+		}
+		if next := s.Next(start); !next.Equal(test.next) {
+			t.Fatal(test.expr, "expected", test.next, "got", next)
+		}
+	}
+
+	for _, expr := range []string{"cron(0 12 * * * *)", "cron(0 12 ? * ? *)", "cron(0 12 * *)", "cron(60 12 * * ? *)", "cron(0 12 L * ? *)", "cron(0 12 ? * 2#1 *)", "cron(5-1 * * * ? *)"} {
+		if _, err := Parse(expr); err == nil {
+			t.Fatal("Expected error for", expr)
+		}
+	}
+}
+
+func TestMissedRunsAndState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The last run was long enough ago that several runs were missed.
+	stateFile := filepath.Join(dir, "state.json")
+	last := time.Now().Add(-time.Hour).Truncate(time.Second)
+	b, _ := json.Marshal(map[string]time.Time{"every-minute": last})
+	if err := ioutil.WriteFile(stateFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := make(chan string, 10)
+	s, err := New(func(function string, payload string) error {
+		if function != "hello" {
+			t.Error("Unexpected function", function)
+		}
+		payloads <- payload
+		return nil
+	}, []*Rule{{Name: "every-minute", Function: "hello", Expression: "rate(1 minute)"}}, Options{StateFile: stateFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event Event
+	select {
+	case payload := <-payloads:
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a run for the missed schedule")
+	}
+	s.Close()
+
+	if len(payloads) != 0 {
+		t.Fatal("Expected a single run for all missed runs, got", len(payloads)+1)
+	}
+	if event.Source != "aws.events" || event.DetailType != "Scheduled Event" || event.ID == "" {
+		t.Fatal("Unexpected event", event)
+	}
+	if len(event.Resources) != 1 || event.Resources[0] != "arn:aws:events:us-east-1:123456789012:rule/every-minute" {
+		t.Fatal("Unexpected resources", event.Resources)
+	}
+	if event.Time != last.Add(time.Minute).UTC().Format(time.RFC3339) {
+		t.Fatal("Unexpected event time", event.Time)
+	}
+
+	var state map[string]time.Time
+	b, err = ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		t.Fatal(err)
+	}
+	if !state["every-minute"].Equal(last.Add(time.Minute)) {
+		t.Fatal("Expected last run to be saved, got", state)
+	}
+}
+
+func TestNoOverlap(t *testing.T) {
+	release := make(chan struct{})
+	runs := make(chan string, 10)
+	s, err := New(func(function string, payload string) error {
+		runs <- function
+		<-release
+		return nil
+	}, []*Rule{
+		{Name: "exclusive", Function: "a", Expression: "rate(1 day)", NoOverlap: true},
+		{Name: "overlapping", Function: "b", Expression: "rate(1 day)"},
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, r := range s.rules {
+		s.fire(r, now)
+		s.fire(r, now.Add(time.Minute))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		select {
+		case f := <-runs:
+			counts[f]++
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected 3 runs, got", counts)
+		}
+	}
+	close(release)
+	s.Close()
+
+	if counts["a"] != 1 || counts["b"] != 2 || len(runs) != 0 {
+		t.Fatal("Expected overlapping runs only for rules that allow them, got", counts)
+	}
+}
@@ -0,0 +1,271 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+const (
+	DefaultRegion  = "us-east-1"
+	DefaultAccount = "123456789012"
+)
+
+// Runs a function with a scheduled event payload.
+type RunFunc func(function string, payload string) error
+
+// Invokes a function on a schedule, like a CloudWatch Events rule with a
+// single target.
+type Rule struct {
+	Name       string `json:"name"`
+	Function   string `json:"function"`
+	Expression string `json:"expression"` // rate(...) or cron(...)
+	// Skip a run if the previous one has not finished yet.
+	NoOverlap bool `json:"no_overlap"`
+}
+
+type Options struct {
+	// Last run times are kept here so rules continue where they left off
+	// after a restart. If empty, they are not kept.
+	StateFile string
+	// Used in the event and rule ARN. Empty means DefaultRegion and
+	// DefaultAccount.
+	Region  string
+	Account string
+}
+
+// The payload scheduled functions receive, shaped like the aws.events event.
+type Event struct {
+	Version    string            `json:"version"`
+	ID         string            `json:"id"`
+	DetailType string            `json:"detail-type"`
+	Source     string            `json:"source"`
+	Account    string            `json:"account"`
+	Time       string            `json:"time"`
+	Region     string            `json:"region"`
+	Resources  []string          `json:"resources"`
+	Detail     map[string]string `json:"detail"`
+}
+
+type rule struct {
+	*Rule
+	schedule Schedule
+	running  bool
+}
+
+type Scheduler struct {
+	run   RunFunc
+	opts  Options
+	rules []*rule
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time
+	closed  bool
+	done    chan struct{}
+	loops   sync.WaitGroup
+	runs    sync.WaitGroup
+
+	// Serializes writes to the state file.
+	saveMu sync.Mutex
+}
+
+// Parses the rules and starts running them.
+func New(run RunFunc, rules []*Rule, opts Options) (*Scheduler, error) {
+	if opts.Region == "" {
+		opts.Region = DefaultRegion
+	}
+	if opts.Account == "" {
+		opts.Account = DefaultAccount
+	}
+
+	s := &Scheduler{
+		run:     run,
+		opts:    opts,
+		lastRun: make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if r.Name == "" || r.Function == "" {
+			return nil, errors.New("Every schedule needs a name and a function.")
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("Duplicate schedule name %s.", r.Name)
+		}
+		names[r.Name] = true
+
+		schedule, err := Parse(r.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", r.Name, err)
+		}
+		s.rules = append(s.rules, &rule{Rule: r, schedule: schedule})
+	}
+
+	if err := s.loadState(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, r := range s.rules {
+		last, ok := s.lastRun[r.Name]
+		if !ok {
+			last = now
+		}
+		s.loops.Add(1)
+		go s.loop(r, last)
+	}
+	return s, nil
+}
+
+// Stops scheduling runs and waits for running ones to finish.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.loops.Wait()
+	s.runs.Wait()
+}
+
+func (s *Scheduler) loop(r *rule, last time.Time) {
+	defer s.loops.Done()
+
+	next := r.schedule.Next(last)
+	for !next.IsZero() {
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-timer.C:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+
+		s.fire(r, next)
+
+		// Runs missed while we were stopped are made up for by a single run,
+		// not one for every missed time.
+		now := time.Now()
+		if next = r.schedule.Next(next); !next.IsZero() && next.Before(now) {
+			next = r.schedule.Next(now)
+		}
+	}
+}
+
+func (s *Scheduler) fire(r *rule, scheduled time.Time) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if r.NoOverlap && r.running {
+		s.mu.Unlock()
+		log.Printf("Schedule %s: previous run of %s has not finished, skipping run at %s", r.Name, r.Function, scheduled.UTC().Format(time.RFC3339))
+		return
+	}
+	r.running = true
+	s.lastRun[r.Name] = scheduled
+	s.runs.Add(1)
+	s.mu.Unlock()
+
+	if err := s.saveState(); err != nil {
+		log.Printf("Schedule %s: error saving state: %s", r.Name, err)
+	}
+
+	payload, err := json.Marshal(s.event(r, scheduled))
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		defer s.runs.Done()
+		if err := s.run(r.Function, string(payload)); err != nil {
+			log.Printf("Schedule %s: %s failed: %s", r.Name, r.Function, err)
+		}
+
+		s.mu.Lock()
+		r.running = false
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Scheduler) event(r *rule, scheduled time.Time) *Event {
+	return NewEvent(r.Name, s.opts.Region, s.opts.Account, scheduled)
+}
+
+// Creates the event for a run of rule `name` scheduled at `t`.
+func NewEvent(name string, region string, account string, t time.Time) *Event {
+	return &Event{
+		Version:    "0",
+		ID:         uuid.NewV4().String(),
+		DetailType: "Scheduled Event",
+		Source:     "aws.events",
+		Account:    account,
+		Time:       t.UTC().Format(time.RFC3339),
+		Region:     region,
+		Resources:  []string{fmt.Sprintf("arn:aws:events:%s:%s:rule/%s", region, account, name)},
+		Detail:     map[string]string{},
+	}
+}
+
+func (s *Scheduler) loadState() error {
+	if s.opts.StateFile == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.opts.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &s.lastRun); err != nil {
+		return fmt.Errorf("Error parsing %s: %s", s.opts.StateFile, err)
+	}
+	return nil
+}
+
+func (s *Scheduler) saveState() error {
+	if s.opts.StateFile == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s.lastRun, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves partial state.
+	tmp, err := ioutil.TempFile(filepath.Dir(s.opts.StateFile), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.opts.StateFile)
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/iron-io/lambda/lambda/envcrypt"
+)
+
+// Keeps secrets in a JSON file, each encrypted with envcrypt for its name
+// using a key file. The key file is created with the first secret.
+type FileStore struct {
+	path    string
+	keyFile string
+
+	mu sync.Mutex
+}
+
+func NewFileStore(path string, keyFile string) (*FileStore, error) {
+	if path == "" || keyFile == "" {
+		return nil, errors.New("A secret store needs a file and a key file.")
+	}
+	return &FileStore{path: path, keyFile: keyFile}, nil
+}
+
+func (s *FileStore) key(create bool) (*envcrypt.KeyFile, error) {
+	if create {
+		if _, err := os.Stat(s.keyFile); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(s.keyFile), 0700); err != nil {
+				return nil, err
+			}
+			if err := envcrypt.GenerateKeyFile(s.keyFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return envcrypt.NewKeyFile(s.keyFile)
+}
+
+func (s *FileStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (s *FileStore) Get(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	v, ok := secrets[name]
+	if !ok {
+		return "", ErrorNotFound
+	}
+
+	key, err := s.key(false)
+	if err != nil {
+		return "", err
+	}
+	return envcrypt.DecryptValue(key, name, v)
+}
+
+// Adds or replaces secret `name`.
+func (s *FileStore) Put(name string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, err := s.key(true)
+	if err != nil {
+		return err
+	}
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[name], err = envcrypt.EncryptValue(key, name, value)
+	if err != nil {
+		return err
+	}
+	return s.save(secrets)
+}
+
+func (s *FileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return ErrorNotFound
+	}
+	delete(secrets, name)
+	return s.save(secrets)
+}
+
+// The names of all secrets, sorted.
+func (s *FileStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *FileStore) save(secrets map[string]string) error {
+	b, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,140 @@
+// Package secrets resolves references to secrets in function environment
+// variables. A value like `secret:db/password` or `ssm:/prod/api-key` is
+// replaced with the secret when the container's environment is prepared, so
+// configurations only hold the reference.
+//
+// References are resolved by the provider of their scheme, a local encrypted
+// FileStore for `secret:` and an SSM-compatible endpoint for `ssm:`.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultCacheTTL = 5 * time.Minute
+
+// Returned by providers for secrets that do not exist.
+var ErrorNotFound = errors.New("Secret not found.")
+
+// Looks up secrets by name.
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+type Options struct {
+	// Providers by reference scheme, without the colon. Nil means `secret`
+	// resolves with DefaultFileStore.
+	Providers map[string]Provider
+	// How long resolved secrets are kept. Zero means DefaultCacheTTL,
+	// negative means they are not cached.
+	CacheTTL time.Duration
+}
+
+type Resolver struct {
+	providers map[string]Provider
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cached
+}
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+func NewResolver(opts Options) (*Resolver, error) {
+	if opts.Providers == nil {
+		store, err := DefaultFileStore()
+		if err != nil {
+			return nil, err
+		}
+		opts.Providers = map[string]Provider{"secret": store}
+	}
+	if opts.CacheTTL == 0 {
+		opts.CacheTTL = DefaultCacheTTL
+	}
+
+	return &Resolver{
+		providers: opts.Providers,
+		ttl:       opts.CacheTTL,
+		cache:     make(map[string]*cached),
+	}, nil
+}
+
+// The store in ~/.iron-lambda, see NewFileStore.
+func DefaultFileStore() (*FileStore, error) {
+	dir := filepath.Join(os.Getenv("HOME"), ".iron-lambda")
+	return NewFileStore(filepath.Join(dir, "secrets.json"), filepath.Join(dir, "secrets.key"))
+}
+
+var schemes = []string{"secret", "ssm"}
+
+// Whether `value` refers to a secret.
+func IsReference(value string) bool {
+	_, _, ok := parseReference(value)
+	return ok
+}
+
+func parseReference(value string) (scheme string, name string, ok bool) {
+	for _, s := range schemes {
+		if strings.HasPrefix(value, s+":") {
+			return s, strings.TrimPrefix(value, s+":"), true
+		}
+	}
+	return "", "", false
+}
+
+// Returns the secret `value` refers to, or `value` if it is not a reference.
+func (r *Resolver) Resolve(value string) (string, error) {
+	scheme, name, ok := parseReference(value)
+	if !ok {
+		return value, nil
+	}
+	if name == "" {
+		return "", fmt.Errorf("Secret reference %s has no name.", value)
+	}
+
+	r.mu.Lock()
+	c, ok := r.cache[value]
+	r.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.value, nil
+	}
+
+	p, ok := r.providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("Could not resolve %s: No secret provider for %s: references is configured.", value, scheme)
+	}
+	secret, err := p.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("Could not resolve %s: %s", value, err)
+	}
+
+	if r.ttl > 0 {
+		r.mu.Lock()
+		r.cache[value] = &cached{value: secret, expires: time.Now().Add(r.ttl)}
+		r.mu.Unlock()
+	}
+	return secret, nil
+}
+
+// Resolves the values of `env` that refer to secrets. The error names the
+// variable that could not be resolved.
+func (r *Resolver) ResolveEnv(env map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	for name, v := range env {
+		secret, err := r.Resolve(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		resolved[name] = secret
+	}
+	return resolved, nil
+}
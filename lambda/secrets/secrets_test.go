@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type countingProvider struct {
+	secrets map[string]string
+	calls   int
+}
+
+func (p *countingProvider) Get(name string) (string, error) {
+	p.calls++
+	v, ok := p.secrets[name]
+	if !ok {
+		return "", ErrorNotFound
+	}
+	return v, nil
+}
+
+func TestResolver(t *testing.T) {
+	p := &countingProvider{secrets: map[string]string{"db/password": "hunter2"}}
+	r, err := NewResolver(Options{Providers: map[string]Provider{"secret": p}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"CONFIG_PASSWORD": "secret:db/password", "CONFIG_USER": "root"}
+	for i := 0; i < 2; i++ {
+		resolved, err := r.ResolveEnv(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved["CONFIG_PASSWORD"] != "hunter2" || resolved["CONFIG_USER"] != "root" {
+			t.Fatal("Unexpected environment", resolved)
+		}
+	}
+	if p.calls != 1 {
+		t.Fatal("Expected secret to be cached, provider was called", p.calls, "times")
+	}
+
+	_, err = r.ResolveEnv(map[string]string{"CONFIG_KEY": "secret:missing"})
+	if err == nil || !strings.Contains(err.Error(), "CONFIG_KEY") || !strings.Contains(err.Error(), "secret:missing") {
+		t.Fatal("Expected error naming the variable and reference", err)
+	}
+
+	_, err = r.Resolve("ssm:/prod/api-key")
+	if err == nil || !strings.Contains(err.Error(), "No secret provider for ssm") {
+		t.Fatal("Expected error for unconfigured provider", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(filepath.Join(dir, "secrets.json"), filepath.Join(dir, "secrets.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("db/password"); err != ErrorNotFound {
+		t.Fatal("Expected ErrorNotFound from empty store, got", err)
+	}
+
+	if err := s.Put("db/password", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "secrets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "hunter2") {
+		t.Fatal("Expected secrets to be encrypted on disk", string(b))
+	}
+
+	v, err := s.Get("db/password")
+	if err != nil || v != "hunter2" {
+		t.Fatal("Unexpected secret", v, err)
+	}
+	names, err := s.List()
+	if err != nil || len(names) != 1 || names[0] != "db/password" {
+		t.Fatal("Unexpected secrets", names, err)
+	}
+
+	if err := s.Delete("db/password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("db/password"); err != ErrorNotFound {
+		t.Fatal("Expected ErrorNotFound after delete, got", err)
+	}
+}
+
+func TestSSM(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" {
+			t.Error("Unexpected target", r.Header.Get("X-Amz-Target"))
+		}
+		var req getParameterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		if !req.WithDecryption {
+			t.Error("Expected SecureString parameters to be decrypted")
+		}
+		if req.Name != "/prod/api-key" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ParameterNotFound", "message": ""}`))
+			return
+		}
+		w.Write([]byte(`{"Parameter": {"Name": "/prod/api-key", "Type": "SecureString", "Value": "abc123"}}`))
+	}))
+	defer srv.Close()
+
+	ssm, err := NewSSM(SSMOptions{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver(Options{Providers: map[string]Provider{"ssm": ssm}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Resolve("ssm:/prod/api-key")
+	if err != nil || v != "abc123" {
+		t.Fatal("Unexpected parameter", v, err)
+	}
+	if _, err := r.Resolve("ssm:/prod/missing"); err == nil || !strings.Contains(err.Error(), ErrorNotFound.Error()) {
+		t.Fatal("Expected not found error", err)
+	}
+}
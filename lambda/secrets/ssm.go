@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"fmt"
+
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+type SSMOptions signer.JSONOptions
+
+// Gets parameters from an SSM-compatible Parameter Store, decrypting
+// SecureString parameters.
+type SSM struct {
+	client *signer.JSONClient
+}
+
+func NewSSM(opts SSMOptions) (*SSM, error) {
+	client, err := signer.NewJSONClient("ssm", "AmazonSSM", signer.JSONOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &SSM{client: client}, nil
+}
+
+type getParameterRequest struct {
+	Name           string `json:"Name"`
+	WithDecryption bool   `json:"WithDecryption"`
+}
+
+type getParameterResponse struct {
+	Parameter struct {
+		Value string `json:"Value"`
+	} `json:"Parameter"`
+}
+
+func (s *SSM) Get(name string) (string, error) {
+	var resp getParameterResponse
+	err := s.client.Do("GetParameter", &getParameterRequest{Name: name, WithDecryption: true}, &resp)
+	if e, ok := err.(*signer.ServiceError); ok {
+		if e.Type == "ParameterNotFound" {
+			return "", ErrorNotFound
+		}
+		return "", fmt.Errorf("SSM %s", e)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.Parameter.Value, nil
+}
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/iron-io/lambda/lambda/apigateway"
+	"github.com/iron-io/lambda/lambda/async"
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/logs"
+	"github.com/iron-io/lambda/lambda/s3events"
+	"github.com/iron-io/lambda/lambda/schedule"
+	"github.com/iron-io/lambda/lambda/secrets"
+	"github.com/iron-io/lambda/lambda/signer"
+	"github.com/iron-io/lambda/lambda/sqsevents"
+	"github.com/iron-io/lambda/lambda/trace"
+)
+
+// A function that can be invoked by name, backed by a Docker image created
+// with lambda.CreateImage.
+type Function struct {
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	MemorySize int64  `json:"memory_size"` // In MB.
+	Timeout    int    `json:"timeout"`     // In seconds.
+
+	// Number of started containers to keep ready. Functions with warm
+	// containers reuse containers between invocations, which needs the
+	// nodejs or python base image. Zero runs a new container per invocation.
+	WarmContainers int `json:"warm_containers"`
+
+	// Concurrent invocations guaranteed to, and allowed for, this function.
+	// Zero means the function shares the unreserved concurrency.
+	ReservedConcurrency int `json:"reserved_concurrency"`
+
+	// HTTP routes served by the function through the API Gateway proxy
+	// integration, see Server.APIGateway.
+	Routes []*apigateway.Route `json:"routes"`
+
+	// AWS credentials passed to the function, see lambda.CredentialsOptions.
+	// Empty means the server's environment.
+	Profile    string `json:"profile"`
+	RoleARN    string `json:"role_arn"`
+	ExternalID string `json:"external_id"`
+
+	// Values can be encrypted with the key of Config.Encryption, see the
+	// envcrypt package, or refer to secrets, see Config.Secrets.
+	Environment map[string]string `json:"environment"`
+
+	// The network of the function, see lambda.NetworkOptions. Empty means
+	// Docker's default bridge, "none" no network, anything else is the name
+	// of a Docker network.
+	Network     string   `json:"network"`
+	DNS         []string `json:"dns"`
+	DNSSearch   []string `json:"dns_search"`
+	EgressAllow []string `json:"egress_allow"`
+
+	// Runs the function as restricted as Lambda does, see
+	// lambda.RunOptions.Sandbox.
+	Sandbox bool `json:"sandbox"`
+
+	Mounts []MountConfig `json:"mounts"`
+
+	// Vends the credentials above if set, see Config.CredentialsURL.
+	credentials *lambda.CredentialsServer
+	envKey      envcrypt.Key
+	secrets     *secrets.Resolver
+	logSink     logs.Sink
+	tracer      trace.Exporter
+}
+
+// A Docker volume, or a directory of the Docker host if source is an absolute
+// path, mounted at target, see lambda.Mount.
+type MountConfig struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// There is no Limiter, the server limits invocations by function name itself.
+func (f *Function) runOptions() lambda.RunOptions {
+	var mounts []lambda.Mount
+	for _, m := range f.Mounts {
+		mounts = append(mounts, lambda.Mount{Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly})
+	}
+
+	return lambda.RunOptions{
+		MemorySize: f.MemorySize,
+		Timeout:    time.Duration(f.Timeout) * time.Second,
+		Credentials: lambda.CredentialsOptions{
+			Profile:    f.Profile,
+			RoleARN:    f.RoleARN,
+			ExternalID: f.ExternalID,
+		},
+		CredentialsServer: f.credentials,
+		Environment:       f.Environment,
+		EnvKey:            f.envKey,
+		Secrets:           f.secrets,
+		LogSink:           f.logSink,
+		Tracer:            f.tracer,
+		Sandbox:           f.Sandbox,
+		Mounts:            mounts,
+		Network: lambda.NetworkOptions{
+			Mode:        f.Network,
+			DNS:         f.DNS,
+			DNSSearch:   f.DNSSearch,
+			EgressAllow: f.EgressAllow,
+		},
+	}
+}
+
+// The key encrypted environment variables are decrypted with, a key file or
+// KMS.
+type EncryptionConfig struct {
+	KeyFile string `json:"key_file"`
+
+	KMSKeyID    string `json:"kms_key_id"`
+	KMSRegion   string `json:"kms_region"`
+	KMSEndpoint string `json:"kms_endpoint"`
+}
+
+func (c EncryptionConfig) key() (envcrypt.Key, error) {
+	if c.KeyFile != "" && (c.KMSKeyID != "" || c.KMSEndpoint != "") {
+		return nil, errors.New("Encryption takes either a key file or KMS.")
+	}
+	if c.KeyFile != "" {
+		return envcrypt.NewKeyFile(c.KeyFile)
+	}
+	if c.KMSKeyID != "" || c.KMSEndpoint != "" || c.KMSRegion != "" {
+		return envcrypt.NewKMS(envcrypt.KMSOptions{
+			KeyID:       c.KMSKeyID,
+			JSONOptions: signer.JSONOptions{Region: c.KMSRegion, Endpoint: c.KMSEndpoint},
+		})
+	}
+	return nil, nil
+}
+
+// Where the secrets that environment variables refer to come from. `secret:`
+// references are looked up in a local encrypted store, `ssm:` references in
+// an SSM-compatible Parameter Store if one is configured.
+type SecretsConfig struct {
+	// Empty means ~/.iron-lambda/secrets.json and secrets.key.
+	StoreFile string `json:"store_file"`
+	KeyFile   string `json:"key_file"`
+
+	SSMRegion   string `json:"ssm_region"`
+	SSMEndpoint string `json:"ssm_endpoint"`
+
+	// Seconds resolved secrets are cached. Zero means 5 minutes, negative
+	// means they are not.
+	CacheTTL int `json:"cache_ttl"`
+}
+
+func (c SecretsConfig) resolver() (*secrets.Resolver, error) {
+	var store *secrets.FileStore
+	var err error
+	if c.StoreFile == "" && c.KeyFile == "" {
+		store, err = secrets.DefaultFileStore()
+	} else {
+		store, err = secrets.NewFileStore(c.StoreFile, c.KeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	providers := map[string]secrets.Provider{"secret": store}
+
+	if c.SSMRegion != "" || c.SSMEndpoint != "" {
+		ssm, err := secrets.NewSSM(secrets.SSMOptions{Region: c.SSMRegion, Endpoint: c.SSMEndpoint})
+		if err != nil {
+			return nil, err
+		}
+		providers["ssm"] = ssm
+	}
+
+	return secrets.NewResolver(secrets.Options{
+		Providers: providers,
+		CacheTTL:  time.Duration(c.CacheTTL) * time.Second,
+	})
+}
+
+// Where the output of invocations is shipped as records, see the logs
+// package. Any number of sinks can be set.
+type LogsConfig struct {
+	// JSON-lines file, rotated after max_size MB keeping max_files old ones.
+	File     string `json:"file"`
+	MaxSize  int64  `json:"max_size"`
+	MaxFiles int    `json:"max_files"`
+
+	// "local" for the local syslog daemon, or udp://host:port or
+	// tcp://host:port.
+	Syslog string `json:"syslog"`
+
+	// host:port of a server taking JSON lines over TCP.
+	TCP string `json:"tcp"`
+}
+
+func (c LogsConfig) sink() (logs.Sink, error) {
+	var sinks []logs.Sink
+	closeAll := func() {
+		logs.Multi(sinks...).Close()
+	}
+
+	if c.File != "" {
+		s, err := logs.NewFileSink(c.File, logs.FileOptions{MaxSize: c.MaxSize * 1024 * 1024, MaxFiles: c.MaxFiles})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if c.Syslog != "" {
+		var network, addr string
+		if c.Syslog != "local" {
+			parts := strings.SplitN(c.Syslog, "://", 2)
+			if len(parts) != 2 || (parts[0] != "udp" && parts[0] != "tcp") {
+				closeAll()
+				return nil, fmt.Errorf("Invalid syslog address %s.", c.Syslog)
+			}
+			network, addr = parts[0], parts[1]
+		}
+		s, err := logs.NewSyslogSink(network, addr, "lambda")
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if c.TCP != "" {
+		s, err := logs.NewTCPSink(c.TCP)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sinks[0], nil
+	}
+	return logs.Multi(sinks...), nil
+}
+
+// Where spans of invocations are exported, see the trace package.
+type TracingConfig struct {
+	// Base URL of the OTLP/HTTP receiver of an OpenTelemetry collector, like
+	// http://localhost:4318. If empty, traces are only passed on.
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	ServiceName  string `json:"service_name"`
+}
+
+func (c TracingConfig) exporter() (trace.Exporter, error) {
+	if c.OTLPEndpoint == "" {
+		return nil, nil
+	}
+	e, err := trace.NewOTLPExporter(c.OTLPEndpoint, trace.OTLPOptions{ServiceName: c.ServiceName})
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// How Event invocations are run, see the async package.
+type AsyncConfig struct {
+	Workers int `json:"workers"`
+	// Zero means Lambda's two retries, negative means none.
+	MaxRetries int `json:"max_retries"`
+	// Seconds to wait before the first retry, doubled for every following
+	// one.
+	Backoff int `json:"backoff"`
+	// Seconds throttled invocations are tried for before they are kept with
+	// the failed ones. Zero means Lambda's six hours.
+	MaxEventAge int `json:"max_event_age"`
+	// Invocations that failed every attempt are kept here. If empty, they are
+	// only logged.
+	DeadLetterDir string `json:"dead_letter_dir"`
+}
+
+func (c AsyncConfig) options() (async.Options, error) {
+	opts := async.Options{
+		Workers:     c.Workers,
+		MaxRetries:  c.MaxRetries,
+		Backoff:     time.Duration(c.Backoff) * time.Second,
+		MaxEventAge: time.Duration(c.MaxEventAge) * time.Second,
+	}
+
+	if c.DeadLetterDir != "" {
+		store, err := async.NewDeadLetterStore(c.DeadLetterDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.DeadLetters = store
+	}
+	return opts, nil
+}
+
+type Config struct {
+	Functions []*Function `json:"functions"`
+	Async     AsyncConfig `json:"async"`
+
+	// Ceiling on concurrent invocations of all functions. Zero means no
+	// ceiling.
+	MaxConcurrency int `json:"max_concurrency"`
+
+	// Functions invoked on a schedule, like CloudWatch Events rules.
+	Schedules []*schedule.Rule `json:"schedules"`
+	// Last run times of the schedules are kept here across restarts.
+	ScheduleStateFile string `json:"schedule_state_file"`
+
+	// Local buckets whose object notifications invoke functions.
+	Buckets []*s3events.BucketConfig `json:"buckets"`
+
+	// SQS queues whose messages invoke functions, like event source
+	// mappings.
+	Queues []*sqsevents.Mapping `json:"queues"`
+
+	// If set, functions get temporary AWS credentials from a credentials
+	// endpoint that containers reach at this URL, see Server.Credentials.
+	CredentialsURL string `json:"credentials_url"`
+	// Keeps the credentials endpoint's authorization tokens valid across
+	// restarts.
+	CredentialsKeyFile string `json:"credentials_key_file"`
+
+	// Decrypts the encrypted environment variables of functions.
+	Encryption EncryptionConfig `json:"encryption"`
+	// Resolves the environment variables of functions that refer to secrets.
+	Secrets SecretsConfig `json:"secrets"`
+
+	// Ships the output of all invocations.
+	Logs LogsConfig `json:"logs"`
+	// Exports spans of all invocations.
+	Tracing TracingConfig `json:"tracing"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %s", path, err)
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	// Warm containers are pooled by image.
+	warmImages := make(map[string]bool)
+	for _, f := range c.Functions {
+		if f.Name == "" || f.Image == "" {
+			return errors.New("Every function needs a name and an image.")
+		}
+		if names[f.Name] {
+			return fmt.Errorf("Duplicate function name %s.", f.Name)
+		}
+		names[f.Name] = true
+
+		opts := f.runOptions()
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", f.Name, err)
+		}
+		// Kept, so errors report the timeout the function ran with.
+		f.MemorySize = opts.MemorySize
+		f.Timeout = int(opts.Timeout / time.Second)
+
+		if c.Encryption == (EncryptionConfig{}) {
+			for name, v := range f.Environment {
+				if envcrypt.IsEncrypted(v) {
+					return fmt.Errorf("%s: Environment variable %s is encrypted, but no encryption key is configured.", f.Name, name)
+				}
+			}
+		}
+
+		if f.WarmContainers < 0 {
+			return fmt.Errorf("Invalid warm container count %d for %s.", f.WarmContainers, f.Name)
+		}
+		if f.WarmContainers > 0 {
+			if warmImages[f.Image] {
+				return fmt.Errorf("Functions with warm containers can not share image %s.", f.Image)
+			}
+			warmImages[f.Image] = true
+		}
+	}
+
+	for _, r := range c.Schedules {
+		if !names[r.Function] {
+			return fmt.Errorf("Schedule %s refers to unknown function %s.", r.Name, r.Function)
+		}
+	}
+
+	for _, b := range c.Buckets {
+		for _, n := range b.Notifications {
+			if !names[n.Function] {
+				return fmt.Errorf("Notification of bucket %s refers to unknown function %s.", b.Name, n.Function)
+			}
+		}
+	}
+
+	for _, m := range c.Queues {
+		if !names[m.Function] {
+			return fmt.Errorf("Queue %s refers to unknown function %s.", m.QueueURL, m.Function)
+		}
+	}
+
+	if c.Async.Workers < 0 || c.Async.Backoff < 0 || c.Async.MaxEventAge < 0 {
+		return errors.New("Async workers, backoff and maximum event age can not be negative.")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda/async"
+)
+
+// Not part of the Lambda API. Lets failed Event invocations be inspected and
+// replayed:
+//
+//	GET    /dead-letters
+//	GET    /dead-letters/{id}
+//	DELETE /dead-letters/{id}
+//	POST   /dead-letters/{id}/replay
+const deadLettersPath = "/dead-letters"
+
+func (s *Server) serveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if s.letters == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException", "No dead letter directory configured")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, deadLettersPath), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == "GET":
+		letters, err := s.letters.List()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "ServiceException", err.Error())
+			return
+		}
+		writeJSON(w, letters)
+	case len(parts) == 1 && r.Method == "GET":
+		l, err := s.letters.Get(parts[0])
+		if err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+		writeJSON(w, l)
+	case len(parts) == 1 && r.Method == "DELETE":
+		if err := s.letters.Delete(parts[0]); err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "replay" && r.Method == "POST":
+		if err := s.async.Replay(parts[0]); err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, http.StatusNotFound, "UnknownOperationException", "Unknown operation "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	switch err {
+	case async.ErrorNoSuchDeadLetter:
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException", err.Error())
+	case async.ErrorQueueFull:
+		writeError(w, http.StatusTooManyRequests, "TooManyRequestsException", err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "ServiceException", err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,488 @@
+// Package server implements the AWS Lambda Invoke API on top of the lambda
+// package, so AWS SDK clients can call Dockerized functions unchanged.
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/iron-io/lambda/lambda/apigateway"
+	"github.com/iron-io/lambda/lambda/async"
+	"github.com/iron-io/lambda/lambda/logs"
+	"github.com/iron-io/lambda/lambda/metrics"
+	"github.com/iron-io/lambda/lambda/s3events"
+	"github.com/iron-io/lambda/lambda/schedule"
+	"github.com/iron-io/lambda/lambda/sqsevents"
+	"github.com/iron-io/lambda/lambda/trace"
+)
+
+const (
+	invokePathPrefix = "/2015-03-31/functions/"
+	invokePathSuffix = "/invocations"
+
+	// Lambda's payload limits.
+	maxSyncPayload  = 6 * 1024 * 1024
+	maxAsyncPayload = 128 * 1024
+
+	// Only the last 4KB of the log is returned with LogType Tail.
+	maxLogTail = 4 * 1024
+
+	concurrencyPath = "/concurrency"
+	metricsPath     = "/metrics"
+
+	// Where S3-compatible servers post bucket notifications.
+	s3NotificationsPath = "/notifications/s3"
+)
+
+const (
+	InvocationRequestResponse = "RequestResponse"
+	InvocationEvent           = "Event"
+	InvocationDryRun          = "DryRun"
+)
+
+type Server struct {
+	functions map[string]*Function
+	pool      *lambda.Pool
+	limiter   *lambda.Limiter
+	async     *async.Dispatcher
+	letters   *async.DeadLetterStore
+	scheduler *schedule.Scheduler
+	gateway   *apigateway.Handler
+	buckets   *s3events.Trigger
+	queues    *sqsevents.Poller
+	creds     *lambda.CredentialsServer
+	logSink   logs.Sink
+	tracer    trace.Exporter
+
+	// Runs a single invocation, in trace `tc` or a new one if nil. Replaced
+	// in tests.
+	run func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error
+}
+
+func New(config *Config) (*Server, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	asyncOpts, err := config.Async.options()
+	if err != nil {
+		return nil, err
+	}
+
+	envKey, err := config.Encryption.key()
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := config.Secrets.resolver()
+	if err != nil {
+		return nil, err
+	}
+	tracer, err := config.Tracing.exporter()
+	if err != nil {
+		return nil, err
+	}
+	logSink, err := config.Logs.sink()
+	if err != nil {
+		if tracer != nil {
+			tracer.Close()
+		}
+		return nil, err
+	}
+
+	s := &Server{
+		functions: make(map[string]*Function),
+		pool:      lambda.NewPool(lambda.PoolOptions{}),
+		limiter:   lambda.NewLimiter(config.MaxConcurrency),
+		letters:   asyncOpts.DeadLetters,
+		logSink:   logSink,
+		tracer:    tracer,
+	}
+	s.run = s.runFunction
+	s.async = async.NewDispatcher(s.runInvocation, asyncOpts)
+
+	if config.CredentialsURL != "" {
+		s.creds, err = lambda.NewCredentialsServer(config.CredentialsURL, config.CredentialsKeyFile)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	var routes []*apigateway.Route
+	for _, f := range config.Functions {
+		f.credentials = s.creds
+		f.envKey = envKey
+		f.secrets = resolver
+		f.logSink = logSink
+		f.tracer = tracer
+		s.functions[f.Name] = f
+		for _, r := range f.Routes {
+			route := *r
+			route.Function = f.Name
+			routes = append(routes, &route)
+		}
+		if err := s.limiter.Reserve(f.Name, f.ReservedConcurrency); err != nil {
+			s.Close()
+			return nil, err
+		}
+		if f.WarmContainers > 0 {
+			if err := s.pool.Provision(f.Image, f.WarmContainers, f.runOptions()); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+	}
+
+	if len(routes) > 0 {
+		s.gateway, err = apigateway.NewHandler(s.invokeResult, routes)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	// S3 invokes functions asynchronously.
+	s.buckets, err = s3events.NewTrigger(s.enqueue, config.Buckets, s3events.Options{})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Like Lambda, queues invoke functions synchronously and delete the
+	// messages once the function succeeded.
+	s.queues, err = sqsevents.NewPoller(s.invokeQueue, config.Queues, sqsevents.Options{})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	// Scheduled invocations are Event invocations, but run directly rather
+	// than queued so overlapping runs can be detected.
+	if len(config.Schedules) > 0 {
+		s.scheduler, err = schedule.New(s.runAsync, config.Schedules, schedule.Options{StateFile: config.ScheduleStateFile})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Stops the schedules, waits for running Event invocations, removes all warm
+// containers, closes the log sinks and exports the remaining spans.
+func (s *Server) Close() {
+	if s.scheduler != nil {
+		s.scheduler.Close()
+	}
+	if s.buckets != nil {
+		s.buckets.Close()
+	}
+	if s.queues != nil {
+		s.queues.Close()
+	}
+	s.async.Close()
+	s.pool.Close()
+	if s.logSink != nil {
+		s.logSink.Close()
+	}
+	if s.tracer != nil {
+		s.tracer.Close()
+	}
+}
+
+func (s *Server) runAsync(name string, payload string) error {
+	return s.runInvocation(async.Invocation{Function: name, Payload: payload})
+}
+
+// Runs an attempt of an Event invocation. The dispatcher keeps throttled
+// invocations queued and tries them again, only their first throttle counts.
+func (s *Server) runInvocation(inv async.Invocation) error {
+	f, ok := s.functions[inv.Function]
+	if !ok {
+		return fmt.Errorf("Function not found: %s", inv.Function)
+	}
+
+	var tc *trace.Context
+	if inv.Trace != "" {
+		if parsed, err := trace.ParseTraceparent(inv.Trace); err == nil {
+			tc = &parsed
+		}
+	}
+
+	err := s.runLimited(f, inv.Payload, tc, nil, nil)
+	if !inv.Throttled {
+		recordThrottle(f, err)
+	}
+	return err
+}
+
+// Runs `f` in one of its concurrency slots. Invocations are throttled by the
+// function's limits, or by the runner's limit on containers.
+func (s *Server) runLimited(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+	if err := s.limiter.Acquire(f.Name); err != nil {
+		return err
+	}
+	defer s.limiter.Release(f.Name)
+	return s.run(f, payload, tc, stdout, stderr)
+}
+
+// Counts `err` if the invocation of `f` was throttled.
+func recordThrottle(f *Function, err error) {
+	if err == lambda.ErrorThrottled {
+		lambda.RecordThrottle(f.Image)
+	}
+}
+
+func (s *Server) enqueue(name string, payload string) error {
+	_, err := s.async.Enqueue(name, payload)
+	return err
+}
+
+// Runs a RequestResponse invocation for the API Gateway proxy and queues.
+func (s *Server) invokeResult(name string, payload string) ([]byte, error) {
+	f, ok := s.functions[name]
+	if !ok {
+		return nil, fmt.Errorf("Function not found: %s", name)
+	}
+
+	var result bytes.Buffer
+	err := s.runLimited(f, payload, nil, &result, os.Stderr)
+	recordThrottle(f, err)
+	return result.Bytes(), err
+}
+
+func (s *Server) invokeQueue(name string, payload string) error {
+	_, err := s.invokeResult(name, payload)
+	return err
+}
+
+// Serves AWS credentials to function containers. Nil if no credentials URL
+// is configured.
+func (s *Server) Credentials() http.Handler {
+	if s.creds == nil {
+		return nil
+	}
+	return s.creds
+}
+
+// Serves the routes of all functions like an API Gateway with Lambda proxy
+// integrations. Nil if no function has routes.
+func (s *Server) APIGateway() http.Handler {
+	if s.gateway == nil {
+		return nil
+	}
+	return s.gateway
+}
+
+func (s *Server) runFunction(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+	if f.WarmContainers > 0 {
+		return s.pool.RunWithTrace(f.Image, payload, tc, stdout, stderr)
+	}
+
+	opts := f.runOptions()
+	opts.OutputStream = stdout
+	opts.ErrorStream = stderr
+	opts.Trace = tc
+	return lambda.RunImageWithOptions(f.Image, payload, opts)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, deadLettersPath) {
+		s.serveDeadLetters(w, r)
+		return
+	}
+
+	if r.URL.Path == s3NotificationsPath {
+		s.buckets.ServeHTTP(w, r)
+		return
+	}
+
+	// Not part of the Lambda API, reports invocations in flight.
+	if r.URL.Path == concurrencyPath && r.Method == "GET" {
+		writeJSON(w, s.limiter.Stats())
+		return
+	}
+
+	// Invocation metrics in the Prometheus text format.
+	if r.URL.Path == metricsPath && r.Method == "GET" {
+		metrics.Default.ServeHTTP(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, invokePathPrefix) || !strings.HasSuffix(r.URL.Path, invokePathSuffix) {
+		writeError(w, http.StatusNotFound, "UnknownOperationException", "Unknown operation "+r.URL.Path)
+		return
+	}
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "UnknownOperationException", "Invoke requires POST")
+		return
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, invokePathPrefix), invokePathSuffix)
+	s.invoke(w, r, functionName(name))
+}
+
+// Functions can be referred to by name or by ARN, optionally qualified with a
+// version or alias, which we ignore.
+//
+//	arn:aws:lambda:us-east-1:123456789012:function:name:qualifier
+func functionName(name string) string {
+	if strings.HasPrefix(name, "arn:") {
+		parts := strings.Split(name, ":")
+		if len(parts) >= 7 {
+			return parts[6]
+		}
+		return name
+	}
+
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func (s *Server) invoke(w http.ResponseWriter, r *http.Request, name string) {
+	f, ok := s.functions[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException", "Function not found: "+name)
+		return
+	}
+
+	invocationType := r.Header.Get("X-Amz-Invocation-Type")
+	if invocationType == "" {
+		invocationType = InvocationRequestResponse
+	}
+
+	maxPayload := maxSyncPayload
+	switch invocationType {
+	case InvocationRequestResponse, InvocationDryRun:
+	case InvocationEvent:
+		maxPayload = maxAsyncPayload
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", "Invalid invocation type "+invocationType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(maxPayload)+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContentException", err.Error())
+		return
+	}
+	if len(body) > maxPayload {
+		writeError(w, http.StatusRequestEntityTooLarge, "RequestTooLargeException", fmt.Sprintf("Request must be smaller than %d bytes for the %s invocation type", maxPayload, invocationType))
+		return
+	}
+
+	if len(body) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidRequestContentException", "Could not parse request body into json: "+err.Error())
+			return
+		}
+	}
+
+	w.Header().Set("X-Amz-Executed-Version", "$LATEST")
+	switch invocationType {
+	case InvocationDryRun:
+		w.WriteHeader(http.StatusNoContent)
+	case InvocationEvent:
+		tc := requestTrace(w, r)
+		if _, err := s.async.EnqueueWithTrace(f.Name, string(body), &tc); err != nil {
+			writeError(w, http.StatusTooManyRequests, "TooManyRequestsException", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case InvocationRequestResponse:
+		s.invokeSync(w, r, f, string(body))
+	}
+}
+
+func (s *Server) invokeSync(w http.ResponseWriter, r *http.Request, f *Function, payload string) {
+	tc := requestTrace(w, r)
+	var result, logs bytes.Buffer
+	err := s.runLimited(f, payload, &tc, &result, io.MultiWriter(os.Stderr, &logs))
+	recordThrottle(f, err)
+	if err == lambda.ErrorThrottled {
+		writeError(w, http.StatusTooManyRequests, "TooManyRequestsException", err.Error())
+		return
+	}
+	if err != nil && !functionError(err) {
+		// Docker failed to pull, create or start the container, the
+		// function never ran.
+		writeError(w, http.StatusInternalServerError, "ServiceException", err.Error())
+		return
+	}
+
+	if r.Header.Get("X-Amz-Log-Type") == "Tail" {
+		tail := logs.Bytes()
+		if len(tail) > maxLogTail {
+			tail = tail[len(tail)-maxLogTail:]
+		}
+		w.Header().Set("X-Amz-Log-Result", base64.StdEncoding.EncodeToString(tail))
+	}
+
+	body := bytes.TrimSpace(result.Bytes())
+	if err != nil {
+		// Handled errors are ones the function reported itself, like
+		// context.fail(). Anything else, including timeouts, is Unhandled.
+		errorType := "Handled"
+		if err == lambda.ErrorTimeout || len(body) == 0 {
+			errorType = "Unhandled"
+			message := "Process exited before completing request"
+			if err == lambda.ErrorTimeout {
+				message = fmt.Sprintf("Task timed out after %.2f seconds", f.runOptions().Timeout.Seconds())
+			}
+			fields := map[string]string{"errorMessage": message}
+			// Only explains functions that did not report an error
+			// themselves, the violation is a guess from their output.
+			if sandboxErr, ok := err.(*lambda.SandboxError); ok {
+				fields = map[string]string{"errorMessage": sandboxErr.Error(), "errorType": "SandboxViolation"}
+			}
+			body, _ = json.Marshal(fields)
+		}
+		w.Header().Set("X-Amz-Function-Error", errorType)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Continues the caller's trace, and tells it which trace a new one is.
+func requestTrace(w http.ResponseWriter, r *http.Request) trace.Context {
+	tc, ok := trace.FromHeaders(r.Header)
+	if !ok {
+		tc = trace.New()
+	}
+	w.Header().Set(trace.XRayHeader, tc.XRay())
+	return tc
+}
+
+// Whether the function itself failed, as opposed to the runner.
+func functionError(err error) bool {
+	switch err.(type) {
+	case *lambda.ExitError, *lambda.SandboxError:
+		return true
+	}
+	return err == lambda.ErrorTimeout
+}
+
+// Errors are returned the way the AWS SDKs expect for REST-JSON services.
+func writeError(w http.ResponseWriter, status int, errorType string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", errorType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"Type":    "User",
+		"message": message,
+	})
+}
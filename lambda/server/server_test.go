@@ -0,0 +1,364 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/iron-io/lambda/lambda/apigateway"
+	"github.com/iron-io/lambda/lambda/async"
+	"github.com/iron-io/lambda/lambda/s3events"
+	"github.com/iron-io/lambda/lambda/schedule"
+	"github.com/iron-io/lambda/lambda/sqsevents"
+	"github.com/iron-io/lambda/lambda/trace"
+)
+
+func newTestServer(t *testing.T, run func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error) *Server {
+	s, err := New(&Config{Functions: []*Function{{Name: "hello", Image: "test/hello"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.run = run
+	return s
+}
+
+func invoke(s *Server, name string, invocationType string, payload string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", invokePathPrefix+name+invokePathSuffix, strings.NewReader(payload))
+	if invocationType != "" {
+		r.Header.Set("X-Amz-Invocation-Type", invocationType)
+	}
+	r.Header.Set("X-Amz-Log-Type", "Tail")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestInvokeRequestResponse(t *testing.T) {
+	s := newTestServer(t, func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		fmt.Fprintln(stderr, "log line")
+		fmt.Fprintf(stdout, "%s\n", payload)
+		return nil
+	})
+	defer s.Close()
+
+	w := invoke(s, "hello", "", `{"key": "value"}`)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected 200, got", w.Code)
+	}
+	if w.Body.String() != `{"key": "value"}` {
+		t.Fatal("Unexpected result", w.Body.String())
+	}
+	if w.Header().Get("X-Amz-Function-Error") != "" {
+		t.Fatal("Unexpected function error", w.Header().Get("X-Amz-Function-Error"))
+	}
+	tail, _ := base64.StdEncoding.DecodeString(w.Header().Get("X-Amz-Log-Result"))
+	if string(tail) != "log line\n" {
+		t.Fatalf("Unexpected log tail %q", tail)
+	}
+}
+
+func TestInvokeFunctionError(t *testing.T) {
+	s := newTestServer(t, func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		fmt.Fprintln(stdout, `{"errorMessage": "FAIL"}`)
+		return &lambda.ExitError{Code: 1}
+	})
+	defer s.Close()
+
+	w := invoke(s, "hello", InvocationRequestResponse, `{}`)
+	if w.Code != http.StatusOK || w.Header().Get("X-Amz-Function-Error") != "Handled" {
+		t.Fatal("Expected handled function error", w.Code, w.Header())
+	}
+
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		return lambda.ErrorTimeout
+	}
+	w = invoke(s, "hello", InvocationRequestResponse, `{}`)
+	if w.Header().Get("X-Amz-Function-Error") != "Unhandled" || !strings.Contains(w.Body.String(), "Task timed out after 3.00 seconds") {
+		t.Fatal("Expected unhandled timeout error", w.Header(), w.Body.String())
+	}
+
+	// The function never ran.
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		return errors.New("No such image: test/hello")
+	}
+	w = invoke(s, "hello", InvocationRequestResponse, `{}`)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Amzn-ErrorType") != "ServiceException" || w.Header().Get("X-Amz-Function-Error") != "" {
+		t.Fatal("Expected a service error", w.Code, w.Header())
+	}
+
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		return &lambda.SandboxError{Err: &lambda.ExitError{Code: 1}, Violation: "The function wrote outside /tmp.", Output: "EROFS"}
+	}
+	w = invoke(s, "hello", InvocationRequestResponse, `{}`)
+	if w.Header().Get("X-Amz-Function-Error") != "Unhandled" || !strings.Contains(w.Body.String(), `"errorType":"SandboxViolation"`) || !strings.Contains(w.Body.String(), "outside /tmp") {
+		t.Fatal("Expected sandbox violation", w.Header(), w.Body.String())
+	}
+
+	// A function's own error is kept, even if its output looks like a
+	// violation.
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		fmt.Fprintln(stdout, `{"errorMessage": "Could not cache: EROFS: read-only file system, open '/app/cache'"}`)
+		return &lambda.SandboxError{Err: &lambda.ExitError{Code: 1}, Violation: "The function wrote outside /tmp.", Output: "EROFS"}
+	}
+	w = invoke(s, "hello", InvocationRequestResponse, `{}`)
+	if w.Header().Get("X-Amz-Function-Error") != "Handled" || w.Body.String() != `{"errorMessage": "Could not cache: EROFS: read-only file system, open '/app/cache'"}` {
+		t.Fatal("Expected the function's own error", w.Header(), w.Body.String())
+	}
+}
+
+func TestInvokeTrace(t *testing.T) {
+	traces := make(chan *trace.Context, 1)
+	s := newTestServer(t, func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		traces <- tc
+		return nil
+	})
+	defer s.Close()
+
+	xray := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	r, _ := http.NewRequest("POST", invokePathPrefix+"hello"+invokePathSuffix, strings.NewReader(`{}`))
+	r.Header.Set(trace.XRayHeader, xray)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if tc := <-traces; tc == nil || tc.XRay() != xray {
+		t.Fatal("Expected the caller's trace", tc)
+	}
+	if w.Header().Get(trace.XRayHeader) != xray {
+		t.Fatal("Unexpected trace header", w.Header().Get(trace.XRayHeader))
+	}
+
+	// Without a trace header a new trace is started.
+	w = invoke(s, "hello", "", `{}`)
+	tc := <-traces
+	if tc == nil || !tc.Sampled || tc.XRay() == xray || w.Header().Get(trace.XRayHeader) != tc.XRay() {
+		t.Fatal("Expected a new trace", tc, w.Header())
+	}
+	// Event invocations keep the caller's trace while queued.
+	r, _ = http.NewRequest("POST", invokePathPrefix+"hello"+invokePathSuffix, strings.NewReader(`{}`))
+	r.Header.Set(trace.XRayHeader, xray)
+	r.Header.Set("X-Amz-Invocation-Type", InvocationEvent)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted || w.Header().Get(trace.XRayHeader) != xray {
+		t.Fatal("Unexpected Event response", w.Code, w.Header())
+	}
+	if tc := <-traces; tc == nil || tc.XRay() != xray {
+		t.Fatal("Expected the caller's trace for the Event invocation", tc)
+	}
+}
+
+func TestInvokeEventAndDryRun(t *testing.T) {
+	ran := make(chan string, 1)
+	s := newTestServer(t, func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		ran <- payload
+		return nil
+	})
+	defer s.Close()
+
+	w := invoke(s, "hello", InvocationDryRun, `{}`)
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Expected 204 for DryRun, got", w.Code)
+	}
+
+	w = invoke(s, "arn:aws:lambda:us-east-1:123456789012:function:hello", InvocationEvent, `{"a": 1}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatal("Expected 202 for Event, got", w.Code)
+	}
+	if payload := <-ran; payload != `{"a": 1}` {
+		t.Fatal("Unexpected payload", payload)
+	}
+}
+
+func TestInvokeErrors(t *testing.T) {
+	s := newTestServer(t, func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		t.Fatal("Function should not run")
+		return nil
+	})
+	defer s.Close()
+
+	cases := []struct {
+		name, invocationType, payload string
+		status                        int
+		errorType                     string
+	}{
+		{"missing", "", `{}`, http.StatusNotFound, "ResourceNotFoundException"},
+		{"hello", "Bogus", `{}`, http.StatusBadRequest, "InvalidParameterValueException"},
+		{"hello", "", `{`, http.StatusBadRequest, "InvalidRequestContentException"},
+		{"hello", InvocationEvent, `"` + string(bytes.Repeat([]byte("a"), maxAsyncPayload)) + `"`, http.StatusRequestEntityTooLarge, "RequestTooLargeException"},
+	}
+	for _, c := range cases {
+		w := invoke(s, c.name, c.invocationType, c.payload)
+		if w.Code != c.status || w.Header().Get("X-Amzn-ErrorType") != c.errorType {
+			t.Error("Unexpected response", c.name, c.invocationType, w.Code, w.Header().Get("X-Amzn-ErrorType"))
+		}
+	}
+}
+
+// The throttles of test/hello the server's /metrics report.
+func throttles(t *testing.T, s *Server) float64 {
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	series := `lambda_throttles_total{function="test/hello",version="$LATEST"} `
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, series) {
+			n, err := strconv.ParseFloat(line[len(series):], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+func TestInvokeThrottled(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s, err := New(&Config{Functions: []*Function{{Name: "hello", Image: "test/hello", ReservedConcurrency: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// The metrics are shared by every server in the process.
+	before := throttles(t, s)
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- invoke(s, "hello", "", `{}`)
+	}()
+	<-started
+
+	w := invoke(s, "hello", "", `{}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-Amzn-ErrorType") != "TooManyRequestsException" {
+		t.Fatal("Expected second invocation to be throttled", w.Code)
+	}
+	if stats := s.limiter.Stats(); stats.InFlight != 1 {
+		t.Fatal("Expected one invocation in flight", stats)
+	}
+
+	close(release)
+	if w := <-done; w.Code != http.StatusOK {
+		t.Fatal("Expected first invocation to succeed", w.Code)
+	}
+
+	if n := throttles(t, s); n != before+1 {
+		t.Fatal("Expected throttle in metrics", n-before)
+	}
+
+	// Event invocations are tried again while throttled, only the first try
+	// counts.
+	s.limiter.Acquire("hello")
+	for _, throttled := range []bool{false, true, true} {
+		if err := s.runInvocation(async.Invocation{Function: "hello", Payload: `{}`, Throttled: throttled}); err != lambda.ErrorThrottled {
+			t.Fatal("Expected Event invocation to be throttled", err)
+		}
+	}
+	s.limiter.Release("hello")
+	if n := throttles(t, s); n != before+2 {
+		t.Fatal("Expected one more throttle in metrics", n-before)
+	}
+}
+
+func TestScheduleConfig(t *testing.T) {
+	functions := []*Function{{Name: "hello", Image: "test/hello"}}
+	for _, rule := range []*schedule.Rule{
+		{Name: "unknown", Function: "goodbye", Expression: "rate(1 hour)"},
+		{Name: "invalid", Function: "hello", Expression: "rate(1 hours)"},
+	} {
+		if _, err := New(&Config{Functions: functions, Schedules: []*schedule.Rule{rule}}); err == nil {
+			t.Fatal("Expected error for schedule", rule.Name)
+		}
+	}
+}
+
+func TestAPIGateway(t *testing.T) {
+	s, err := New(&Config{Functions: []*Function{
+		{Name: "hello", Image: "test/hello", Routes: []*apigateway.Route{{Method: "GET", Path: "/hello/{name}"}}},
+		{Name: "goodbye", Image: "test/goodbye"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		if f.Name != "hello" || !strings.Contains(payload, `"pathParameters":{"name":"world"}`) {
+			t.Error("Unexpected invocation", f.Name, payload)
+		}
+		fmt.Fprintln(stdout, `{"statusCode": 200, "body": "Hello, world"}`)
+		return nil
+	}
+
+	r, _ := http.NewRequest("GET", "/hello/world", strings.NewReader(""))
+	w := httptest.NewRecorder()
+	s.APIGateway().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "Hello, world" {
+		t.Fatal("Unexpected response", w.Code, w.Body.String())
+	}
+}
+
+func TestBucketNotifications(t *testing.T) {
+	s, err := New(&Config{
+		Functions: []*Function{{Name: "resize", Image: "test/resize"}},
+		Buckets: []*s3events.BucketConfig{{
+			Name:          "photos",
+			Notifications: []*s3events.Notification{{Function: "resize", Prefix: "uploads/"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	payloads := make(chan string, 1)
+	s.run = func(f *Function, payload string, tc *trace.Context, stdout, stderr io.Writer) error {
+		payloads <- payload
+		return nil
+	}
+
+	event := `{"Records": [{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "photos"}, "object": {"key": "uploads/cat.jpg"}}}]}`
+	r, _ := http.NewRequest("POST", "/notifications/s3", strings.NewReader(event))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatal("Unexpected status", w.Code)
+	}
+
+	select {
+	case payload := <-payloads:
+		if !strings.Contains(payload, `"key":"uploads/cat.jpg"`) {
+			t.Fatal("Unexpected payload", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an Event invocation")
+	}
+
+	if _, err := New(&Config{Buckets: []*s3events.BucketConfig{{Name: "photos", Notifications: []*s3events.Notification{{Function: "resize"}}}}}); err == nil {
+		t.Fatal("Expected error for notification of unknown function")
+	}
+}
+
+func TestQueueConfig(t *testing.T) {
+	functions := []*Function{{Name: "consume", Image: "test/consume"}}
+	for _, m := range []*sqsevents.Mapping{
+		{Function: "unknown", QueueURL: "http://localhost:9324/queue/orders"},
+		{Function: "consume", QueueURL: "http://localhost:9324/queue/orders", BatchSize: 100},
+	} {
+		if _, err := New(&Config{Functions: functions, Queues: []*sqsevents.Mapping{m}}); err == nil {
+			t.Fatal("Expected error for queue", m)
+		}
+	}
+}
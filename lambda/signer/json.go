@@ -0,0 +1,133 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+type JSONOptions struct {
+	// Empty means us-east-1.
+	Region string
+	// Empty means the AWS endpoint of Region.
+	Endpoint string
+	// Requests are signed with these, whatever the endpoint. Nil means the
+	// keys in the environment, and requests are only sent unsigned, like to
+	// local stand-ins, if there are none.
+	Credentials *credentials.Credentials
+}
+
+// Calls AWS services speaking the JSON 1.1 protocol, like KMS and SSM, or a
+// compatible endpoint.
+type JSONClient struct {
+	service string
+	target  string
+	opts    JSONOptions
+	creds   *credentials.Credentials
+	client  *http.Client
+}
+
+// An error the service described, with the namespace stripped from its type.
+type ServiceError struct {
+	Type    string
+	Message string
+}
+
+func (e *ServiceError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// `service` is the signing name, like kms, and `target` the prefix of the
+// actions, like TrentService.
+func NewJSONClient(service, target string, opts JSONOptions) (*JSONClient, error) {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, opts.Region)
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("Invalid %s endpoint %s.", strings.ToUpper(service), opts.Endpoint)
+	}
+
+	c := &JSONClient{
+		service: service,
+		target:  target,
+		opts:    opts,
+		creds:   opts.Credentials,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if c.creds == nil {
+		env := credentials.NewEnvCredentials()
+		if _, err := env.Get(); err == nil {
+			c.creds = env
+		}
+	}
+	return c, nil
+}
+
+// Defaults to us-east-1.
+func (c *JSONClient) Region() string {
+	return c.opts.Region
+}
+
+type jsonError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// Posts `in` as `action` and decodes the response into `out`. Errors the
+// service describes are returned as *ServiceError.
+func (c *JSONClient) Do(action string, in interface{}, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", c.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	r.Header.Set("X-Amz-Target", c.target+"."+action)
+	if c.creds != nil {
+		creds, err := c.creds.Get()
+		if err != nil {
+			return err
+		}
+		Sign(r, body, creds, c.opts.Region, c.service, time.Now())
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToUpper(c.service)
+	if resp.StatusCode != http.StatusOK {
+		var e jsonError
+		if err := json.Unmarshal(b, &e); err != nil || e.Type == "" {
+			return fmt.Errorf("%s %s returned %s", name, action, resp.Status)
+		}
+		// The type may be prefixed with a namespace.
+		return &ServiceError{Type: e.Type[strings.LastIndex(e.Type, "#")+1:], Message: e.Message}
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("Invalid %s response: %s", name, err)
+	}
+	return nil
+}
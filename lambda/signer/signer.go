@@ -0,0 +1,139 @@
+// Package signer signs requests to AWS APIs with Signature Version 4, for the
+// APIs we call without the AWS SDK.
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	algorithm      = "AWS4-HMAC-SHA256"
+	timeFormat     = "20060102T150405Z"
+	dateFormat     = "20060102"
+	requestSuffix  = "aws4_request"
+	headerAmzDate  = "X-Amz-Date"
+	headerSecurity = "X-Amz-Security-Token"
+)
+
+// Signs `r`, whose body is `body`, for `service` in `region`. Session tokens
+// of temporary credentials are sent along.
+func Sign(r *http.Request, body []byte, creds credentials.Value, region string, service string, t time.Time) {
+	t = t.UTC()
+	r.Header.Set(headerAmzDate, t.Format(timeFormat))
+	if creds.SessionToken != "" {
+		r.Header.Set(headerSecurity, creds.SessionToken)
+	}
+	if r.Header.Get("Host") == "" {
+		r.Header.Set("Host", r.URL.Host)
+	}
+
+	signedHeaders, canonicalHeaders := canonicalHeaders(r.Header)
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		canonicalPath(r),
+		canonicalQuery(r),
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(dateFormat), region, service, requestSuffix}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		algorithm,
+		t.Format(timeFormat),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), t.Format(dateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, requestSuffix)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalPath(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func canonicalQuery(r *http.Request) string {
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, escape(k)+"="+escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Escapes everything but the unreserved characters of RFC 3986, as SigV4
+// requires.
+func escape(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Signs all headers set on the request, which are only the ones we set
+// ourselves.
+func canonicalHeaders(header http.Header) (signed string, canonical string) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		if strings.EqualFold(name, "Authorization") || strings.EqualFold(name, "User-Agent") {
+			continue
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		var values []string
+		for _, v := range header[http.CanonicalHeaderKey(name)] {
+			values = append(values, strings.Join(strings.Fields(v), " "))
+		}
+		lines = append(lines, name+":"+strings.Join(values, ","))
+	}
+	return strings.Join(names, ";"), strings.Join(lines, "\n") + "\n"
+}
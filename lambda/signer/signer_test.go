@@ -0,0 +1,83 @@
+package signer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// The get-vanilla and get-vanilla-query-order-key cases of the AWS Signature
+// Version 4 test suite.
+func TestSign(t *testing.T) {
+	creds := credentials.Value{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	when := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"https://example.amazonaws.com/":                             "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		"https://example.amazonaws.com/?Param2=value2&Param1=value1": "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	}
+	for url, signature := range tests {
+		r, _ := http.NewRequest("GET", url, nil)
+		Sign(r, nil, creds, "us-east-1", "service", when)
+
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + signature
+		if auth := r.Header.Get("Authorization"); auth != expected {
+			t.Fatal(url, "unexpected Authorization header", auth)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	creds.SessionToken = "token"
+	Sign(r, nil, creds, "us-east-1", "service", when)
+	if r.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Fatal("Expected session token to be sent")
+	}
+}
+
+func TestJSONClientServiceError(t *testing.T) {
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" {
+			t.Error("Unexpected target", r.Header.Get("X-Amz-Target"))
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type": "com.amazonaws.ssm#ParameterNotFound", "message": "Missing"}`))
+	}))
+	defer srv.Close()
+
+	// Signed with the configured credentials, whatever the endpoint.
+	creds := credentials.NewStaticCredentials("AKID", "SECRET", "")
+	c, err := NewJSONClient("ssm", "AmazonSSM", JSONOptions{Endpoint: srv.URL, Credentials: creds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct{}
+	err = c.Do("GetParameter", struct{}{}, &out)
+	if e, ok := err.(*ServiceError); !ok || e.Type != "ParameterNotFound" || e.Message != "Missing" {
+		t.Fatal("Expected ParameterNotFound service error, got", err)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") {
+		t.Fatal("Expected signed request", auth)
+	}
+
+	// Without credentials in the environment, requests are sent unsigned.
+	defer os.Setenv("AWS_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID"))
+	os.Setenv("AWS_ACCESS_KEY_ID", "")
+	c, err = NewJSONClient("ssm", "AmazonSSM", JSONOptions{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Do("GetParameter", struct{}{}, &out)
+	if auth != "" {
+		t.Fatal("Expected unsigned request without credentials", auth)
+	}
+}
@@ -0,0 +1,147 @@
+package sqsevents
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+const apiVersion = "2012-11-05"
+
+// A minimal client for the SQS query API, enough to consume a queue.
+type client struct {
+	queueURL string
+	region   string
+	// Requests are unsigned if nil, which local SQS stand-ins accept.
+	creds *credentials.Credentials
+	http  *http.Client
+	// Closing it cancels requests in progress.
+	cancel <-chan struct{}
+}
+
+type messageAttributeValue struct {
+	StringValue string `xml:"StringValue"`
+	BinaryValue string `xml:"BinaryValue"`
+	DataType    string `xml:"DataType"`
+}
+
+type message struct {
+	MessageID     string `xml:"MessageId"`
+	ReceiptHandle string `xml:"ReceiptHandle"`
+	MD5OfBody     string `xml:"MD5OfBody"`
+	Body          string `xml:"Body"`
+	Attributes    []struct {
+		Name  string `xml:"Name"`
+		Value string `xml:"Value"`
+	} `xml:"Attribute"`
+	MessageAttributes []struct {
+		Name  string                `xml:"Name"`
+		Value messageAttributeValue `xml:"Value"`
+	} `xml:"MessageAttribute"`
+}
+
+type receiveMessageResponse struct {
+	Messages []*message `xml:"ReceiveMessageResult>Message"`
+}
+
+type deleteMessageBatchResponse struct {
+	Failed []struct {
+		ID      string `xml:"Id"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"DeleteMessageBatchResult>BatchResultErrorEntry"`
+}
+
+// An error returned by SQS.
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Error>Code"`
+	Message    string `xml:"Error>Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("SQS error %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (c *client) do(action string, params url.Values, v interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", apiVersion)
+	body := params.Encode()
+
+	r, err := http.NewRequest("POST", c.queueURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Cancel = c.cancel
+
+	if c.creds != nil {
+		creds, err := c.creds.Get()
+		if err != nil {
+			return err
+		}
+		signer.Sign(r, []byte(body), creds, c.region, "sqs", time.Now())
+	}
+
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := &Error{StatusCode: resp.StatusCode}
+		if err := xml.Unmarshal(b, e); err != nil {
+			e.Message = strings.TrimSpace(string(b))
+		}
+		return e
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// Long polls for up to `max` messages for at most `wait`.
+func (c *client) receive(max int, wait time.Duration) ([]*message, error) {
+	params := url.Values{
+		"MaxNumberOfMessages":    {strconv.Itoa(max)},
+		"WaitTimeSeconds":        {strconv.Itoa(int(wait / time.Second))},
+		"AttributeName.1":        {"All"},
+		"MessageAttributeName.1": {"All"},
+	}
+
+	var resp receiveMessageResponse
+	if err := c.do("ReceiveMessage", params, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+func (c *client) deleteBatch(messages []*message) error {
+	params := url.Values{}
+	for i, m := range messages {
+		prefix := fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.", i+1)
+		params.Set(prefix+"Id", strconv.Itoa(i))
+		params.Set(prefix+"ReceiptHandle", m.ReceiptHandle)
+	}
+
+	var resp deleteMessageBatchResponse
+	if err := c.do("DeleteMessageBatch", params, &resp); err != nil {
+		return err
+	}
+	if len(resp.Failed) > 0 {
+		f := resp.Failed[0]
+		return fmt.Errorf("Could not delete %d of %d messages: %s: %s", len(resp.Failed), len(messages), f.Code, f.Message)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+// Package sqsevents consumes SQS queues and invokes functions with batches of
+// messages, like Lambda event source mappings do. It speaks the SQS query API,
+// so local stand-ins such as ElasticMQ work as well as SQS itself.
+package sqsevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/iron-io/lambda/lambda"
+)
+
+const (
+	DefaultBatchSize = 10
+	// The lowest maximum concurrency Lambda allows.
+	DefaultMaxConcurrency = 2
+
+	defaultRegion  = "us-east-1"
+	defaultAccount = "123456789012"
+
+	// SQS limits.
+	maxReceive       = 10
+	maxWaitTime      = 20 * time.Second
+	maxBatchSize     = 10000
+	maxBatchWindow   = 300
+	maxBatchNoWindow = 10
+
+	// How long to wait after failing to receive or being throttled.
+	errorBackoff    = 5 * time.Second
+	throttleBackoff = time.Second
+)
+
+// Runs a function with an SQS event and waits for it to finish.
+type InvokeFunc func(function string, payload string) error
+
+// Invokes a function with the messages of a queue.
+type Mapping struct {
+	Function string `json:"function"`
+	QueueURL string `json:"queue_url"`
+	// Empty means the region in the queue URL, or us-east-1 for local queues.
+	Region string `json:"region"`
+	// Most messages per invocation. Zero means DefaultBatchSize, more than 10
+	// needs a batch window.
+	BatchSize int `json:"batch_size"`
+	// Seconds to wait for a full batch once the first message arrived.
+	BatchWindow int `json:"batch_window"`
+	// Most invocations at a time. Zero means DefaultMaxConcurrency.
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+func (m *Mapping) validate() error {
+	if m.Function == "" || m.QueueURL == "" {
+		return errors.New("Every queue needs a function and a queue URL.")
+	}
+	u, err := url.Parse(m.QueueURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Invalid queue URL %s.", m.QueueURL)
+	}
+
+	if m.BatchWindow < 0 || m.BatchWindow > maxBatchWindow {
+		return fmt.Errorf("Batch window of %s must be between 0 and %d seconds.", m.QueueURL, maxBatchWindow)
+	}
+	max := maxBatchNoWindow
+	if m.BatchWindow > 0 {
+		max = maxBatchSize
+	}
+	if m.BatchSize < 0 || m.BatchSize > max {
+		return fmt.Errorf("Batch size of %s must be between 1 and %d.", m.QueueURL, max)
+	}
+	if m.MaxConcurrency < 0 {
+		return fmt.Errorf("Invalid maximum concurrency %d for %s.", m.MaxConcurrency, m.QueueURL)
+	}
+	return nil
+}
+
+type Options struct {
+	// Used to sign requests to SQS itself, local queues get unsigned requests.
+	// Nil means the credentials in the environment.
+	Credentials *credentials.Credentials
+}
+
+// The payload functions receive.
+type Event struct {
+	Records []*Record `json:"Records"`
+}
+
+type Record struct {
+	MessageID         string                       `json:"messageId"`
+	ReceiptHandle     string                       `json:"receiptHandle"`
+	Body              string                       `json:"body"`
+	Attributes        map[string]string            `json:"attributes"`
+	MessageAttributes map[string]*MessageAttribute `json:"messageAttributes"`
+	MD5OfBody         string                       `json:"md5OfBody"`
+	EventSource       string                       `json:"eventSource"`
+	EventSourceARN    string                       `json:"eventSourceARN"`
+	AWSRegion         string                       `json:"awsRegion"`
+}
+
+type MessageAttribute struct {
+	StringValue      *string  `json:"stringValue,omitempty"`
+	BinaryValue      *string  `json:"binaryValue,omitempty"`
+	StringListValues []string `json:"stringListValues"`
+	BinaryListValues []string `json:"binaryListValues"`
+	DataType         string   `json:"dataType"`
+}
+
+type queue struct {
+	*Mapping
+	client *client
+	arn    string
+}
+
+type Poller struct {
+	invoke InvokeFunc
+	queues []*queue
+
+	done    chan struct{}
+	closing sync.Once
+	pollers sync.WaitGroup
+}
+
+// Starts polling the queues.
+func NewPoller(invoke InvokeFunc, mappings []*Mapping, opts Options) (*Poller, error) {
+	p := &Poller{
+		invoke: invoke,
+		done:   make(chan struct{}),
+	}
+
+	for _, m := range mappings {
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+
+		u, _ := url.Parse(m.QueueURL)
+		region, account, name := queueInfo(u)
+		if m.Region != "" {
+			region = m.Region
+		}
+
+		c := &client{
+			queueURL: m.QueueURL,
+			region:   region,
+			// Long polls take up to 20 seconds.
+			http:   &http.Client{Timeout: maxWaitTime + 10*time.Second},
+			cancel: p.done,
+		}
+		if strings.HasSuffix(u.Host, ".amazonaws.com") {
+			c.creds = opts.Credentials
+			if c.creds == nil {
+				c.creds = credentials.NewEnvCredentials()
+			}
+		}
+
+		p.queues = append(p.queues, &queue{
+			Mapping: m,
+			client:  c,
+			arn:     fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, account, name),
+		})
+	}
+
+	for _, q := range p.queues {
+		concurrency := q.MaxConcurrency
+		if concurrency == 0 {
+			concurrency = DefaultMaxConcurrency
+		}
+		for i := 0; i < concurrency; i++ {
+			p.pollers.Add(1)
+			go p.poll(q)
+		}
+	}
+	return p, nil
+}
+
+// Queue URLs look like https://sqs.us-east-1.amazonaws.com/123456789012/name
+// on AWS. Local stand-ins use their own layouts.
+func queueInfo(u *url.URL) (region string, account string, name string) {
+	region, account = defaultRegion, defaultAccount
+	if host := strings.Split(u.Host, "."); len(host) == 4 && host[0] == "sqs" && strings.HasSuffix(u.Host, ".amazonaws.com") {
+		region = host[1]
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	name = parts[len(parts)-1]
+	if len(parts) == 2 && strings.Trim(parts[0], "0123456789") == "" {
+		account = parts[0]
+	}
+	return region, account, name
+}
+
+// Stops polling and waits for running invocations to finish. Messages of
+// batches not invoked yet become visible again after their visibility
+// timeout.
+func (p *Poller) Close() {
+	p.closing.Do(func() { close(p.done) })
+	p.pollers.Wait()
+}
+
+func (p *Poller) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Poller) sleep(d time.Duration) {
+	select {
+	case <-time.After(d):
+	case <-p.done:
+	}
+}
+
+func (p *Poller) poll(q *queue) {
+	defer p.pollers.Done()
+
+	for !p.closed() {
+		messages, err := p.receiveBatch(q)
+		if err != nil {
+			if !p.closed() {
+				log.Printf("Queue %s: error receiving messages: %s", q.QueueURL, err)
+				p.sleep(errorBackoff)
+			}
+			continue
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		payload, err := json.Marshal(q.event(messages))
+		if err != nil {
+			panic(err)
+		}
+
+		// Failed batches stay in the queue and are received again once
+		// their visibility timeout expires.
+		if err := p.invoke(q.Function, string(payload)); err != nil {
+			log.Printf("Queue %s: %s failed for %d messages: %s", q.QueueURL, q.Function, len(messages), err)
+			if err == lambda.ErrorThrottled {
+				p.sleep(throttleBackoff)
+			}
+			continue
+		}
+
+		for i := 0; i < len(messages); i += maxReceive {
+			end := i + maxReceive
+			if end > len(messages) {
+				end = len(messages)
+			}
+			if err := q.client.deleteBatch(messages[i:end]); err != nil {
+				log.Printf("Queue %s: error deleting messages: %s", q.QueueURL, err)
+			}
+		}
+	}
+}
+
+// Waits for a first message, then collects messages until the batch is full
+// or the batch window is over.
+func (p *Poller) receiveBatch(q *queue) ([]*message, error) {
+	size := q.BatchSize
+	if size == 0 {
+		size = DefaultBatchSize
+	}
+	window := time.Duration(q.BatchWindow) * time.Second
+
+	var (
+		batch    []*message
+		deadline time.Time
+	)
+	for len(batch) < size && !p.closed() {
+		wait := maxWaitTime
+		if len(batch) > 0 {
+			wait = deadline.Sub(time.Now())
+			if wait <= 0 {
+				break
+			}
+			if wait > maxWaitTime {
+				wait = maxWaitTime
+			}
+		}
+
+		max := size - len(batch)
+		if max > maxReceive {
+			max = maxReceive
+		}
+		messages, err := q.client.receive(max, wait)
+		if err != nil {
+			// The messages we have will become visible again.
+			return nil, err
+		}
+		if len(messages) == 0 && len(batch) > 0 && wait < time.Second {
+			break
+		}
+
+		if len(batch) == 0 && len(messages) > 0 {
+			deadline = time.Now().Add(window)
+		}
+		batch = append(batch, messages...)
+		if window == 0 && len(batch) > 0 {
+			break
+		}
+	}
+	return batch, nil
+}
+
+func (q *queue) event(messages []*message) *Event {
+	event := &Event{}
+	for _, m := range messages {
+		r := &Record{
+			MessageID:         m.MessageID,
+			ReceiptHandle:     m.ReceiptHandle,
+			Body:              m.Body,
+			Attributes:        make(map[string]string),
+			MessageAttributes: make(map[string]*MessageAttribute),
+			MD5OfBody:         m.MD5OfBody,
+			EventSource:       "aws:sqs",
+			EventSourceARN:    q.arn,
+			AWSRegion:         q.client.region,
+		}
+		for _, a := range m.Attributes {
+			r.Attributes[a.Name] = a.Value
+		}
+		for _, a := range m.MessageAttributes {
+			attr := &MessageAttribute{
+				DataType:         a.Value.DataType,
+				StringListValues: []string{},
+				BinaryListValues: []string{},
+			}
+			if strings.HasPrefix(a.Value.DataType, "Binary") {
+				v := a.Value.BinaryValue
+				attr.BinaryValue = &v
+			} else {
+				v := a.Value.StringValue
+				attr.StringValue = &v
+			}
+			r.MessageAttributes[a.Name] = attr
+		}
+		event.Records = append(event.Records, r)
+	}
+	return event
+}
@@ -0,0 +1,195 @@
+package sqsevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A queue that hands out at most `perReceive` messages per ReceiveMessage.
+type fakeQueue struct {
+	mu         sync.Mutex
+	perReceive int
+	visible    []string
+	received   map[string]string // Receipt handle to body.
+	deleted    []string
+}
+
+func (q *fakeQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch r.Form.Get("Action") {
+	case "ReceiveMessage":
+		max, _ := strconv.Atoi(r.Form.Get("MaxNumberOfMessages"))
+		if q.perReceive > 0 && q.perReceive < max {
+			max = q.perReceive
+		}
+		// Stands in for long polling.
+		if len(q.visible) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		fmt.Fprint(w, "<ReceiveMessageResponse><ReceiveMessageResult>")
+		for i := 0; i < max && len(q.visible) > 0; i++ {
+			body := q.visible[0]
+			q.visible = q.visible[1:]
+			handle := "handle-" + body
+			q.received[handle] = body
+			fmt.Fprintf(w, `<Message><MessageId>id-%s</MessageId><ReceiptHandle>%s</ReceiptHandle><MD5OfBody>x</MD5OfBody><Body>%s</Body>
+<Attribute><Name>ApproximateReceiveCount</Name><Value>1</Value></Attribute>
+<MessageAttribute><Name>color</Name><Value><StringValue>blue</StringValue><DataType>String</DataType></Value></MessageAttribute></Message>`, body, handle, body)
+		}
+		fmt.Fprint(w, "</ReceiveMessageResult></ReceiveMessageResponse>")
+	case "DeleteMessageBatch":
+		for i := 1; ; i++ {
+			handle := r.Form.Get(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.ReceiptHandle", i))
+			if handle == "" {
+				break
+			}
+			q.deleted = append(q.deleted, q.received[handle])
+		}
+		fmt.Fprint(w, "<DeleteMessageBatchResponse><DeleteMessageBatchResult></DeleteMessageBatchResult></DeleteMessageBatchResponse>")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<ErrorResponse><Error><Code>InvalidAction</Code><Message>Unknown action</Message></Error></ErrorResponse>")
+	}
+}
+
+func (q *fakeQueue) deletedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.deleted)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for", what)
+}
+
+func TestPoller(t *testing.T) {
+	q := &fakeQueue{visible: []string{"a", "b", "c", "fail"}, received: make(map[string]string), perReceive: 1}
+	server := httptest.NewServer(q)
+	defer server.Close()
+
+	var mu sync.Mutex
+	var events []*Event
+	p, err := NewPoller(func(function string, payload string) error {
+		var event Event
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		events = append(events, &event)
+		mu.Unlock()
+		for _, r := range event.Records {
+			if r.Body == "fail" {
+				return errors.New("Container exited with non-zero exit code 1")
+			}
+		}
+		return nil
+	}, []*Mapping{{Function: "consume", QueueURL: server.URL + "/123456789012/orders", BatchSize: 3, BatchWindow: 1, MaxConcurrency: 1}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "both batches", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 2
+	})
+	p.Close()
+
+	first := events[0]
+	if len(first.Records) != 3 {
+		t.Fatal("Expected the batch window to collect 3 messages, got", len(first.Records))
+	}
+	r := first.Records[0]
+	if r.MessageID != "id-a" || r.ReceiptHandle != "handle-a" || r.Body != "a" || r.EventSource != "aws:sqs" {
+		t.Fatal("Unexpected record", r)
+	}
+	if r.EventSourceARN != "arn:aws:sqs:us-east-1:123456789012:orders" || r.Attributes["ApproximateReceiveCount"] != "1" {
+		t.Fatal("Unexpected record", r)
+	}
+	if a := r.MessageAttributes["color"]; a == nil || a.StringValue == nil || *a.StringValue != "blue" || a.DataType != "String" {
+		t.Fatal("Unexpected message attributes", r.MessageAttributes)
+	}
+
+	if len(q.deleted) != 3 {
+		t.Fatal("Expected successful messages to be deleted, got", q.deleted)
+	}
+	for _, body := range q.deleted {
+		if body == "fail" {
+			t.Fatal("Failed messages should stay in the queue")
+		}
+	}
+}
+
+func TestPollerBatchSize(t *testing.T) {
+	q := &fakeQueue{received: make(map[string]string)}
+	for i := 0; i < 25; i++ {
+		q.visible = append(q.visible, fmt.Sprint(i))
+	}
+	server := httptest.NewServer(q)
+	defer server.Close()
+
+	sizes := make(chan int, 10)
+	p, err := NewPoller(func(function string, payload string) error {
+		var event Event
+		json.Unmarshal([]byte(payload), &event)
+		sizes <- len(event.Records)
+		return nil
+	}, []*Mapping{{Function: "consume", QueueURL: server.URL + "/queue/orders", BatchSize: 4, MaxConcurrency: 1}}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "all messages", func() bool { return q.deletedCount() == 25 })
+	p.Close()
+	close(sizes)
+	for size := range sizes {
+		if size > 4 {
+			t.Fatal("Batch larger than batch size", size)
+		}
+	}
+}
+
+func TestMappingValidation(t *testing.T) {
+	for _, m := range []*Mapping{
+		{QueueURL: "http://localhost/q"},
+		{Function: "f", QueueURL: "localhost/q"},
+		{Function: "f", QueueURL: "http://localhost/q", BatchSize: 11},
+		{Function: "f", QueueURL: "http://localhost/q", BatchWindow: 301},
+		{Function: "f", QueueURL: "http://localhost/q", MaxConcurrency: -1},
+	} {
+		if err := m.validate(); err == nil {
+			t.Fatal("Expected error for", m)
+		}
+	}
+	if err := (&Mapping{Function: "f", QueueURL: "http://localhost/q", BatchSize: 100, BatchWindow: 5}).validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for rawurl, arn := range map[string]string{
+		"https://sqs.eu-west-1.amazonaws.com/210987654321/orders": "eu-west-1 210987654321 orders",
+		"http://localhost:9324/queue/orders":                      "us-east-1 123456789012 orders",
+	} {
+		u, _ := url.Parse(rawurl)
+		region, account, name := queueInfo(u)
+		if got := region + " " + account + " " + name; got != arn {
+			t.Fatal(rawurl, "unexpected queue info", got)
+		}
+	}
+}
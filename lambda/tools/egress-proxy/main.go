@@ -0,0 +1,48 @@
+package main
+
+// The proxy of egress networks, run in the iron/lambda-egress image.
+//
+// Usage: egress-proxy [-addr :3128] rule ...
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda/egress"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: egress-proxy [-addr :3128] rule ...
+
+Proxies HTTP requests and CONNECT tunnels to the destinations allowed by the
+rules, and refuses all others. Rules are host names, wildcard domains like
+*.amazonaws.com, IP addresses or CIDR blocks, each optionally with a :port.
+Without arguments, the rules are read from EGRESS_ALLOW, separated by commas.`)
+	os.Exit(1)
+}
+
+func main() {
+	addr := flag.String("addr", ":"+egress.ProxyPort, "Address to listen on")
+	flag.Usage = usage
+	flag.Parse()
+
+	rules := flag.Args()
+	if len(rules) == 0 && os.Getenv("EGRESS_ALLOW") != "" {
+		rules = strings.Split(os.Getenv("EGRESS_ALLOW"), ",")
+	}
+	if len(rules) == 0 {
+		usage()
+	}
+
+	allow, err := egress.ParseAllowList(rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Allowing egress to", strings.Join(allow.Entries(), ", "))
+	log.Fatal(http.ListenAndServe(*addr, egress.NewProxy(allow)))
+}
@@ -0,0 +1,75 @@
+package main
+
+// Encrypt environment variable values for a function.
+//
+// Usage: encrypt-env [-key-file file] [-kms-key id] function NAME=value ...
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda/envcrypt"
+	"github.com/iron-io/lambda/lambda/signer"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: encrypt-env [-key-file file | -kms-key id [-kms-region region] [-kms-endpoint url]] function NAME=value ...
+       encrypt-env -generate-key file
+
+Prints NAME=value lines with the values encrypted for function, the image
+name without tag. They only decrypt for that function, with the same key.
+Put them into the environment of a lambda-server config, or pass them to
+CreateImage or RunOptions.`)
+	os.Exit(1)
+}
+
+func main() {
+	generate := flag.String("generate-key", "", "Write a new random key to this file")
+	keyFile := flag.String("key-file", "", "Encrypt with the key in this file")
+	kmsKey := flag.String("kms-key", "", "Encrypt with this KMS key")
+	kmsRegion := flag.String("kms-region", "", "Region of the KMS key")
+	kmsEndpoint := flag.String("kms-endpoint", "", "KMS-compatible endpoint to use instead of AWS")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *generate != "" {
+		if err := envcrypt.GenerateKeyFile(*generate); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.NArg() < 2 || (*keyFile == "") == (*kmsKey == "") {
+		usage()
+	}
+
+	var key envcrypt.Key
+	var err error
+	if *keyFile != "" {
+		key, err = envcrypt.NewKeyFile(*keyFile)
+	} else {
+		key, err = envcrypt.NewKMS(envcrypt.KMSOptions{
+			KeyID:       *kmsKey,
+			JSONOptions: signer.JSONOptions{Region: *kmsRegion, Endpoint: *kmsEndpoint},
+		})
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	function := flag.Arg(0)
+	for _, arg := range flag.Args()[1:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Invalid variable %q, expected NAME=value.", arg)
+		}
+		v, err := envcrypt.EncryptValue(key, function, parts[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s=%s\n", parts[0], v)
+	}
+}
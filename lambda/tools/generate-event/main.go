@@ -0,0 +1,100 @@
+package main
+
+// Print the payload an AWS event source would send to a Lambda function.
+//
+// Usage: generate-event [-run image [-debug port] [-source dir]] template [name=value ...]
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/iron-io/lambda/lambda"
+	"github.com/iron-io/lambda/lambda/events"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: generate-event [-run image [-debug port] [-source dir]] template [name=value ...]
+
+Prints the payload of an AWS event source, with the template's parameters set
+to the given values. Pipe it into a local invocation:
+
+  generate-event s3-put bucket=photos key=cat.jpg | docker run --rm -i user/resize
+
+or run the image directly with -run. With -debug, the runtime's debugger
+listens on the given port of 127.0.0.1 and the function waits for it to attach,
+then has the maximum timeout.
+With -source, the function's code is loaded from the directory instead of the
+image, and it runs again with the same payload whenever the code changes, until
+interrupted.
+
+Templates:`)
+	for _, t := range events.Templates() {
+		fmt.Fprintf(os.Stderr, "\n  %s - %s\n", t.Name, t.Description)
+		for _, p := range t.Params {
+			fmt.Fprintf(os.Stderr, "      %s=%q: %s\n", p.Name, p.Default, p.Description)
+		}
+	}
+	os.Exit(1)
+}
+
+func main() {
+	image := flag.String("run", "", "Run this image with the payload instead of printing it")
+	debugPort := flag.Int("debug", 0, "With -run, wait for a debugger on this port")
+	source := flag.String("source", "", "With -run, load the function from this directory and run it again on changes")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	params := make(events.Params)
+	for _, arg := range flag.Args()[1:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("Invalid parameter %q, expected name=value.", arg)
+		}
+		params[parts[0]] = parts[1]
+	}
+
+	payload, err := events.Generate(flag.Arg(0), params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *image == "" {
+		fmt.Println(string(payload))
+		return
+	}
+	opts := lambda.RunOptions{DebugPort: *debugPort}
+	if *debugPort != 0 {
+		// Time to step through the function once the debugger attached.
+		opts.Timeout = lambda.MaxTimeout
+	}
+	if *source == "" {
+		if err := lambda.RunImageWithOptions(*image, string(payload), opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	opts.Source, err = filepath.Abs(*source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dev, err := lambda.NewDevRunner(*image, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := dev.Run(string(payload)); err != nil {
+		log.Println(err)
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	dev.Close()
+}
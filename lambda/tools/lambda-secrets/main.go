@@ -0,0 +1,75 @@
+package main
+
+// Manage the local secret store that `secret:` references resolve from.
+//
+// Usage: lambda-secrets [-store file -key file] put|get|delete|list [name [value]]
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/iron-io/lambda/lambda/secrets"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: lambda-secrets [-store file -key file] command
+
+Commands:
+  put name value   Add or replace a secret
+  get name         Print a secret
+  delete name      Remove a secret
+  list             Print the names of all secrets
+
+Function environment variables refer to secrets as secret:name. Secrets are
+kept encrypted in ~/.iron-lambda/secrets.json, with the key in
+~/.iron-lambda/secrets.key, unless -store and -key are given.`)
+	os.Exit(1)
+}
+
+func main() {
+	storeFile := flag.String("store", "", "Secret store file")
+	keyFile := flag.String("key", "", "Key file of the secret store")
+	flag.Usage = usage
+	flag.Parse()
+
+	var store *secrets.FileStore
+	var err error
+	if *storeFile == "" && *keyFile == "" {
+		store, err = secrets.DefaultFileStore()
+	} else {
+		store, err = secrets.NewFileStore(*storeFile, *keyFile)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+	switch {
+	case args[0] == "put" && len(args) == 3:
+		err = store.Put(args[1], args[2])
+	case args[0] == "get" && len(args) == 2:
+		var v string
+		if v, err = store.Get(args[1]); err == nil {
+			fmt.Println(v)
+		}
+	case args[0] == "delete" && len(args) == 2:
+		err = store.Delete(args[1])
+	case args[0] == "list" && len(args) == 1:
+		var names []string
+		if names, err = store.List(); err == nil {
+			for _, name := range names {
+				fmt.Println(name)
+			}
+		}
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+// Serve the AWS Lambda Invoke API for the functions in a config file.
+//
+// Usage: lambda-server [-addr :8080] [-api-addr :8081] [-credentials-addr :8082] config.json
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/iron-io/lambda/lambda/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	apiAddr := flag.String("api-addr", ":8081", "Address to serve function routes on")
+	credsAddr := flag.String("credentials-addr", ":8082", "Address to serve function credentials on")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, `Usage: lambda-server [-addr :8080] [-api-addr :8081] config.json
+
+Serves the AWS Lambda Invoke API for the functions in config.json:
+
+{
+  "functions": [
+    {"name": "hello", "image": "user/hello:1", "memory_size": 128, "timeout": 3, "warm_containers": 1, "reserved_concurrency": 10,
+     "routes": [{"method": "GET", "path": "/hello/{name}"}],
+     "profile": "dev", "role_arn": "arn:aws:iam::123456789012:role/hello", "external_id": "local",
+     "environment": {"CONFIG_GREETING": "hi", "CONFIG_DB_PASSWORD": "encrypted:...", "CONFIG_API_KEY": "ssm:/prod/api-key"},
+     "dns": ["10.0.0.2"], "egress_allow": ["*.amazonaws.com:443", "10.0.0.0/16"], "sandbox": true,
+     "mounts": [{"source": "shared-data", "target": "/mnt/data"}, {"source": "/srv/models", "target": "/opt/models", "read_only": true}]}
+  ],
+  "max_concurrency": 100,
+  "schedules": [
+    {"name": "hourly-hello", "function": "hello", "expression": "rate(1 hour)", "no_overlap": true}
+  ],
+  "schedule_state_file": "./schedules.json",
+  "buckets": [
+    {"name": "photos", "dir": "./buckets/photos",
+     "notifications": [{"function": "hello", "events": ["s3:ObjectCreated:*"], "prefix": "uploads/", "suffix": ".jpg"}]}
+  ],
+  "queues": [
+    {"function": "hello", "queue_url": "http://localhost:9324/queue/orders", "batch_size": 10, "batch_window": 5, "max_concurrency": 2}
+  ],
+  "async": {"workers": 4, "max_retries": 2, "backoff": 60, "max_event_age": 21600, "dead_letter_dir": "./dead-letters"},
+  "credentials_url": "http://172.17.0.1:8082",
+  "credentials_key_file": "./credentials.key",
+  "encryption": {"key_file": "./env.key"},
+  "secrets": {"ssm_region": "us-east-1", "cache_ttl": 300},
+  "logs": {"file": "./functions.log", "max_size": 100, "max_files": 5, "syslog": "udp://localhost:514", "tcp": "localhost:5170"},
+  "tracing": {"otlp_endpoint": "http://localhost:4318", "service_name": "lambda"}
+}
+
+Point AWS SDK clients at it by setting the Lambda endpoint to http://<addr>.
+
+Event invocations that fail every retry are kept in dead_letter_dir. They can
+be listed with GET /dead-letters and replayed with
+POST /dead-letters/{id}/replay.
+
+Invocations over the concurrency limits are throttled: RequestResponse calls
+fail with TooManyRequestsException and Event calls stay queued, for up to
+max_event_age seconds before they become dead letters. Invocations in
+flight are reported by GET /concurrency.
+
+Schedules take CloudWatch Events rate(...) and cron(...) expressions, with cron
+expressions in UTC, and invoke their function with an aws.events payload.
+
+Routes are served on api-addr like API Gateway resources with a Lambda proxy
+integration. {name} in a path matches one segment and {name+} the rest of the
+path.
+
+Files created, changed or removed in a bucket's dir invoke the functions of
+matching notifications with S3 events. Buckets without a dir get their events
+from S3-compatible servers that POST them to /notifications/s3.
+
+Queues are polled with the SQS query API and invoke their function with batches
+of messages, which are deleted once the function succeeds. Requests to SQS
+itself are signed with the AWS credentials in the environment.
+
+Functions get the AWS credentials in the environment, or those of profile in
+~/.aws/credentials. With a role_arn, they get temporary credentials for the
+role from STS instead, renewed before they expire.
+
+With a credentials_url, functions get no keys. They fetch temporary
+credentials, scoped to the function, from credentials-addr like from the ECS
+container credentials endpoint, which containers must reach at
+credentials_url.
+
+A function's network is "none", the name of a Docker network, or Docker's
+default bridge if empty. With egress_allow, it can only reach those hosts,
+domains, IP addresses and CIDR blocks, through the proxy of the
+iron/lambda-egress image in HTTP_PROXY and HTTPS_PROXY.
+
+Functions with sandbox set run like on Lambda, as an unprivileged user with a
+read-only root filesystem and a 512MB /tmp. Invocations that fail because of
+that return a SandboxViolation error.
+
+The mounts of a function are Docker volumes, which must exist, or directories
+of the Docker host if the source is an absolute path.
+
+Encrypted environment values, see encrypt-env, are decrypted with the key file
+or the KMS key (kms_key_id, kms_region, kms_endpoint) of encryption just before
+the function's container starts. Values like secret:name refer to secrets in
+the local store managed with lambda-secrets, ssm:/name to parameters of the SSM
+Parameter Store of secrets, and are resolved at the same time.
+
+The output of every invocation is shipped as JSON records, one per line, with
+the timestamp, request ID, function, stream and message, to the sinks of logs:
+a file rotated after max_size MB, syslog ("local" or a udp:// or tcp://
+address) and TCP.
+
+Invocations, errors, timeouts, throttles, cold starts, durations, memory use
+and concurrency of every function are served in the Prometheus text format on
+/metrics.
+
+RequestResponse invocations continue the trace of the X-Amzn-Trace-Id or
+traceparent header of the request, other invocations start a new trace, which
+functions get as _X_AMZN_TRACE_ID and TRACEPARENT. With an otlp_endpoint,
+spans for starting the container, running the handler and tearing it down are
+exported to that OpenTelemetry collector.`)
+		os.Exit(1)
+	}
+
+	config, err := server.LoadConfig(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := server.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	if creds := s.Credentials(); creds != nil {
+		go func() {
+			log.Println("Serving function credentials on", *credsAddr)
+			log.Fatal(http.ListenAndServe(*credsAddr, creds))
+		}()
+	}
+
+	if gateway := s.APIGateway(); gateway != nil {
+		go func() {
+			log.Println("Serving function routes on", *apiAddr)
+			log.Fatal(http.ListenAndServe(*apiAddr, gateway))
+		}()
+	}
+
+	log.Println("Listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
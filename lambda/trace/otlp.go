@@ -0,0 +1,246 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultServiceName   = "iron-lambda"
+	DefaultFlushInterval = 5 * time.Second
+	DefaultMaxBatch      = 512
+
+	otlpTracesPath = "/v1/traces"
+)
+
+type OTLPOptions struct {
+	// Resource service.name of all spans. Empty means DefaultServiceName.
+	ServiceName string
+	// How often spans are sent. Zero means DefaultFlushInterval.
+	FlushInterval time.Duration
+	// Spans are sent early once this many are waiting. Zero means
+	// DefaultMaxBatch.
+	MaxBatch int
+	Client   *http.Client
+}
+
+// Exports spans in batches to an OpenTelemetry collector with OTLP over HTTP,
+// JSON encoded. Spans that can not be sent are logged and dropped.
+type OTLPExporter struct {
+	url  string
+	opts OTLPOptions
+
+	mu      sync.Mutex
+	pending []*Span
+	flush   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+// `endpoint` is the collector's base URL, like http://localhost:4318.
+func NewOTLPExporter(endpoint string, opts OTLPOptions) (*OTLPExporter, error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("Invalid OTLP endpoint %s. Should be an http:// or https:// URL.", endpoint)
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = DefaultServiceName
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.MaxBatch == 0 {
+		opts.MaxBatch = DefaultMaxBatch
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	e := &OTLPExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + otlpTracesPath,
+		opts:    opts,
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.loop()
+	return e, nil
+}
+
+func (e *OTLPExporter) Export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+
+	e.pending = append(e.pending, s)
+	if len(e.pending) >= e.opts.MaxBatch {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Sends the spans still waiting.
+func (e *OTLPExporter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	close(e.done)
+	<-e.stopped
+	return e.send()
+}
+
+func (e *OTLPExporter) loop() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		if err := e.send(); err != nil {
+			log.Println("Could not export spans:", err)
+		}
+	}
+}
+
+func (e *OTLPExporter) send() error {
+	e.mu.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.opts.Client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("Collector responded with %s to %d spans.", resp.Status, len(spans))
+	}
+	return nil
+}
+
+// The ExportTraceServiceRequest message in its JSON mapping, where IDs are hex
+// and 64 bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	var out []otlpSpan
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.ID.String(),
+			Name:              s.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if !s.ParentID.isZero() {
+			span.ParentSpanID = s.ParentID.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		out = append(out, span)
+	}
+
+	return otlpRequest{[]otlpResourceSpans{{
+		Resource:   otlpResource{attributes(map[string]string{"service.name": e.opts.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{DefaultServiceName}, Spans: out}},
+	}}}
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var attrs []otlpAttribute
+	for _, k := range keys {
+		attrs = append(attrs, otlpAttribute{k, otlpValue{m[k]}})
+	}
+	return attrs
+}
@@ -0,0 +1,249 @@
+// Package trace carries trace contexts across invocations in the X-Ray
+// (X-Amzn-Trace-Id) and W3C (traceparent) formats, and records spans that are
+// exported to an OpenTelemetry collector.
+//
+// X-Ray trace IDs are W3C trace IDs with the first 4 bytes holding the time
+// the trace started, so the same trace can be passed in both formats:
+//
+//	X-Amzn-Trace-Id: Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+//	traceparent: 00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	XRayHeader        = "X-Amzn-Trace-Id"
+	TraceparentHeader = "Traceparent"
+
+	// The environment variables functions find the trace context in. The
+	// X-Ray SDKs read _X_AMZN_TRACE_ID, OpenTelemetry propagators TRACEPARENT.
+	XRayEnv        = "_X_AMZN_TRACE_ID"
+	TraceparentEnv = "TRACEPARENT"
+)
+
+var ErrorInvalidHeader = errors.New("Invalid trace header.")
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) isZero() bool {
+	return id == TraceID{}
+}
+
+func (id SpanID) isZero() bool {
+	return id == SpanID{}
+}
+
+// Where an invocation is in a trace.
+type Context struct {
+	TraceID TraceID
+	// The span the invocation was made from, zero for the root of a trace.
+	ParentID SpanID
+	// Whether spans of the trace are recorded. Unsampled contexts are still
+	// passed on, so downstream services make the same decision.
+	Sampled bool
+}
+
+// Starts a new sampled trace.
+func New() Context {
+	var c Context
+	binary.BigEndian.PutUint32(c.TraceID[:4], uint32(time.Now().Unix()))
+	rand.Read(c.TraceID[4:])
+	c.Sampled = true
+	return c
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
+
+// Returns the trace context of an incoming request, preferring the X-Ray
+// header, or false if it has none or only invalid ones.
+func FromHeaders(h http.Header) (Context, bool) {
+	if v := h.Get(XRayHeader); v != "" {
+		if c, err := ParseXRay(v); err == nil {
+			return c, true
+		}
+	}
+	if v := h.Get(TraceparentHeader); v != "" {
+		if c, err := ParseTraceparent(v); err == nil {
+			return c, true
+		}
+	}
+	return Context{}, false
+}
+
+// Parses an X-Amzn-Trace-Id header. A missing Sampled field means sampled,
+// since that is what the runner would decide for a new trace.
+func ParseXRay(header string) (Context, error) {
+	c := Context{Sampled: true}
+	root := false
+	for _, field := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			parts := strings.Split(kv[1], "-")
+			if len(parts) != 3 || parts[0] != "1" || !decodeHex(c.TraceID[:4], parts[1]) || !decodeHex(c.TraceID[4:], parts[2]) {
+				return Context{}, ErrorInvalidHeader
+			}
+			root = true
+		case "Parent":
+			if !decodeHex(c.ParentID[:], kv[1]) {
+				return Context{}, ErrorInvalidHeader
+			}
+		case "Sampled":
+			c.Sampled = kv[1] != "0"
+		}
+	}
+	if !root || c.TraceID.isZero() {
+		return Context{}, ErrorInvalidHeader
+	}
+	return c, nil
+}
+
+// Parses a W3C traceparent header.
+func ParseTraceparent(header string) (Context, error) {
+	var c Context
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 {
+		return Context{}, ErrorInvalidHeader
+	}
+	// Later versions may add fields, but must keep these.
+	if parts[0] == "00" && len(parts) != 4 {
+		return Context{}, ErrorInvalidHeader
+	}
+
+	var flags [1]byte
+	if !decodeHex(c.TraceID[:], parts[1]) || !decodeHex(c.ParentID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return Context{}, ErrorInvalidHeader
+	}
+	if c.TraceID.isZero() || c.ParentID.isZero() {
+		return Context{}, ErrorInvalidHeader
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, nil
+}
+
+// Decodes `s` into `dst`, which it must fill exactly.
+func decodeHex(dst []byte, s string) bool {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// The context as an X-Amzn-Trace-Id header.
+func (c Context) XRay() string {
+	s := fmt.Sprintf("Root=1-%x-%x", c.TraceID[:4], c.TraceID[4:])
+	if !c.ParentID.isZero() {
+		s += ";Parent=" + c.ParentID.String()
+	}
+	if c.Sampled {
+		return s + ";Sampled=1"
+	}
+	return s + ";Sampled=0"
+}
+
+// The context as a W3C traceparent header. A root context has no parent to
+// refer to, so it gets a random one.
+func (c Context) Traceparent() string {
+	parent := c.ParentID
+	if parent.isZero() {
+		parent = newSpanID()
+	}
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceID, parent, flags)
+}
+
+// Environment variables that pass the context to a function.
+func (c Context) Env() []string {
+	return []string{XRayEnv + "=" + c.XRay(), TraceparentEnv + "=" + c.Traceparent()}
+}
+
+// One timed operation of an invocation.
+type Span struct {
+	Name       string
+	TraceID    TraceID
+	ID         SpanID
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	// Set if the operation failed.
+	Error string
+
+	exporter Exporter
+	sampled  bool
+}
+
+// Receives finished spans, concurrently.
+type Exporter interface {
+	Export(s *Span)
+	Close() error
+}
+
+// Starts a span as a child of `parent`. Spans of unsampled contexts, or
+// without an exporter, are timed but not exported.
+func Start(exporter Exporter, parent Context, name string) *Span {
+	return &Span{
+		Name:       name,
+		TraceID:    parent.TraceID,
+		ID:         newSpanID(),
+		ParentID:   parent.ParentID,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		exporter:   exporter,
+		sampled:    parent.Sampled,
+	}
+}
+
+// The context for operations the span is the parent of.
+func (s *Span) Context() Context {
+	return Context{TraceID: s.TraceID, ParentID: s.ID, Sampled: s.sampled}
+}
+
+// Starts a span as a child of `s`.
+func (s *Span) Child(name string) *Span {
+	return Start(s.exporter, s.Context(), name)
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	s.Attributes[key] = value
+}
+
+// Ends the span, failed if `err` is not nil, and exports it.
+func (s *Span) Finish(err error) {
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if s.exporter != nil && s.sampled {
+		s.exporter.Export(s)
+	}
+}
@@ -0,0 +1,155 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeaders(t *testing.T) {
+	xray := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+	c, err := ParseXRay(xray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TraceID.String() != "5759e988bd862e3fe1be46a994272793" || c.ParentID.String() != "53995c3f42cd8ad8" || !c.Sampled {
+		t.Fatal("Unexpected context", c)
+	}
+	if c.XRay() != xray {
+		t.Fatal("Unexpected X-Ray header", c.XRay())
+	}
+	if c.Traceparent() != "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01" {
+		t.Fatal("Unexpected traceparent", c.Traceparent())
+	}
+
+	c2, err := ParseTraceparent(c.Traceparent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 != c {
+		t.Fatal("Expected the same context from both headers", c2)
+	}
+
+	c, err = ParseTraceparent("00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-00")
+	if err != nil || c.Sampled {
+		t.Fatal("Expected unsampled context", c, err)
+	}
+
+	for _, h := range []string{"", "Parent=53995c3f42cd8ad8", "Root=1-5759e988-bd86", "Root=2-5759e988-bd862e3fe1be46a994272793"} {
+		if _, err := ParseXRay(h); err == nil {
+			t.Fatal("Expected error for X-Ray header", h)
+		}
+	}
+	for _, h := range []string{"", "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8", "00-00000000000000000000000000000000-53995c3f42cd8ad8-01", "ff-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01"} {
+		if _, err := ParseTraceparent(h); err == nil {
+			t.Fatal("Expected error for traceparent", h)
+		}
+	}
+
+	h := http.Header{}
+	h.Set(TraceparentHeader, "00-5759e988bd862e3fe1be46a994272793-53995c3f42cd8ad8-01")
+	if c, ok := FromHeaders(h); !ok || c.TraceID.String() != "5759e988bd862e3fe1be46a994272793" {
+		t.Fatal("Expected context from traceparent", c, ok)
+	}
+	if _, ok := FromHeaders(http.Header{}); ok {
+		t.Fatal("Expected no context without headers")
+	}
+}
+
+func TestNew(t *testing.T) {
+	c := New()
+	if !c.Sampled || !c.ParentID.isZero() {
+		t.Fatal("Unexpected new context", c)
+	}
+	parsed, err := ParseXRay(c.XRay())
+	if err != nil || parsed != c {
+		t.Fatal("Could not parse new context", c.XRay(), err)
+	}
+	epoch := int64(c.TraceID[0])<<24 | int64(c.TraceID[1])<<16 | int64(c.TraceID[2])<<8 | int64(c.TraceID[3])
+	if d := time.Now().Unix() - epoch; d < 0 || d > 5 {
+		t.Fatal("Expected the trace ID to start with the time", c.TraceID)
+	}
+}
+
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != otlpTracesPath || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	c.mu.Unlock()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	c := &collector{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	e, err := NewOTLPExporter(ts.URL, OTLPOptions{ServiceName: "test", FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := New()
+	root := Start(e, parent, "invoke")
+	root.SetAttribute("function", "user/fn")
+	child := root.Child("handler")
+	child.Finish(errors.New("Exited with code 1"))
+	root.Finish(nil)
+
+	unsampled := parent
+	unsampled.Sampled = false
+	Start(e, unsampled, "dropped").Finish(nil)
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.requests) != 1 {
+		t.Fatal("Expected one request on close", len(c.requests))
+	}
+	rs := c.requests[0].ResourceSpans[0]
+	if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.StringValue != "test" {
+		t.Fatal("Unexpected resource", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatal("Expected the sampled spans", spans)
+	}
+
+	handler, invoke := spans[0], spans[1]
+	if invoke.Name != "invoke" || invoke.TraceID != parent.TraceID.String() || invoke.ParentSpanID != "" || invoke.Status.Code != 0 {
+		t.Fatal("Unexpected root span", invoke)
+	}
+	if len(invoke.Attributes) != 1 || invoke.Attributes[0].Key != "function" || invoke.Attributes[0].Value.StringValue != "user/fn" {
+		t.Fatal("Unexpected attributes", invoke.Attributes)
+	}
+	if handler.Name != "handler" || handler.TraceID != invoke.TraceID || handler.ParentSpanID != invoke.SpanID {
+		t.Fatal("Expected handler to be a child of invoke", handler)
+	}
+	if handler.Status.Code != otlpStatusError || handler.Status.Message != "Exited with code 1" {
+		t.Fatal("Expected error status", handler.Status)
+	}
+	if handler.StartTimeUnixNano == "" || handler.EndTimeUnixNano < handler.StartTimeUnixNano {
+		t.Fatal("Unexpected times", handler)
+	}
+
+	if _, err := NewOTLPExporter("localhost:4318", OTLPOptions{}); err == nil {
+		t.Fatal("Expected error for endpoint without scheme")
+	}
+}
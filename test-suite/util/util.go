@@ -96,7 +96,7 @@ func MakeImage(dir string, desc *TestDescription, imageNameVersion string) error
 		return errors.New("One of the files MUST be test-build.jar for Java tests.")
 	}
 
-	opts := iron_lambda.CreateImageOptions{imageNameVersion, "iron/lambda-" + desc.Runtime, "", desc.Handler, os.Stdout, false}
+	opts := iron_lambda.CreateImageOptions{Name: imageNameVersion, Base: "iron/lambda-" + desc.Runtime, Handler: desc.Handler, OutputStream: os.Stdout}
 	// FIXME(nikhil): Use some configuration username.
 	if desc.Runtime == "java8" {
 		opts.Package = "test-build.jar"